@@ -0,0 +1,107 @@
+package combine
+
+import (
+	"fmt"
+	"go/token"
+	"strings"
+)
+
+// packageDirective names the generated package of a command regardless of
+// the package name template, e.g. //combiner:package server.
+const packageDirective = "//combiner:package"
+
+// commandDirective sets the name a command is dispatched by regardless of
+// its directory, e.g. //combiner:command admin.
+const commandDirective = "//combiner:command"
+
+// includeDirective marks a command to be combined with WithMarker, e.g.
+// //combiner:include.
+const includeDirective = "//combiner:include"
+
+// directive returns the argument of the first comment in src that is the
+// given directive, and where it is.
+func (s *sourceFile) directive(directive string) (string, token.Position, bool) {
+	for _, group := range s.file.Comments {
+		for _, comment := range group.List {
+			rest := strings.TrimPrefix(comment.Text, directive)
+			if rest == comment.Text || rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+				continue
+			}
+
+			return strings.TrimSpace(rest), s.fset.Position(comment.Pos()), true
+		}
+	}
+
+	return "", token.Position{}, false
+}
+
+// marked reports whether a non-test file of m has an includeDirective.
+func (m *MainPackage) marked() bool {
+	for _, src := range m.nonTestSources() {
+		if _, _, ok := src.directive(includeDirective); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// packageOverride returns the package name set by a packageDirective in a
+// non-test file of m, or "" if there is none. Only one file may set it.
+func (m *MainPackage) packageOverride() (string, error) {
+	name, pos, err := m.override(packageDirective, "package name")
+	if err != nil || name == "" {
+		return "", err
+	}
+
+	if !token.IsIdentifier(name) || name == "_" || name == "main" {
+		return "", fmt.Errorf("%s: %q is not a valid package name", pos, name)
+	}
+
+	return name, nil
+}
+
+// commandOverride returns the command name set by a commandDirective in a
+// non-test file of m, or "" if there is none. Only one file may set it.
+func (m *MainPackage) commandOverride() (string, error) {
+	name, pos, err := m.override(commandDirective, "command name")
+	if err != nil || name == "" {
+		return "", err
+	}
+
+	if strings.ContainsAny(name, "/\\ \t") || name == "." || name == ".." {
+		return "", fmt.Errorf("%s: %q is not a valid command name", pos, name)
+	}
+
+	return name, nil
+}
+
+// override returns the argument of directive in a non-test file of m, and
+// where it is, or "" if there is none. what describes the argument in the
+// error returned if several files have the directive.
+func (m *MainPackage) override(directive string, what string) (string, token.Position, error) {
+	var (
+		value string
+		at    token.Position
+		found bool
+	)
+
+	for _, src := range m.nonTestSources() {
+		arg, pos, ok := src.directive(directive)
+		if !ok {
+			continue
+		}
+
+		if found {
+			return "", token.Position{}, fmt.Errorf("%s: %s of %s is already set at %s", pos, what, m.key, at)
+		}
+
+		if arg == "" {
+			return "", token.Position{}, fmt.Errorf("%s: %s needs a %s", pos, directive, what)
+		}
+
+		value, at, found = arg, pos, true
+	}
+
+	return value, at, nil
+}
@@ -0,0 +1,314 @@
+package combine
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPackageAnnotation(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  map[string]string
+		err   string
+	}{
+		{
+			name:  "override",
+			files: map[string]string{"cmd/server/main.go": packageDirective + " api\n\n" + mainFile("server")},
+			want:  map[string]string{"server": "api", "worker": "cmd_worker"},
+		},
+		{
+			name: "override in a helper file",
+			files: map[string]string{
+				"cmd/server/main.go":   mainFile("server"),
+				"cmd/server/helper.go": "package main\n\n" + packageDirective + " api\n",
+			},
+			want: map[string]string{"server": "api", "worker": "cmd_worker"},
+		},
+		{
+			name:  "override in a test file",
+			files: map[string]string{"cmd/server/main_test.go": packageDirective + " api\n\npackage main\n"},
+			want:  map[string]string{"server": "cmd_server", "worker": "cmd_worker"},
+		},
+		{
+			name: "set twice",
+			files: map[string]string{
+				"cmd/server/main.go":   packageDirective + " api\n\n" + mainFile("server"),
+				"cmd/server/helper.go": packageDirective + " other\n\npackage main\n",
+			},
+			err: "package name of cmd/server is already set at ",
+		},
+		{
+			name: "same package twice",
+			files: map[string]string{
+				"cmd/server/main.go": packageDirective + " api\n\n" + mainFile("server"),
+				"cmd/worker/main.go": packageDirective + " api\n\n" + mainFile("worker"),
+			},
+			err: "directories cmd/server and cmd/worker both generate package api",
+		},
+		{
+			name:  "main",
+			files: map[string]string{"cmd/server/main.go": packageDirective + " main\n\n" + mainFile("server")},
+			err:   `"main" is not a valid package name`,
+		},
+		{
+			name:  "missing name",
+			files: map[string]string{"cmd/server/main.go": packageDirective + "\n\n" + mainFile("server")},
+			err:   packageDirective + " needs a package name",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{
+				"cmd/server/main.go": mainFile("server"),
+				"cmd/worker/main.go": mainFile("worker"),
+			}
+			for name, data := range tt.files {
+				files[name] = data
+			}
+
+			dir := newModule(t, files)
+
+			c := newCombiner(t, dir)
+
+			err := c.Collect()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			generated, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, m := range c.packages {
+				if want := tt.want[m.Command]; m.PackageName != want {
+					t.Fatalf("expected %s in package %s, got %s", m.Command, want, m.PackageName)
+				}
+
+				if _, ok := generated[m.PackageName+"/main.go"]; !ok {
+					t.Fatalf("%s was not written to %s", m.Command, m.PackageName)
+				}
+			}
+
+			binary := buildBinary(t, c)
+
+			for command := range tt.want {
+				if out, code := runBinary(t, binary, command); code != 0 || out != command+"\n" {
+					t.Fatalf("expected %s to print its name, got %q and exit code %d", command, out, code)
+				}
+			}
+		})
+	}
+}
+
+func TestMarker(t *testing.T) {
+	files := map[string]string{
+		"cmd/marked/main.go":        includeDirective + "\n\n" + mainFile("marked"),
+		"cmd/helper/main.go":        mainFile("helper"),
+		"cmd/helper/helper.go":      "package main\n\n" + includeDirective + " because it is ready\n",
+		"cmd/body/main.go":          "package main\n\nimport \"fmt\"\n\nfunc main() {\n\t" + includeDirective + "\n\tfmt.Println(\"body\")\n}\n",
+		"cmd/unmarked/main.go":      mainFile("unmarked"),
+		"cmd/testonly/main.go":      mainFile("testonly"),
+		"cmd/testonly/main_test.go": "package main\n\n" + includeDirective + "\n",
+		"cmd/lookalike/main.go":     includeDirective + "d\n\n" + mainFile("lookalike"),
+		"cmd/block/main.go":         "/* " + includeDirective + " */\n\n" + mainFile("block"),
+	}
+
+	tests := []struct {
+		name   string
+		marker bool
+		want   []string
+	}{
+		{name: "marker", marker: true, want: []string{"body", "helper", "marked"}},
+		{name: "disabled", want: []string{"block", "body", "helper", "lookalike", "marked", "testonly", "unmarked"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, files)
+
+			c := collected(t, dir, WithMarker(tt.marker))
+
+			if got := commandNames(c); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected commands %v, got %v", tt.want, got)
+			}
+
+			commands, err := c.Discover()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(commands) != len(tt.want) {
+				t.Fatalf("expected Discover to find %d commands, got %+v", len(tt.want), commands)
+			}
+
+			binary := buildBinary(t, c)
+
+			for _, name := range tt.want {
+				if out, code := runBinary(t, binary, name); code != 0 || out != name+"\n" {
+					t.Fatalf("expected %s to print its name, got %q and exit code %d", name, out, code)
+				}
+			}
+		})
+	}
+}
+
+func TestCommandAnnotation(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []Option
+		files map[string]string
+		// want maps the commands to their packages
+		want map[string]string
+		err  string
+		dup  *DuplicateCommandError
+	}{
+		{
+			name:  "override",
+			files: map[string]string{"cmd/internal-admin-tool/main.go": commandDirective + " admin\n\n" + mainFile("admin")},
+			want:  map[string]string{"admin": "cmd_internal_admin_tool", "server": "cmd_server"},
+		},
+		{
+			name: "override in a helper file",
+			files: map[string]string{
+				"cmd/internal-admin-tool/main.go":   mainFile("admin"),
+				"cmd/internal-admin-tool/helper.go": "package main\n\n" + commandDirective + " admin\n",
+			},
+			want: map[string]string{"admin": "cmd_internal_admin_tool", "server": "cmd_server"},
+		},
+		{
+			name: "override in a test file",
+			files: map[string]string{
+				"cmd/internal-admin-tool/main.go":      mainFile("internal-admin-tool"),
+				"cmd/internal-admin-tool/main_test.go": commandDirective + " admin\n\npackage main\n",
+			},
+			want: map[string]string{"internal-admin-tool": "cmd_internal_admin_tool", "server": "cmd_server"},
+		},
+		{
+			name: "with a package override",
+			files: map[string]string{
+				"cmd/internal-admin-tool/main.go": commandDirective + " admin\n" + packageDirective + " tool\n\n" + mainFile("admin"),
+			},
+			want: map[string]string{"admin": "tool", "server": "cmd_server"},
+		},
+		{
+			name: "override resolves a duplicate",
+			files: map[string]string{
+				"tools/server/main.go": commandDirective + " tools-server\n\n" + mainFile("tools-server"),
+			},
+			want: map[string]string{"server": "cmd_server", "tools-server": "tools_server"},
+		},
+		{
+			name:  "duplicate of a directory",
+			files: map[string]string{"cmd/internal-admin-tool/main.go": commandDirective + " server\n\n" + mainFile("server")},
+			dup:   &DuplicateCommandError{Command: "server", Dirs: []string{"cmd/internal-admin-tool", "cmd/server"}},
+		},
+		{
+			name:  "duplicate with duplicates allowed",
+			opts:  []Option{WithAllowDuplicateCommands(true)},
+			files: map[string]string{"cmd/internal-admin-tool/main.go": commandDirective + " server\n\n" + mainFile("server")},
+			dup:   &DuplicateCommandError{Command: "server", Dirs: []string{"cmd/internal-admin-tool", "cmd/server"}},
+		},
+		{
+			name: "duplicate of another override",
+			files: map[string]string{
+				"cmd/internal-admin-tool/main.go": commandDirective + " admin\n\n" + mainFile("admin"),
+				"tools/admin-v2/main.go":          commandDirective + " admin\n\n" + mainFile("admin"),
+			},
+			dup: &DuplicateCommandError{Command: "admin", Dirs: []string{"cmd/internal-admin-tool", "tools/admin-v2"}},
+		},
+		{
+			name: "set twice",
+			files: map[string]string{
+				"cmd/internal-admin-tool/main.go":   commandDirective + " admin\n\n" + mainFile("admin"),
+				"cmd/internal-admin-tool/helper.go": commandDirective + " other\n\npackage main\n",
+			},
+			err: "command name of cmd/internal-admin-tool is already set at ",
+		},
+		{
+			name:  "path",
+			files: map[string]string{"cmd/internal-admin-tool/main.go": commandDirective + " admin/tool\n\n" + mainFile("admin")},
+			err:   `"admin/tool" is not a valid command name`,
+		},
+		{
+			name:  "missing name",
+			files: map[string]string{"cmd/internal-admin-tool/main.go": commandDirective + "\n\n" + mainFile("admin")},
+			err:   commandDirective + " needs a command name",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{"cmd/server/main.go": mainFile("server")}
+			for name, data := range tt.files {
+				files[name] = data
+			}
+
+			c := newCombiner(t, newModule(t, files), tt.opts...)
+
+			err := c.Collect()
+
+			switch {
+			case tt.dup != nil:
+				var dup *DuplicateCommandError
+				if !errors.As(err, &dup) || !reflect.DeepEqual(dup, tt.dup) {
+					t.Fatalf("expected %v, got %v", tt.dup, err)
+				}
+
+				return
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+
+				return
+			case err != nil:
+				t.Fatal(err)
+			}
+
+			got := make(map[string]string)
+			for _, m := range c.packages {
+				got[m.Command] = m.PackageName
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected commands and packages %v, got %v", tt.want, got)
+			}
+
+			// Discover reports the same names
+			commands, err := c.Discover()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			discovered := make(map[string]string)
+			for _, command := range commands {
+				discovered[command.Command] = tt.want[command.Command]
+			}
+
+			if !reflect.DeepEqual(discovered, tt.want) {
+				t.Fatalf("expected Discover to find %v, got %v", tt.want, commands)
+			}
+
+			binary := buildBinary(t, c)
+
+			for command := range tt.want {
+				if out, code := runBinary(t, binary, command); code != 0 || out != command+"\n" {
+					t.Fatalf("expected %s to print its name, got %q and exit code %d", command, out, code)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package combine
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// previousCommands returns the commands of the existing dispatcher in the
+// output directory that are not collected by this run, in append mode. A
+// command whose generated package is collected again is replaced by it, but
+// one whose name is taken by a different package is a
+// *DuplicateCommandError. The commands are read from the switch on the
+// command name, so the dispatcher must have been generated by main-combiner
+// with switch based dispatch.
+func (c *Combiner) previousCommands(outputs []*MainPackage) ([]*MainPackage, error) {
+	if !c.appendCommands {
+		return nil, nil
+	}
+
+	filename := filepath.Join(c.outputDir, c.dispatcherFilename)
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	fset := token.NewFileSet()
+
+	f, err := parser.ParseFile(fset, filename, data, parser.ParseComments)
+	if err != nil {
+		return nil, &ParseError{File: filename, Err: err}
+	}
+
+	src := &sourceFile{filename: filename, fset: fset, file: f, data: data}
+	if !src.isGenerated() {
+		return nil, fmt.Errorf("can't append to %s, which was not generated by main-combiner", filename)
+	}
+
+	previous, err := c.dispatchedCommands(src)
+	if err != nil {
+		return nil, err
+	}
+
+	byImportPath := make(map[string]*MainPackage)
+	byCommand := make(map[string]*MainPackage)
+
+	for _, m := range outputs {
+		byImportPath[m.ImportPath] = m
+		byCommand[m.Command] = m
+	}
+
+	var kept []*MainPackage
+
+	for _, p := range previous {
+		if byImportPath[p.ImportPath] != nil {
+			continue
+		}
+
+		if m := byCommand[p.Command]; m != nil {
+			dirs := []string{m.key, p.ImportPath}
+			sort.Strings(dirs)
+
+			return nil, &DuplicateCommandError{Command: p.Command, Dirs: dirs}
+		}
+
+		if _, err := os.Stat(p.OutputDir); err != nil {
+			return nil, fmt.Errorf("command %s of %s runs %s, which can't be appended to: %w", p.Command, filename, p.ImportPath, err)
+		}
+
+		c.logf(1, "keeping command %s from %s", p.Command, filename)
+
+		kept = append(kept, p)
+	}
+
+	return kept, nil
+}
+
+// dispatchedCommands returns the commands of the cases of the switch on
+// the command name in the dispatcher src. The source of each command is
+// read from the comment above its case, if there is one.
+func (c *Combiner) dispatchedCommands(src *sourceFile) ([]*MainPackage, error) {
+	imports := make(map[string]string)
+
+	for _, spec := range src.file.Imports {
+		p := importPath(spec)
+
+		name := path.Base(p)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+
+		imports[name] = p
+	}
+
+	var sw *ast.SwitchStmt
+
+	ast.Inspect(src.file, func(n ast.Node) bool {
+		if s, ok := n.(*ast.SwitchStmt); ok && sw == nil {
+			if tag, ok := s.Tag.(*ast.Ident); ok && tag.Name == "name" {
+				sw = s
+			}
+		}
+
+		return sw == nil
+	})
+
+	if sw == nil {
+		return nil, fmt.Errorf("can't append to %s, which has no switch on the command name", src.filename)
+	}
+
+	var commands []*MainPackage
+
+	for _, stmt := range sw.Body.List {
+		clause := stmt.(*ast.CaseClause)
+		if len(clause.List) != 1 {
+			continue
+		}
+
+		lit, ok := clause.List[0].(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			continue
+		}
+
+		command, err := strconv.Unquote(lit.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid command name %s", src.fset.Position(lit.Pos()), lit.Value)
+		}
+
+		// the list command calls a function of the dispatcher itself
+		call := entrypointCall(clause, c.entrypointName)
+		if call == nil {
+			if command != c.listCommandName(filepath.Base(c.outputDir)) {
+				c.logf(0, "warning: %s: case %q calls no %s and is dropped", src.fset.Position(clause.Pos()), command, c.entrypointName)
+			}
+
+			continue
+		}
+
+		packageName := call.Fun.(*ast.SelectorExpr).X.(*ast.Ident).Name
+
+		importPath, ok := imports[packageName]
+		if !ok {
+			return nil, fmt.Errorf("%s: %s is not an imported package", src.fset.Position(call.Pos()), packageName)
+		}
+
+		sourceImportPath := importPath
+
+		for _, cg := range src.file.Comments {
+			if src.fset.Position(cg.End()).Line != src.fset.Position(clause.Pos()).Line-1 {
+				continue
+			}
+
+			if text := strings.TrimPrefix(cg.Text(), "from "); text != cg.Text() {
+				if i := strings.Index(text, ", generated as "); i > 0 {
+					sourceImportPath = text[:i]
+				}
+			}
+		}
+
+		commands = append(commands, &MainPackage{
+			Command:     command,
+			SourceDir:   ".",
+			ImportPath:  importPath,
+			PackageName: packageName,
+			OutputDir:   filepath.Join(c.outputDir, packageName),
+			Module:      sourceImportPath,
+			Contents:    make(map[string][]byte),
+			Embedded:    make(map[string][]byte),
+			Copied:      make(map[string][]byte),
+			key:         importPath,
+			context:     len(call.Args) > 0,
+		})
+	}
+
+	return commands, nil
+}
+
+// entrypointCall returns the call of a function called entrypoint of an
+// imported package in clause, or nil.
+func entrypointCall(clause *ast.CaseClause, entrypoint string) *ast.CallExpr {
+	for _, stmt := range clause.Body {
+		expr, ok := stmt.(*ast.ExprStmt)
+		if !ok {
+			continue
+		}
+
+		call, ok := expr.X.(*ast.CallExpr)
+		if !ok {
+			continue
+		}
+
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || sel.Sel.Name != entrypoint {
+			continue
+		}
+
+		if _, ok := sel.X.(*ast.Ident); ok {
+			return call
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,190 @@
+package combine
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAppend(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		// first and second are the directories included by each run
+		first  []string
+		second []string
+		// server replaces the source of the server command before the
+		// second run
+		server string
+		want   map[string]string
+		// commands is the number of commands in the summary
+		commands int
+		dup      *DuplicateCommandError
+	}{
+		{
+			name:     "second command",
+			first:    []string{"cmd/server"},
+			second:   []string{"tools/worker"},
+			want:     map[string]string{"server": "server\n", "worker": "worker\n"},
+			commands: 2,
+		},
+		{
+			name:     "subcommand dispatch",
+			opts:     []Option{WithDispatch(DispatchSubcommand)},
+			first:    []string{"cmd/server"},
+			second:   []string{"tools/worker"},
+			want:     map[string]string{"server": "server\n", "worker": "worker\n"},
+			commands: 2,
+		},
+		{
+			name:     "list command",
+			opts:     []Option{WithEmitListCommand(true)},
+			first:    []string{"cmd/server"},
+			second:   []string{"tools/worker"},
+			want:     map[string]string{"server": "server\n", "worker": "worker\n", "combined-list": "server\nworker\n"},
+			commands: 2,
+		},
+		{
+			name:     "collected again",
+			first:    []string{"cmd/server", "tools/worker"},
+			second:   []string{"cmd/server"},
+			server:   mainFile("new server"),
+			want:     map[string]string{"server": "new server\n", "worker": "worker\n"},
+			commands: 2,
+		},
+		{
+			name:     "first run",
+			second:   []string{"tools/worker"},
+			want:     map[string]string{"worker": "worker\n"},
+			commands: 1,
+		},
+		{
+			name:   "same name",
+			first:  []string{"cmd/server"},
+			second: []string{"tools/server"},
+			dup:    &DuplicateCommandError{Command: "server", Dirs: []string{testModule + "/cmd/combined/cmd_server", "tools/server"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go":   mainFile("server"),
+				"tools/worker/main.go": mainFile("worker"),
+				"tools/server/main.go": mainFile("tools server"),
+			})
+
+			if tt.first != nil {
+				c := collected(t, dir, append([]Option{WithInclude(tt.first...)}, tt.opts...)...)
+				if err := c.Write(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if tt.server != "" {
+				writeFiles(t, dir, map[string]string{"cmd/server/main.go": tt.server})
+			}
+
+			c := collected(t, dir, append([]Option{WithInclude(tt.second...), WithAppend(true)}, tt.opts...)...)
+
+			_, err := c.Generate()
+			if tt.dup != nil {
+				var dup *DuplicateCommandError
+				if !errors.As(err, &dup) || !reflect.DeepEqual(dup, tt.dup) {
+					t.Fatalf("expected %v, got %v", tt.dup, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			binary := buildBinary(t, c)
+
+			if got := c.Summary().Commands; got != tt.commands {
+				t.Fatalf("expected %d commands in the summary, got %d", tt.commands, got)
+			}
+
+			for name, want := range tt.want {
+				var out string
+				var code int
+
+				if c.dispatch == DispatchSubcommand {
+					out, code = runBinary(t, binary, "", name)
+				} else {
+					out, code = runBinary(t, binary, name)
+				}
+
+				if code != 0 || out != want {
+					t.Fatalf("expected %s to print %q, got %q and exit code %d", name, want, out, code)
+				}
+			}
+
+			// appending again with nothing new leaves the dispatcher as it is
+			before, err := ioutil.ReadFile(filepath.Join(c.outputDir, "main.go"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			again := collected(t, dir, append([]Option{WithInclude(tt.second...), WithAppend(true)}, tt.opts...)...)
+
+			generated, err := again.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(generated["main.go"]) != string(before) {
+				t.Fatalf("expected the same dispatcher when appending twice, got:\n%s\nthen:\n%s", before, generated["main.go"])
+			}
+		})
+	}
+
+	t.Run("without append", func(t *testing.T) {
+		dir := newModule(t, map[string]string{
+			"cmd/server/main.go":   mainFile("server"),
+			"tools/worker/main.go": mainFile("worker"),
+		})
+
+		if err := collected(t, dir, WithInclude("cmd/server")).Write(); err != nil {
+			t.Fatal(err)
+		}
+
+		binary := buildBinary(t, collected(t, dir, WithInclude("tools/worker")))
+
+		if _, code := runBinary(t, binary, "server"); code == 0 {
+			t.Fatal("expected server to be replaced without WithAppend")
+		}
+	})
+
+	t.Run("not generated", func(t *testing.T) {
+		dir := newModule(t, map[string]string{
+			"cmd/server/main.go":   mainFile("server"),
+			"cmd/combined/main.go": mainFile("combined"),
+		})
+
+		_, err := collected(t, dir, WithInclude("cmd/server"), WithAppend(true)).Generate()
+		if err == nil || !strings.Contains(err.Error(), "which was not generated by main-combiner") {
+			t.Fatalf("expected an error appending to a dispatcher written by hand, got %v", err)
+		}
+	})
+
+	for name, opt := range map[string]Option{
+		"registry": WithDispatch(DispatchRegistry),
+		"group by": WithGroupBy(1),
+		"prune":    WithPrune(true),
+		"go.mod":   WithEmitGoMod(true),
+	} {
+		t.Run("with "+name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{"cmd/server/main.go": mainFile("server")})
+
+			if _, err := New(dir, "cmd/combined", WithAppend(true), opt); err == nil || !strings.Contains(err.Error(), "append") {
+				t.Fatalf("expected appending with %s to be rejected, got %v", name, err)
+			}
+		})
+	}
+}
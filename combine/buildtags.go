@@ -0,0 +1,279 @@
+package combine
+
+import (
+	"fmt"
+	"go/build/constraint"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// knownOS and knownArch are the GOOS and GOARCH values, which also appear
+// as file name suffixes such as _linux_amd64.go.
+var knownOS = map[string]bool{
+	"aix": true, "android": true, "darwin": true, "dragonfly": true,
+	"freebsd": true, "hurd": true, "illumos": true, "ios": true, "js": true,
+	"linux": true, "nacl": true, "netbsd": true, "openbsd": true,
+	"plan9": true, "solaris": true, "wasip1": true, "windows": true,
+	"zos": true,
+}
+
+var knownArch = map[string]bool{
+	"386": true, "amd64": true, "amd64p32": true, "arm": true, "armbe": true,
+	"arm64": true, "arm64be": true, "loong64": true, "mips": true,
+	"mipsle": true, "mips64": true, "mips64le": true, "mips64p32": true,
+	"mips64p32le": true, "ppc": true, "ppc64": true, "ppc64le": true,
+	"riscv": true, "riscv64": true, "s390": true, "s390x": true,
+	"sparc": true, "sparc64": true, "wasm": true,
+}
+
+// unixOS are the GOOS values matched by the unix tag.
+var unixOS = map[string]bool{
+	"aix": true, "android": true, "darwin": true, "dragonfly": true,
+	"freebsd": true, "hurd": true, "illumos": true, "ios": true,
+	"linux": true, "netbsd": true, "openbsd": true, "solaris": true,
+}
+
+// impliedOS maps GOOS values to the GOOS tag they also satisfy.
+var impliedOS = map[string]string{
+	"android": "linux",
+	"illumos": "solaris",
+	"ios":     "darwin",
+}
+
+var goVersionTag = regexp.MustCompile(`^go1\.[0-9]+$`)
+
+// isBuildTimeTag reports whether tag is decided by the environment the
+// combined binary is built in. Constraints on these tags are left to the go
+// tool, and only the remaining tags are evaluated against WithBuildTags.
+func isBuildTimeTag(tag string) bool {
+	switch tag {
+	case "unix", "cgo", "gc", "gccgo":
+		return true
+	}
+
+	return knownOS[tag] || knownArch[tag] || goVersionTag.MatchString(tag) || strings.HasPrefix(tag, "goexperiment.")
+}
+
+// ignoreTag is conventionally used to keep a file out of every build, as
+// in //go:build ignore, and is never considered set.
+const ignoreTag = "ignore"
+
+// matchesTags reports whether the build constraints of src can be
+// satisfied when exactly the given tags are set. Build time tags such as
+// GOOS and GOARCH values may take any value.
+func (s *sourceFile) matchesTags(tags map[string]bool) (bool, error) {
+	expr, err := s.constraint()
+	if err != nil || expr == nil {
+		return true, err
+	}
+
+	return satisfiable(expr, isBuildTimeTag, func(tag string) bool {
+		return tags[tag]
+	}), nil
+}
+
+// ignored reports whether the build constraints of src can only be
+// satisfied with the ignore tag set, like those of generators run with go
+// run that share the directory of a command.
+func (s *sourceFile) ignored() (bool, error) {
+	expr, err := s.constraint()
+	if err != nil || expr == nil {
+		return false, err
+	}
+
+	return !satisfiable(expr, func(tag string) bool {
+		return tag != ignoreTag
+	}, func(string) bool {
+		return false
+	}), nil
+}
+
+// satisfiable reports whether expr is satisfied for some value of the tags
+// that are free, when every other tag has the given value.
+func satisfiable(expr constraint.Expr, free func(tag string) bool, value func(tag string) bool) bool {
+	var tags []string
+
+	seen := make(map[string]bool)
+	expr.Eval(func(tag string) bool {
+		if free(tag) && !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+
+		return false
+	})
+
+	// constraints are tiny, so trying every assignment of the free tags is
+	// cheap
+	if len(tags) > 16 {
+		return true
+	}
+
+	for assignment := 0; assignment < 1<<len(tags); assignment++ {
+		set := make(map[string]bool)
+		for i, tag := range tags {
+			set[tag] = assignment&(1<<i) != 0
+		}
+
+		ok := expr.Eval(func(tag string) bool {
+			if free(tag) {
+				return set[tag]
+			}
+
+			return value(tag)
+		})
+
+		if ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// constraint returns the build constraint of src, preferring //go:build
+// over // +build lines as the go tool does. It returns nil if src has no
+// constraints.
+func (s *sourceFile) constraint() (constraint.Expr, error) {
+	var plus constraint.Expr
+
+	for _, line := range s.constraints {
+		expr, err := constraint.Parse(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid build constraint in %s: %w", s.filename, err)
+		}
+
+		if constraint.IsGoBuild(line) {
+			return expr, nil
+		}
+
+		if plus == nil {
+			plus = expr
+		} else {
+			plus = &constraint.AndExpr{X: plus, Y: expr}
+		}
+	}
+
+	return plus, nil
+}
+
+// fileNameConstraint returns the constraint implied by a GOOS or GOARCH
+// suffix of filename, such as _linux.go, or nil.
+func fileNameConstraint(filename string) constraint.Expr {
+	name := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(filename), ".go"), "_test")
+
+	// the part before the first _ is never a constraint
+	i := strings.Index(name, "_")
+	if i < 0 {
+		return nil
+	}
+
+	l := strings.Split(name[i+1:], "_")
+	n := len(l)
+
+	switch {
+	case n >= 2 && knownOS[l[n-2]] && knownArch[l[n-1]]:
+		return &constraint.AndExpr{X: &constraint.TagExpr{Tag: l[n-2]}, Y: &constraint.TagExpr{Tag: l[n-1]}}
+	case knownOS[l[n-1]] || knownArch[l[n-1]]:
+		return &constraint.TagExpr{Tag: l[n-1]}
+	}
+
+	return nil
+}
+
+// buildableTogether reports whether some build includes every one of
+// sources, given their build constraints and file names.
+func buildableTogether(sources ...*sourceFile) (bool, error) {
+	var exprs []constraint.Expr
+
+	for _, src := range sources {
+		expr, err := src.constraint()
+		if err != nil {
+			return false, err
+		}
+
+		for _, x := range []constraint.Expr{expr, fileNameConstraint(src.filename)} {
+			if x != nil {
+				exprs = append(exprs, x)
+			}
+		}
+	}
+
+	// GOOS and GOARCH each take a single value; "" stands for any value
+	// not mentioned
+	goos := []string{"", "linux"}
+	goarch := []string{""}
+
+	var free []string
+
+	seen := make(map[string]bool)
+	for _, expr := range exprs {
+		expr.Eval(func(tag string) bool {
+			if seen[tag] {
+				return false
+			}
+
+			seen[tag] = true
+
+			switch {
+			case knownOS[tag]:
+				goos = append(goos, tag)
+
+				for value, implied := range impliedOS {
+					if implied == tag {
+						goos = append(goos, value)
+					}
+				}
+			case knownArch[tag]:
+				goarch = append(goarch, tag)
+			case tag != "unix":
+				free = append(free, tag)
+			}
+
+			return false
+		})
+	}
+
+	if len(free) > 16 {
+		return true, nil
+	}
+
+	for _, targetOS := range goos {
+		for _, targetArch := range goarch {
+			for assignment := 0; assignment < 1<<len(free); assignment++ {
+				set := make(map[string]bool)
+				for i, tag := range free {
+					set[tag] = assignment&(1<<i) != 0
+				}
+
+				ok := func(tag string) bool {
+					switch {
+					case knownOS[tag]:
+						return tag == targetOS || tag == impliedOS[targetOS]
+					case knownArch[tag]:
+						return tag == targetArch
+					case tag == "unix":
+						return unixOS[targetOS]
+					}
+
+					return set[tag]
+				}
+
+				all := true
+				for _, expr := range exprs {
+					if !expr.Eval(ok) {
+						all = false
+						break
+					}
+				}
+
+				if all {
+					return true, nil
+				}
+			}
+		}
+	}
+
+	return false, nil
+}
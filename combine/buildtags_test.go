@@ -0,0 +1,234 @@
+package combine
+
+import (
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestBuildTags(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []string
+		want []string
+	}{
+		{
+			name: "no tags",
+			tags: nil,
+			want: []string{"cmd_server/debug.go", "cmd_server/linux.go", "cmd_server/main.go", "cmd_server/release.go"},
+		},
+		{
+			name: "tag set",
+			tags: []string{"debug"},
+			want: []string{"cmd_server/debug.go", "cmd_server/main.go"},
+		},
+		{
+			name: "tag not set",
+			tags: []string{"other"},
+			want: []string{"cmd_server/linux.go", "cmd_server/main.go", "cmd_server/release.go"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go":    mainFile("server"),
+				"cmd/server/debug.go":   "//go:build debug\n\npackage main\n\nconst mode = \"debug\"\n",
+				"cmd/server/release.go": "//go:build !debug\n\npackage main\n\nconst mode = \"release\"\n",
+				"cmd/server/linux.go":   "//go:build linux && !debug\n\npackage main\n\nconst platform = \"linux\"\n",
+				"cmd/server/ignore.go":  "//go:build ignore\n\npackage main\n",
+			})
+
+			c := collected(t, dir, WithBuildTags(tt.tags...))
+
+			files, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+
+			for name := range files {
+				if strings.HasPrefix(name, "cmd_server/") {
+					got = append(got, name)
+				}
+			}
+
+			sort.Strings(got)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected files %q, got %q", tt.want, got)
+			}
+
+			buildOutput(t, c, tt.tags...)
+		})
+	}
+}
+
+func TestExcludedCommands(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+	}{
+		{
+			name:  "only file",
+			files: map[string]string{"cmd/tool/main.go": "//go:build tool\n\n" + mainFile("tool")},
+		},
+		{
+			name: "func main excluded",
+			files: map[string]string{
+				"cmd/tool/main.go":   "//go:build tool\n\n" + mainFile("tool"),
+				"cmd/tool/helper.go": "package main\n\nfunc helper() {}\n",
+			},
+		},
+		{
+			name: "test file left",
+			files: map[string]string{
+				"cmd/tool/main.go":      "//go:build tool\n\n" + mainFile("tool"),
+				"cmd/tool/main_test.go": "package main\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{"cmd/server/main.go": mainFile("server")}
+			for name, data := range tt.files {
+				files[name] = data
+			}
+
+			dir := newModule(t, files)
+
+			var logs strings.Builder
+
+			c := collected(t, dir, WithBuildTags("release"), WithLogger(log.New(&logs, "", 0), 0))
+
+			if got := commandNames(c); !reflect.DeepEqual(got, []string{"server"}) {
+				t.Fatalf("expected only server to be collected, got %v", got)
+			}
+
+			if logs.Len() != 0 {
+				t.Fatalf("expected no warnings, got:\n%s", logs.String())
+			}
+
+			generated, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if dispatcher := string(generated["main.go"]); strings.Contains(dispatcher, "tool") {
+				t.Fatalf("expected tool to be absent from the dispatcher:\n%s", dispatcher)
+			}
+
+			binary := buildBinary(t, c)
+
+			if out, code := runBinary(t, binary, "server"); code != 0 || out != "server\n" {
+				t.Fatalf("expected server to print its name, got %q and exit code %d", out, code)
+			}
+
+			if _, code := runBinary(t, binary, "tool"); code == 0 {
+				t.Fatal("expected tool to be an unknown command")
+			}
+		})
+	}
+}
+
+func TestIgnoredFiles(t *testing.T) {
+	const generator = "//go:build ignore\n\npackage main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"generator\")\n}\n"
+
+	tests := []struct {
+		name    string
+		options []Option
+		files   map[string]string
+		// want are the generated files of cmd_server
+		want     []string
+		commands []string
+	}{
+		{
+			name:     "generator",
+			files:    map[string]string{"cmd/server/gen.go": generator},
+			want:     []string{"cmd_server/helper.go", "cmd_server/main.go"},
+			commands: []string{"server"},
+		},
+		{
+			name:     "legacy constraint",
+			files:    map[string]string{"cmd/server/gen.go": strings.Replace(generator, "//go:build ignore", "// +build ignore", 1)},
+			want:     []string{"cmd_server/helper.go", "cmd_server/main.go"},
+			commands: []string{"server"},
+		},
+		{
+			name:     "ignore with another tag",
+			files:    map[string]string{"cmd/server/gen.go": strings.Replace(generator, "ignore", "ignore && linux", 1)},
+			want:     []string{"cmd_server/helper.go", "cmd_server/main.go"},
+			commands: []string{"server"},
+		},
+		{
+			name:     "ignore with build tags",
+			options:  []Option{WithBuildTags("release")},
+			files:    map[string]string{"cmd/server/gen.go": generator},
+			want:     []string{"cmd_server/helper.go", "cmd_server/main.go"},
+			commands: []string{"server"},
+		},
+		{
+			name: "tagged helper",
+			files: map[string]string{
+				"cmd/server/gen.go":    generator,
+				"cmd/server/linux.go":  "//go:build linux || !linux\n\npackage main\n\nfunc init() {}\n",
+				"cmd/server/ignore.go": "//go:build !ignore\n\npackage main\n\nfunc init() {}\n",
+			},
+			want:     []string{"cmd_server/helper.go", "cmd_server/ignore.go", "cmd_server/linux.go", "cmd_server/main.go"},
+			commands: []string{"server"},
+		},
+		{
+			name:     "only the generator",
+			files:    map[string]string{"cmd/tool/gen.go": generator, "cmd/tool/helper.go": "package main\n\nfunc helper() {}\n"},
+			want:     []string{"cmd_server/helper.go", "cmd_server/main.go"},
+			commands: []string{"server"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{
+				"cmd/server/main.go":   "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(name())\n}\n",
+				"cmd/server/helper.go": "package main\n\nfunc name() string {\n\treturn \"server\"\n}\n",
+			}
+			for name, data := range tt.files {
+				files[name] = data
+			}
+
+			c := collected(t, newModule(t, files), tt.options...)
+
+			if got := commandNames(c); !reflect.DeepEqual(got, tt.commands) {
+				t.Fatalf("expected commands %v, got %v", tt.commands, got)
+			}
+
+			generated, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for name := range generated {
+				if strings.HasPrefix(name, "cmd_server/") {
+					got = append(got, name)
+				}
+			}
+
+			sort.Strings(got)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected files %v, got %v", tt.want, got)
+			}
+
+			// a combined generator would be a second func main
+			binary := buildBinary(t, c)
+
+			if out, code := runBinary(t, binary, "server"); code != 0 || out != "server\n" {
+				t.Fatalf("expected server to print its name, got %q and exit code %d", out, code)
+			}
+		})
+	}
+}
@@ -0,0 +1,1571 @@
+// Package combine rewrites a tree of main packages into importable packages
+// and generates a single dispatcher binary that runs them by name.
+//
+// Commands using cgo are supported: the preamble above import "C" is kept
+// with the import, and the build fails early if it was not.
+//
+// A command can pin the name of its generated package, regardless of the
+// package name template, with a comment in any of its files:
+//
+//	//combiner:package server
+//
+// Similarly, a command can be dispatched by a name other than the base of
+// its directory, without changing its package name:
+//
+//	//combiner:command admin
+package combine
+
+import (
+	"errors"
+	"fmt"
+	"go/token"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// getModuleName returns the module path declared by the go.mod at the root
+// of fsys, which holds the contents of dir.
+func getModuleName(fsys fs.FS, dir string) (string, error) {
+	filename := filepath.Join(dir, goModName)
+
+	goModBytes, err := fs.ReadFile(fsys, goModName)
+	if err != nil {
+		return "", &ModuleError{Path: filename, Err: err}
+	}
+
+	modName := modfile.ModulePath(goModBytes)
+	if modName == "" {
+		return "", &ModuleError{Path: filename, Err: errNoModuleDirective}
+	}
+
+	return modName, nil
+}
+
+// DefaultUnknownExitCode is the exit code of the dispatcher when invoked as
+// an unknown command.
+const DefaultUnknownExitCode = 11
+
+// MainPackage is a main package discovered by Collect.
+type MainPackage struct {
+	// Command is the name the dispatcher matches to run this package.
+	Command string
+	// SourceDir is the slash separated directory of the original package,
+	// relative to the input directory it was found in.
+	SourceDir string
+	// ImportPath is the import path of the generated package.
+	ImportPath string
+	// PackageName is the name of the generated package.
+	PackageName string
+	// OutputDir is the directory the generated package is written to.
+	OutputDir string
+	// Module is the path of the module the original package belongs to.
+	Module string
+	// Contents maps each original file path to its transformed source.
+	Contents map[string][]byte
+	// Embedded maps the files embedded with //go:embed, as slash separated
+	// paths relative to the package directory, to their contents.
+	Embedded map[string][]byte
+	// Copied maps the files with an extension passed to
+	// WithCopyExtensions, as slash separated paths relative to the package
+	// directory, to their contents.
+	Copied map[string][]byte
+
+	// key identifies the package across inputs, see Packages
+	key string
+	// named is set if Command was set with a commandDirective
+	named   bool
+	sources []*sourceFile
+	// input is where the sources were found
+	input *input
+	// hash identifies the inputs of the package in incremental mode
+	hash string
+	// context is set when the main function takes a context.Context
+	context bool
+}
+
+// input is a directory containing a go.mod that commands are collected from.
+type input struct {
+	dir    string
+	module string
+	// fsys holds the contents of dir
+	fsys fs.FS
+	// os is set if fsys is the operating system's file system, so
+	// symlinks can be resolved and files above dir can be read
+	os bool
+	// primary is set for the service directory
+	primary bool
+}
+
+// Combiner collects main packages from a service directory and writes them,
+// along with a dispatcher, to an output directory.
+type Combiner struct {
+	serviceDir string
+	module     string
+	// workspaceGo is the go version of the service directory's go.work
+	workspaceGo string
+	outputDir   string
+	packages    map[string]*MainPackage
+	include     []string
+	exclude     []string
+	inputs      []*input
+	extraDirs   []string
+
+	packageNameTemplate *template.Template
+	packageNameText     string
+
+	dispatcherTemplate     *template.Template
+	dispatcherTemplateText string
+
+	allowDuplicateCommands bool
+	emitGoMod              bool
+	emitInstallScript      bool
+	emitDockerfile         bool
+	dispatch               Dispatch
+	prefixIdentifiers      bool
+	rename                 RenamePredicate
+	deferInit              bool
+	versionVar             string
+	emitListCommand        bool
+	emitCommands           bool
+	unknownExitCode        int
+	pruneStale             bool
+	appendCommands         bool
+	entrypointName         string
+	dispatcherFilename     string
+	incremental            bool
+	simplify               bool
+	buildTags              map[string]bool
+	contextEntrypoint      bool
+	summary                Summary
+	dispatched             int
+	importPrefix           string
+	followSymlinks         bool
+	singleFile             bool
+	respectGitignore       bool
+	completions            []Shell
+	skipCommands           map[string]bool
+	keepGoing              bool
+	parseErrors            []error
+	goBinary               string
+	lineDirectives         bool
+	commandPrefix          string
+	trimSuffixes           []string
+	standaloneTag          string
+	timings                Timings
+	fsys                   fs.FS
+	force                  bool
+	groupBy                int
+	maxDepth               int
+	defaultCommand         string
+	extraIgnore            []string
+	dirMode                os.FileMode
+	dispatcherPackage      string
+	prolog                 string
+	wrapper                bool
+	preserveArgv0          bool
+	progress               progress
+	progressInterval       time.Duration
+	epilog                 string
+	wrapImports            []string
+	fileMode               os.FileMode
+	alwaysIgnore           []string
+	extraCopyExtensions    []string
+	copyExtensions         map[string]bool
+	trapExit               bool
+	allowEmptyInclude      bool
+	allowEmpty             bool
+	includeTests           bool
+	marker                 bool
+	packageDoc             PackageDoc
+	sortBy                 SortBy
+	logger                 *log.Logger
+	verbosity              int
+}
+
+// New creates a Combiner for the module rooted at serviceDir. If serviceDir
+// holds a go.work, commands are collected from every module it uses, and
+// serviceDir itself needs no go.mod. outputDir is relative to serviceDir
+// unless it is absolute. A directory that is not the root of a module is
+// reported as a *ModuleError.
+func New(serviceDir string, outputDir string, opts ...Option) (*Combiner, error) {
+	serviceDir, err := filepath.Abs(serviceDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// an absolute output directory may be outside the service directory,
+	// even in another module
+	if !filepath.IsAbs(outputDir) {
+		outputDir = filepath.Join(serviceDir, outputDir)
+	}
+
+	outputDir = filepath.Clean(outputDir)
+
+	c := &Combiner{
+		serviceDir: serviceDir,
+		packages:   make(map[string]*MainPackage),
+		outputDir:  outputDir,
+		dispatch:   DispatchArgv0,
+
+		unknownExitCode: DefaultUnknownExitCode,
+		entrypointName:  DefaultEntrypointName,
+		packageDoc:      PackageDocRewrite,
+		sortBy:          SortByImportPath,
+		packageNameText: DefaultPackageNameTemplate,
+
+		dispatcherFilename: DefaultDispatcherFilename,
+		goBinary:           DefaultGoBinary,
+		dirMode:            DefaultDirMode,
+		fileMode:           DefaultFileMode,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	primary := &input{dir: serviceDir, fsys: c.fsys, primary: true}
+	if primary.fsys == nil {
+		primary.fsys = os.DirFS(serviceDir)
+		primary.os = true
+	}
+
+	workspace, err := readWorkspace(primary)
+	if err != nil {
+		return nil, err
+	}
+
+	// a workspace root needs no module of its own
+	if _, err := fs.Stat(primary.fsys, goModName); err == nil || workspace == nil {
+		c.module, err = getModuleName(primary.fsys, serviceDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get module name: %w", err)
+		}
+
+		primary.module = c.module
+	}
+
+	if c.inOutputDir(serviceDir) {
+		return nil, fmt.Errorf("output directory %s contains the service directory", outputDir)
+	}
+
+	c.importPrefix, err = c.resolveImportPrefix()
+	if err != nil {
+		return nil, err
+	}
+
+	c.packageNameTemplate, err = parsePackageNameTemplate(c.packageNameText)
+	if err != nil {
+		return nil, err
+	}
+
+	c.dispatcherTemplate = dispatcherTemplate
+	if c.dispatcherTemplateText != "" {
+		c.dispatcherTemplate, err = parseDispatcherTemplate(c.dispatcherTemplateText)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.module != "" {
+		c.inputs = []*input{primary}
+	}
+
+	if workspace != nil {
+		c.workspaceGo = workspace.goVersion
+
+		for _, dir := range workspace.dirs {
+			if dir == serviceDir && c.module != "" {
+				continue
+			}
+
+			in, err := workspaceInput(primary, dir)
+			if err != nil {
+				return nil, err
+			}
+
+			c.inputs = append(c.inputs, in)
+		}
+	}
+
+	for _, dir := range c.extraDirs {
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		if dir == serviceDir || c.inputAt(dir) != nil {
+			continue
+		}
+
+		fsys := os.DirFS(dir)
+
+		module, err := getModuleName(fsys, dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get module name: %w", err)
+		}
+
+		c.inputs = append(c.inputs, &input{dir: dir, module: module, fsys: fsys, os: true})
+	}
+
+	for i, dir := range c.include {
+		c.include[i] = strings.TrimSuffix(filepath.ToSlash(dir), "/")
+	}
+
+	c.alwaysIgnore = append(append([]string(nil), alwaysIgnore...), c.extraIgnore...)
+	for i, dir := range c.alwaysIgnore {
+		c.alwaysIgnore[i] = strings.TrimSuffix(filepath.ToSlash(dir), "/")
+	}
+
+	for _, ext := range c.extraCopyExtensions {
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+
+		if ext == "." || strings.ContainsAny(ext, "/\\") {
+			return nil, fmt.Errorf("invalid extension %q to copy", ext)
+		}
+
+		if ext == ".go" {
+			return nil, fmt.Errorf("go files can't be copied verbatim")
+		}
+
+		if c.copyExtensions == nil {
+			c.copyExtensions = make(map[string]bool)
+		}
+
+		c.copyExtensions[ext] = true
+	}
+
+	switch c.dispatch {
+	case DispatchArgv0, DispatchSubcommand, DispatchRegistry:
+	default:
+		return nil, fmt.Errorf("unknown dispatch mode %q", c.dispatch)
+	}
+
+	if !token.IsIdentifier(c.entrypointName) || !token.IsExported(c.entrypointName) {
+		return nil, fmt.Errorf("entrypoint name %q must be an exported Go identifier", c.entrypointName)
+	}
+
+	if c.dispatcherFilename != filepath.Base(c.dispatcherFilename) || path.Ext(c.dispatcherFilename) != ".go" || strings.HasSuffix(c.dispatcherFilename, "_test.go") {
+		return nil, fmt.Errorf("dispatcher filename %q must be a file name ending in .go", c.dispatcherFilename)
+	}
+
+	if c.standaloneTag != "" {
+		if err := checkTag(c.standaloneTag); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.dispatcherPackage != "" {
+		if !token.IsIdentifier(c.dispatcherPackage) || c.dispatcherPackage == "main" || c.dispatcherPackage == "_" {
+			return nil, fmt.Errorf("dispatcher package %q must be a Go identifier other than main", c.dispatcherPackage)
+		}
+
+		// these build a binary from the output directory
+		if c.emitDockerfile || c.emitInstallScript || len(c.completions) > 0 {
+			return nil, errors.New("completion scripts, the install script and the Dockerfile can't be generated for a dispatcher package")
+		}
+	}
+
+	if err := checkStatements("prolog", c.prolog); err != nil {
+		return nil, fmt.Errorf("prolog must be Go statements: %w", err)
+	}
+
+	if err := checkStatements("epilog", c.epilog); err != nil {
+		return nil, fmt.Errorf("epilog must be Go statements: %w", err)
+	}
+
+	for _, p := range c.wrapImports {
+		if err := module.CheckImportPath(p); err != nil {
+			return nil, fmt.Errorf("invalid wrap import: %w", err)
+		}
+	}
+
+	if c.dirMode != c.dirMode.Perm() || c.fileMode != c.fileMode.Perm() {
+		return nil, fmt.Errorf("directory mode %o and file mode %o must only have permission bits", c.dirMode, c.fileMode)
+	}
+
+	if c.groupBy < 0 {
+		return nil, fmt.Errorf("group depth must not be negative, got %d", c.groupBy)
+	}
+
+	if c.maxDepth < 0 {
+		return nil, fmt.Errorf("maximum depth must not be negative, got %d", c.maxDepth)
+	}
+
+	// these describe a single binary named after the output directory
+	if c.groupBy > 0 && (len(c.completions) > 0 || c.emitInstallScript || c.emitDockerfile) {
+		return nil, errors.New("completion scripts, the install script and the Dockerfile can't be generated for grouped commands")
+	}
+
+	// the commands of an existing dispatcher are only known by name and
+	// package
+	if c.appendCommands {
+		switch {
+		case c.dispatch == DispatchRegistry:
+			return nil, fmt.Errorf("appending to the dispatcher requires %s or %s dispatch", DispatchArgv0, DispatchSubcommand)
+		case c.groupBy > 0:
+			return nil, errors.New("appending to the dispatcher is not supported for grouped commands")
+		case c.pruneStale:
+			return nil, errors.New("appending to the dispatcher would prune the packages of its commands")
+		case c.emitGoMod:
+			return nil, fmt.Errorf("appending to the dispatcher can't generate a %s requiring the modules of its commands", goModName)
+		}
+	}
+
+	if c.commandPrefix != "" && c.dispatch == DispatchSubcommand {
+		return nil, fmt.Errorf("a command prefix can't be used with %s dispatch", DispatchSubcommand)
+	}
+
+	if c.preserveArgv0 && c.dispatch != DispatchSubcommand {
+		return nil, fmt.Errorf("os.Args[0] can only be preserved with %s dispatch", DispatchSubcommand)
+	}
+
+	if len(c.trimSuffixes) > 0 && c.dispatch == DispatchSubcommand {
+		return nil, fmt.Errorf("suffixes to trim can't be used with %s dispatch", DispatchSubcommand)
+	}
+
+	for _, shell := range c.completions {
+		switch shell {
+		case ShellBash, ShellZsh, ShellFish:
+		default:
+			return nil, fmt.Errorf("unknown completion shell %q", shell)
+		}
+
+		if c.dispatch != DispatchSubcommand {
+			return nil, fmt.Errorf("completion scripts require %s dispatch", DispatchSubcommand)
+		}
+	}
+
+	switch c.packageDoc {
+	case PackageDocRewrite, PackageDocStrip, PackageDocKeep:
+	default:
+		return nil, fmt.Errorf("unknown package doc mode %q", c.packageDoc)
+	}
+
+	switch c.sortBy {
+	case SortByImportPath, SortByCommand:
+	default:
+		return nil, fmt.Errorf("unknown command order %q", c.sortBy)
+	}
+
+	if c.unknownExitCode < 1 || c.unknownExitCode > 125 {
+		return nil, fmt.Errorf("unknown command exit code %d must be between 1 and 125", c.unknownExitCode)
+	}
+
+	for _, pattern := range c.exclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+		}
+	}
+
+	return c, nil
+}
+
+// Packages returns the discovered main packages keyed by their slash
+// separated source directory relative to their input directory. For inputs
+// other than the service directory the key is prefixed with the module path.
+func (c *Combiner) Packages() map[string]*MainPackage {
+	return c.packages
+}
+
+// alwaysIgnore are the directories that are never walked, at any depth.
+// WithAlwaysIgnore adds to them.
+var alwaysIgnore = []string{
+	".git",
+	"vendor",
+	".idea",
+	".github",
+}
+
+// alwaysIgnored reports whether the directory at relativePath matches
+// ignore: by name at any depth, or, if ignore has a slash, by its path
+// relative to the input directory.
+func alwaysIgnored(ignore string, relativePath string) bool {
+	if relativePath == "" {
+		return false
+	}
+
+	if strings.Contains(ignore, "/") {
+		return ignore == relativePath
+	}
+
+	return ignore == path.Base(relativePath)
+}
+
+// isExcluded reports whether relativePath matches any exclude pattern.
+// Patterns use path.Match syntax per path segment, and a "**" segment
+// matches zero or more segments.
+func (c *Combiner) isExcluded(relativePath string) bool {
+	if relativePath == "" {
+		return false
+	}
+
+	name := strings.Split(relativePath, "/")
+
+	for _, pattern := range c.exclude {
+		// patterns are validated in New
+		if ok, _ := matchSegments(strings.Split(pattern, "/"), name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchSegments(pattern []string, name []string) (bool, error) {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				ok, err := matchSegments(pattern[1:], name[i:])
+				if err != nil || ok {
+					return ok, err
+				}
+			}
+
+			return false, nil
+		}
+
+		if len(name) == 0 {
+			return false, nil
+		}
+
+		ok, err := path.Match(pattern[0], name[0])
+		if err != nil || !ok {
+			return false, err
+		}
+
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0, nil
+}
+
+// Collect walks the service directory, transforming every main package it
+// finds, and checks that the resulting command names are unique. It fails
+// if there are none, unless WithAllowEmpty is set. Files that fail to parse
+// are reported as a *ParseError, and duplicate command names as a
+// *DuplicateCommandError.
+func (c *Combiner) Collect() error {
+	if err := c.collect(); err != nil {
+		return err
+	}
+
+	return c.validate()
+}
+
+// ParseErrors returns the errors of the files that failed to parse during
+// Collect in keep going mode. Those files and their packages were skipped.
+func (c *Combiner) ParseErrors() []error {
+	return c.parseErrors
+}
+
+// candidate is a Go file found while walking the service directory.
+type candidate struct {
+	input        *input
+	fullPath     string
+	relativePath string
+	test         bool
+	// excluded is set for main package files excluded by build
+	// constraints
+	excluded bool
+	// failed is set for files that failed to parse in keep going mode
+	failed bool
+	// otherPackage is the package of a file of a package other than main,
+	// and other holds just enough of it to evaluate its build constraints
+	otherPackage string
+	other        *sourceFile
+}
+
+// parseCandidates walks every input and parses the Go files found. The
+// sources of files that are skipped, such as files of other packages, are
+// nil. In keep going mode, files that fail to parse are skipped too.
+func (c *Combiner) parseCandidates() ([]candidate, []*sourceFile, error) {
+	var candidates []candidate
+
+	c.progress.reset()
+
+	stop := c.reportProgress()
+	defer stop()
+
+	start := time.Now()
+
+	for _, in := range c.inputs {
+		found, err := c.walk(in)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		candidates = append(candidates, found...)
+	}
+
+	c.timings.Walk += time.Since(start)
+	start = time.Now()
+
+	sources := make([]*sourceFile, len(candidates))
+
+	// skipped holds why each skipped file was skipped, so it can be logged
+	// in walk order once parsing is done
+	skipped := make([]string, len(candidates))
+	errs := make([]error, len(candidates))
+
+	err := parallel(len(candidates), func(i int) error {
+		src, err := parseFile(candidates[i].input.fsys, candidates[i].input.name(candidates[i].fullPath), candidates[i].fullPath)
+		if err != nil && c.keepGoing {
+			errs[i] = err
+			c.progress.parsed(candidates[i].key(), false)
+
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// without build tags, files are only left out if no build but one
+		// with the ignore tag includes them
+		matches := true
+		if c.buildTags != nil {
+			matches, err = src.matchesTags(c.buildTags)
+		} else {
+			var ignored bool
+			ignored, err = src.ignored()
+			matches = !ignored
+		}
+
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case !src.isMain():
+			skipped[i] = fmt.Sprintf("package %s, not main", src.file.Name.Name)
+			if matches {
+				candidates[i].otherPackage = src.file.Name.Name
+				candidates[i].other = &sourceFile{filename: src.filename, constraints: src.constraints}
+			}
+		case src.isGenerated():
+			// files generated by an earlier run into a different output
+			// directory are never inputs
+			skipped[i] = "generated by main-combiner"
+		case !matches:
+			skipped[i] = "excluded by build constraints"
+			candidates[i].excluded = true
+		default:
+			sources[i] = src
+		}
+
+		c.progress.parsed(candidates[i].key(), sources[i] != nil && !candidates[i].test && src.mainFunc() != nil)
+
+		return nil
+	})
+	c.timings.Parse += time.Since(start)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i, reason := range skipped {
+		if errs[i] != nil {
+			c.logf(0, "error: %s", errs[i])
+			c.parseErrors = append(c.parseErrors, errs[i])
+			candidates[i].failed = true
+
+			continue
+		}
+
+		if reason != "" {
+			c.logf(1, "skipping %s: %s", candidates[i].relativePath, reason)
+		}
+	}
+
+	return candidates, sources, nil
+}
+
+func (c *Combiner) collect() error {
+	candidates, sources, err := c.parseCandidates()
+	if err != nil {
+		return err
+	}
+
+	// failed and excluded hold the keys of directories with files that
+	// failed to parse, and with non-test files excluded by build
+	// constraints
+	failed := make(map[string]bool)
+	excluded := make(map[string]bool)
+
+	for _, cd := range candidates {
+		if cd.failed {
+			failed[cd.key()] = true
+		}
+
+		if cd.excluded && !cd.test {
+			excluded[cd.key()] = true
+		}
+	}
+
+	packages, testOnly, err := c.mainPackages(candidates, sources)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range packages {
+		c.packages[m.key] = m
+	}
+
+	// a package name may be set by any of the files of a package, so
+	// packages are named once all of them are known
+	for _, m := range packages {
+		packageName, err := m.packageOverride()
+		if err != nil {
+			return err
+		}
+
+		if packageName == "" {
+			packageName, err = c.packageName(m.key, m.Module)
+			if err != nil {
+				return err
+			}
+		}
+
+		if other := c.findPackage(packageName); other != nil {
+			return fmt.Errorf("directories %s and %s both generate package %s", other.key, m.key, packageName)
+		}
+
+		m.PackageName = packageName
+		m.ImportPath = path.Join(c.outputImportPath(), packageName)
+		m.OutputDir = filepath.Join(c.outputDir, packageName)
+	}
+
+	for i, src := range sources {
+		if src == nil || !candidates[i].test {
+			continue
+		}
+
+		key := candidates[i].key()
+
+		m := c.packages[key]
+		if m == nil {
+			switch {
+			case testOnly[key] && excluded[key]:
+				c.logf(1, "skipping %s: all of its non-test files are excluded by build constraints", key)
+			case testOnly[key]:
+				c.logf(0, "warning: %s declares package main only in test files and is skipped", key)
+			}
+
+			delete(testOnly, key)
+
+			continue
+		}
+
+		if !c.includeTests {
+			c.logf(1, "skipping %s: test file", candidates[i].relativePath)
+			continue
+		}
+
+		src.test = true
+		m.sources = append(m.sources, src)
+	}
+
+	// main may be declared in a test file, or in a file generated at build
+	// time, and the dispatcher would not compile without it
+	var withMain []*MainPackage
+
+	for _, m := range packages {
+		if !declaresMain(m.nonTestSources()) {
+			if excluded[m.key] {
+				c.logf(1, "skipping %s: func main is excluded by build constraints", m.key)
+			} else {
+				c.logf(0, "warning: %s declares package main but no func main and is skipped", m.key)
+			}
+
+			delete(c.packages, m.key)
+
+			continue
+		}
+
+		if err := checkMains(m.nonTestSources(), c.contextEntrypoint); err != nil {
+			return err
+		}
+
+		// a package with a file that failed to parse is incomplete
+		if failed[m.key] {
+			c.logf(0, "warning: %s has files that failed to parse and is skipped", m.key)
+			delete(c.packages, m.key)
+
+			continue
+		}
+
+		if c.isSkipped(m) {
+			c.logf(1, "skipping %s: command %s is skipped", m.key, m.Command)
+			delete(c.packages, m.key)
+
+			continue
+		}
+
+		withMain = append(withMain, m)
+	}
+
+	packages = withMain
+
+	if err := c.checkOtherPackages(candidates); err != nil {
+		return err
+	}
+
+	for _, m := range packages {
+		if err := c.collectEmbeds(m); err != nil {
+			return err
+		}
+
+		if err := c.collectCopies(m); err != nil {
+			return err
+		}
+
+		m.context = c.contextEntrypoint && takesContext(m.nonTestSources())
+	}
+
+	if c.incremental {
+		previous, err := c.loadState()
+		if err != nil {
+			return err
+		}
+
+		var changed []*MainPackage
+
+		for _, m := range packages {
+			m.hash = c.packageHash(m)
+
+			if previous.Packages[m.key] == m.hash && c.reuseOutput(m) {
+				c.logf(1, "skipping %s: unchanged", m.key)
+				continue
+			}
+
+			changed = append(changed, m)
+		}
+
+		packages = changed
+	}
+
+	start := time.Now()
+	defer func() {
+		c.timings.Rewrite += time.Since(start)
+	}()
+
+	return parallel(len(packages), func(i int) error {
+		return c.rewritePackage(packages[i])
+	})
+}
+
+// mainPackages groups the non-test sources of main packages by directory,
+// in walk order, and names their commands. In marker mode only marked
+// packages are kept. testOnly holds the keys of the remaining directories
+// with main package test files, which are left out of the packages.
+func (c *Combiner) mainPackages(candidates []candidate, sources []*sourceFile) (packages []*MainPackage, testOnly map[string]bool, err error) {
+	byKey := make(map[string]*MainPackage)
+	testOnly = make(map[string]bool)
+
+	for i, src := range sources {
+		if src == nil {
+			continue
+		}
+
+		if candidates[i].test {
+			testOnly[candidates[i].key()] = true
+			continue
+		}
+
+		dirName := path.Dir(candidates[i].relativePath)
+		key := candidates[i].key()
+
+		m := byKey[key]
+		if m == nil {
+			m = &MainPackage{
+				Command:   path.Base(dirName),
+				SourceDir: dirName,
+				Module:    candidates[i].input.module,
+				Contents:  make(map[string][]byte),
+				Embedded:  make(map[string][]byte),
+				Copied:    make(map[string][]byte),
+				key:       key,
+				input:     candidates[i].input,
+			}
+
+			packages = append(packages, m)
+
+			byKey[key] = m
+		}
+
+		m.sources = append(m.sources, src)
+	}
+
+	if c.marker {
+		var marked []*MainPackage
+
+		for _, m := range packages {
+			if !m.marked() {
+				c.logf(1, "skipping %s: no %s comment", m.key, includeDirective)
+				delete(testOnly, m.key)
+
+				continue
+			}
+
+			marked = append(marked, m)
+		}
+
+		packages = marked
+	}
+
+	for _, m := range packages {
+		command, err := m.commandOverride()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if command != "" {
+			m.Command = command
+			m.named = true
+		}
+	}
+
+	return packages, testOnly, nil
+}
+
+// isSkipped reports whether m is skipped by WithSkipCommands, by its command
+// name or its dotted key.
+func (c *Combiner) isSkipped(m *MainPackage) bool {
+	return c.skipCommands[m.Command] || c.skipCommands[strings.ReplaceAll(m.key, "/", ".")]
+}
+
+// logf logs to the configured logger if the verbosity is at least level.
+// Level 0 is for warnings, level 1 reports skipped files and written
+// output, level 2 reports every directory and file considered.
+func (c *Combiner) logf(level int, format string, args ...interface{}) {
+	if c.logger == nil || c.verbosity < level {
+		return
+	}
+
+	c.logger.Printf(format, args...)
+}
+
+// outputImportPath returns the import path of the output directory.
+func (c *Combiner) outputImportPath() string {
+	return c.importPrefix
+}
+
+// resolveImportPrefix checks the configured import prefix, or finds it if
+// none was set.
+func (c *Combiner) resolveImportPrefix() (string, error) {
+	if c.importPrefix == "" {
+		return c.findImportPrefix()
+	}
+
+	if err := module.CheckImportPath(c.importPrefix); err != nil {
+		return "", fmt.Errorf("invalid import prefix: %w", err)
+	}
+
+	return c.importPrefix, nil
+}
+
+// findImportPrefix returns the import path of the output directory, based on
+// the nearest go.mod above it. The go.mod written to the output directory by
+// WithEmitGoMod is ignored, as its module path is derived from the result.
+// Below the service directory, go.mod files are read from the file system
+// given with WithFS, if any.
+func (c *Combiner) findImportPrefix() (string, error) {
+	dir := c.outputDir
+	if c.emitGoMod {
+		dir = filepath.Dir(dir)
+	}
+
+	for {
+		fsys := os.DirFS(dir)
+		if rel, inside := relativeInside(c.serviceDir, dir); inside && c.fsys != nil {
+			sub, err := fs.Sub(c.fsys, rel)
+			if err != nil {
+				return "", err
+			}
+
+			fsys = sub
+		}
+
+		if _, err := fs.Stat(fsys, goModName); err == nil {
+			module, err := getModuleName(fsys, dir)
+			if err != nil {
+				return "", err
+			}
+
+			return path.Join(module, relative(dir, c.outputDir)), nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("output directory %s is not inside a module; set an import prefix", c.outputDir)
+		}
+
+		dir = parent
+	}
+}
+
+// key returns the Packages key of the directory containing the candidate.
+func (cd candidate) key() string {
+	dirName := path.Dir(cd.relativePath)
+	if cd.input.primary {
+		return dirName
+	}
+
+	return path.Join(cd.input.module, dirName)
+}
+
+// relative returns fullPath relative to dir, using forward slashes on every
+// platform. dir itself is "".
+func relative(dir string, fullPath string) string {
+	rel, err := filepath.Rel(dir, fullPath)
+	if err != nil || rel == "." {
+		return ""
+	}
+
+	return filepath.ToSlash(rel)
+}
+
+func (m *MainPackage) nonTestSources() []*sourceFile {
+	var sources []*sourceFile
+
+	for _, src := range m.sources {
+		if !src.test {
+			sources = append(sources, src)
+		}
+	}
+
+	return sources
+}
+
+func declaresMain(sources []*sourceFile) bool {
+	for _, src := range sources {
+		if src.mainFunc() != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkMains makes sure every func main of sources can be renamed, and that
+// no two of sources that declare it can be built together, as both would be
+// renamed to the entrypoint. Files whose build constraints exclude each
+// other, such as main_linux.go and main_windows.go, are fine. With
+// allowContext set, main may take a context.Context.
+func checkMains(sources []*sourceFile, allowContext bool) error {
+	var mains []*sourceFile
+
+	for _, src := range sources {
+		if src.mainFunc() != nil {
+			if err := src.checkMainFunc(allowContext); err != nil {
+				return err
+			}
+
+			mains = append(mains, src)
+		}
+	}
+
+	for i := range mains {
+		for _, other := range mains[i+1:] {
+			ok, err := buildableTogether(mains[i], other)
+			if err != nil {
+				return err
+			}
+
+			if ok {
+				return fmt.Errorf("func main is declared in both %s and %s, which can be built together", mains[i].filename, other.filename)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkOtherPackages warns about files of packages other than main in the
+// directories of commands. The go tool refuses to build a directory with
+// several packages, so such files never belong to the command unless build
+// constraints keep them out of its build, and are not combined.
+func (c *Combiner) checkOtherPackages(candidates []candidate) error {
+	for _, cd := range candidates {
+		if cd.other == nil || cd.test {
+			continue
+		}
+
+		m := c.packages[cd.key()]
+		if m == nil {
+			continue
+		}
+
+		for _, src := range m.nonTestSources() {
+			ok, err := buildableTogether(cd.other, src)
+			if err != nil {
+				return err
+			}
+
+			if ok {
+				c.logf(0, "warning: %s is package %s but %s is package main, so the go tool can't build it; the file is not combined", cd.relativePath, cd.otherPackage, m.key)
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// rewritePackage transforms every source file of m into m.Contents.
+func (c *Combiner) rewritePackage(m *MainPackage) error {
+	imports := importUsage(m.sources)
+
+	if c.versionVar != "" {
+		for _, src := range m.sources {
+			if _, err := injectVersion(src, c.versionVar, c.versionImportPath()); err != nil {
+				return err
+			}
+		}
+	}
+
+	if rename := c.renamePredicate(); rename != nil {
+		prefixTopLevel(m.PackageName+"_", m.sources, rename)
+	}
+
+	if c.deferInit {
+		if err := deferInit(m.nonTestSources()); err != nil {
+			return err
+		}
+	}
+
+	for _, src := range m.sources {
+		if obj := src.file.Scope.Lookup(c.entrypointName); obj != nil {
+			return fmt.Errorf("%s: %s is already declared; choose another entrypoint name", src.fset.Position(obj.Pos()), c.entrypointName)
+		}
+	}
+
+	t := &transform{
+		packageName:    m.PackageName,
+		entrypointName: c.entrypointName,
+		trapExit:       c.trapExit,
+		exitImportPath: c.exitImportPath(),
+		packageDoc:     c.packageDoc,
+		keepMain:       c.wrapper,
+	}
+
+	for _, src := range m.sources {
+		t.rewrite(src)
+	}
+
+	reconcileImports(m.sources, imports)
+
+	for _, src := range m.sources {
+		if c.simplify {
+			simplify(src.file)
+		}
+
+		lines := declLines(src)
+
+		data, err := render(src)
+		if err != nil {
+			return err
+		}
+
+		if c.lineDirectives {
+			target, err := filepath.Rel(m.OutputDir, src.filename)
+			if err != nil {
+				return err
+			}
+
+			data, err = addLineDirectives(src.filename, filepath.ToSlash(target), lines, data)
+			if err != nil {
+				return err
+			}
+		}
+
+		m.Contents[src.filename] = data
+	}
+
+	if c.singleFile {
+		if err := mergePackage(m); err != nil {
+			return err
+		}
+	}
+
+	if c.standaloneTag != "" {
+		return c.addStandalone(m)
+	}
+
+	return nil
+}
+
+// parallel calls fn for 0 through n-1 across runtime.NumCPU() workers. It
+// returns the error for the lowest i, so the result does not depend on
+// scheduling.
+func parallel(n int, fn func(i int) error) error {
+	var wg sync.WaitGroup
+
+	errs := make([]error, n)
+	jobs := make(chan int)
+
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range jobs {
+				errs[i] = fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// inOutputDir reports whether fullPath is the output directory or inside it.
+func (c *Combiner) inOutputDir(fullPath string) bool {
+	rel, err := filepath.Rel(c.outputDir, fullPath)
+	if err != nil {
+		return false
+	}
+
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// walk returns the Go files under the input directory that pass the ignore,
+// exclude, and include filters, in lexical order.
+func (c *Combiner) walk(in *input) ([]candidate, error) {
+	var candidates []candidate
+
+	var gitIgnore *gitignore
+
+	if c.respectGitignore {
+		var err error
+
+		gitIgnore, err = newGitignore(in)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Paths are filtered in order: alwaysIgnore, then exclude, then
+	// .gitignore, then include. A path that is excluded is skipped even if
+	// it is also included.
+	walkFn := func(fullPath string, isDir bool) error {
+		relativePath := relative(in.dir, fullPath)
+
+		if isDir {
+			if c.maxDepth > 0 && relativePath != "" && strings.Count(relativePath, "/") >= c.maxDepth {
+				c.logf(1, "skipping directory %s: below the maximum depth of %d", relativePath, c.maxDepth)
+				return fs.SkipDir
+			}
+
+			for _, ignore := range c.alwaysIgnore {
+				if alwaysIgnored(ignore, relativePath) {
+					c.logf(1, "skipping directory %s: always ignored", relativePath)
+					return fs.SkipDir
+				}
+			}
+
+			if c.inOutputDir(fullPath) {
+				c.logf(1, "skipping directory %s: output directory", relativePath)
+				return fs.SkipDir
+			}
+
+			if other := c.inputAt(fullPath); other != nil && other != in {
+				c.logf(1, "skipping directory %s: module %s is collected separately", relativePath, other.module)
+				return fs.SkipDir
+			}
+
+			if c.isExcluded(relativePath) {
+				c.logf(1, "skipping directory %s: excluded", relativePath)
+				return fs.SkipDir
+			}
+
+			if gitIgnore != nil {
+				if gitIgnore.ignored(fullPath, true) {
+					c.logf(1, "skipping directory %s: ignored by .gitignore", relativePath)
+					return fs.SkipDir
+				}
+
+				if err := gitIgnore.load(fullPath); err != nil {
+					return err
+				}
+			}
+
+			c.logf(2, "entering directory %s", fullPath)
+
+			return nil
+		}
+
+		c.logf(2, "considering %s", relativePath)
+
+		if c.isExcluded(relativePath) {
+			c.logf(1, "skipping %s: excluded", relativePath)
+			return nil
+		}
+
+		if c.inOutputDir(fullPath) {
+			c.logf(1, "skipping %s: in output directory", relativePath)
+			return nil
+		}
+
+		if gitIgnore != nil && gitIgnore.ignored(fullPath, false) {
+			c.logf(1, "skipping %s: ignored by .gitignore", relativePath)
+			return nil
+		}
+
+		if len(c.include) > 0 && relativePath != "" {
+			found := false
+
+			for _, d := range c.include {
+				if strings.HasPrefix(relativePath, d+"/") {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				c.logf(2, "skipping %s: not included", relativePath)
+				return nil
+			}
+		}
+
+		if !strings.HasSuffix(fullPath, ".go") {
+			c.logf(2, "skipping %s: not a Go file", relativePath)
+			return nil
+		}
+
+		// test files are only copied with WithIncludeTests, but are always
+		// parsed to warn about directories whose main is only in tests
+		c.progress.foundFile()
+
+		candidates = append(candidates, candidate{
+			input:        in,
+			fullPath:     fullPath,
+			relativePath: relativePath,
+			test:         strings.HasSuffix(fullPath, "_test.go"),
+		})
+
+		return nil
+	}
+
+	// visited holds the real path of every directory walked, so symlinks
+	// to a directory that was already walked, including loops, are skipped
+	visited := make(map[string]bool)
+
+	// walkDir walks root, a name in the file system of the input that may
+	// be a symlink to a directory. Names below a symlink are the names it
+	// was reached by.
+	var walkDir func(root string) error
+
+	walkDir = func(root string) error {
+		return fs.WalkDir(in.fsys, root, func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			fullPath := filepath.Join(in.dir, filepath.FromSlash(name))
+			relativePath := relative(in.dir, fullPath)
+
+			if d.Type()&fs.ModeSymlink == 0 {
+				if d.IsDir() {
+					realPath, err := in.realPath(name)
+					if err != nil {
+						return err
+					}
+
+					if visited[realPath] {
+						c.logf(1, "skipping directory %s: already walked through a symlink", relativePath)
+						return fs.SkipDir
+					}
+
+					visited[realPath] = true
+				}
+
+				return walkFn(fullPath, d.IsDir())
+			}
+
+			target, err := fs.Stat(in.fsys, name)
+			if err != nil {
+				c.logf(1, "skipping %s: broken symlink", relativePath)
+				return nil
+			}
+
+			if !target.IsDir() {
+				return walkFn(fullPath, false)
+			}
+
+			if !c.followSymlinks {
+				c.logf(1, "skipping %s: symlink to a directory", relativePath)
+				return nil
+			}
+
+			realPath, err := in.realPath(name)
+			if err != nil {
+				return err
+			}
+
+			if visited[realPath] {
+				c.logf(1, "skipping %s: symlink to a directory that was already walked", relativePath)
+				return nil
+			}
+
+			return walkDir(name)
+		})
+	}
+
+	if err := walkDir("."); err != nil {
+		return nil, err
+	}
+
+	return candidates, nil
+}
+
+// checkIncludes makes sure every included directory contains at least one
+// main package, which catches misspelled directories.
+func (c *Combiner) checkIncludes() error {
+	if c.allowEmptyInclude {
+		return nil
+	}
+
+	var empty []string
+
+	for _, d := range c.include {
+		found := false
+
+		for _, m := range c.packages {
+			if m.SourceDir == d || strings.HasPrefix(m.SourceDir, d+"/") {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			empty = append(empty, d)
+		}
+	}
+
+	if len(empty) > 0 {
+		return fmt.Errorf("no main packages found in included directories %s", strings.Join(empty, ", "))
+	}
+
+	return nil
+}
+
+// commandGroups groups packages by command name, and returns the names
+// sorted along with the groups.
+func commandGroups(packages map[string]*MainPackage) ([]string, map[string][]*MainPackage) {
+	byCommand := make(map[string][]*MainPackage)
+
+	for _, m := range packages {
+		byCommand[m.Command] = append(byCommand[m.Command], m)
+	}
+
+	var commands []string
+	for command := range byCommand {
+		commands = append(commands, command)
+	}
+
+	sort.Strings(commands)
+
+	return commands, byCommand
+}
+
+// duplicateCommand returns the error for packages that share command.
+func duplicateCommand(command string, packages []*MainPackage) *DuplicateCommandError {
+	var dirs []string
+	for _, m := range packages {
+		dirs = append(dirs, m.key)
+	}
+
+	sort.Strings(dirs)
+
+	return &DuplicateCommandError{Command: command, Dirs: dirs}
+}
+
+// validate checks that every package has a unique command name. When
+// duplicates are allowed, colliding commands are renamed to their dotted
+// source directory, e.g. foo/server becomes foo.server, which must not be
+// the name of another command either.
+func (c *Combiner) validate() error {
+	if err := c.checkIncludes(); err != nil {
+		return err
+	}
+
+	if len(c.packages) == 0 && !c.allowEmpty {
+		return fmt.Errorf("no main packages found under %s", c.serviceDir)
+	}
+
+	commands, byCommand := commandGroups(c.packages)
+
+	for _, command := range commands {
+		packages := byCommand[command]
+		if len(packages) < 2 {
+			continue
+		}
+
+		// names that were chosen explicitly are never disambiguated
+		named := false
+		for _, m := range packages {
+			named = named || m.named
+		}
+
+		if !c.allowDuplicateCommands || named {
+			return duplicateCommand(command, packages)
+		}
+
+		for _, m := range packages {
+			m.Command = strings.ReplaceAll(m.key, "/", ".")
+		}
+	}
+
+	// a dotted source directory may still be the name of another command
+	commands, byCommand = commandGroups(c.packages)
+
+	for _, command := range commands {
+		if packages := byCommand[command]; len(packages) > 1 {
+			return duplicateCommand(command, packages)
+		}
+	}
+
+	if c.defaultCommand != "" && c.findCommand(c.defaultCommand) == nil {
+		return fmt.Errorf("default command %q is not one of the collected commands", c.defaultCommand)
+	}
+
+	return nil
+}
+
+func (c *Combiner) findCommand(command string) *MainPackage {
+	for _, m := range c.packages {
+		if m.Command == command {
+			return m
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,1767 @@
+package combine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// testModule is the module path of the fixtures made by newModule.
+const testModule = "example.com/fx"
+
+// writeFiles writes files, keyed by slash separated path, below dir.
+func writeFiles(t testing.TB, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, contents := range files {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(filename, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// newModule writes files to a temporary directory holding the module
+// testModule, and returns the directory. A go.mod in files replaces the
+// default one.
+func newModule(t testing.TB, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"go.mod": "module " + testModule + "\n\ngo 1.16\n"})
+	writeFiles(t, dir, files)
+
+	return dir
+}
+
+// mainFile returns the source of a main package that prints name.
+func mainFile(name string) string {
+	return "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"" + name + "\")\n}\n"
+}
+
+// newCombiner creates a Combiner for dir writing to cmd/combined.
+func newCombiner(t testing.TB, dir string, opts ...Option) *Combiner {
+	t.Helper()
+
+	c, err := New(dir, "cmd/combined", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+// collected creates a Combiner for dir, writing to cmd/combined, and
+// collects it.
+func collected(t testing.TB, dir string, opts ...Option) *Combiner {
+	t.Helper()
+
+	c := newCombiner(t, dir, opts...)
+	if err := c.Collect(); err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+// generate collects dir and returns the generated files.
+func generate(t testing.TB, dir string, opts ...Option) map[string][]byte {
+	t.Helper()
+
+	files, err := collected(t, dir, opts...).Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return files
+}
+
+// buildOutput writes the output of c and makes sure every package in the
+// output directory compiles, with tags set.
+func buildOutput(t testing.TB, c *Combiner, tags ...string) {
+	t.Helper()
+
+	if err := c.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	goBuild(t, c.outputDir, tags...)
+}
+
+// goBuild builds every package below dir with tags set.
+func goBuild(t testing.TB, dir string, tags ...string) {
+	t.Helper()
+
+	goBinary, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+
+	args := []string{"build", "-o", os.DevNull}
+	if len(tags) > 0 {
+		args = append(args, "-tags", strings.Join(tags, ","))
+	}
+
+	cmd := exec.Command(goBinary, append(args, "./...")...)
+	cmd.Dir = dir
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to build %s: %s\n%s", dir, err, out)
+	}
+}
+
+// buildBinary writes the output of c and builds the dispatcher, passing
+// flags to go build, into a temporary directory. It returns the path of the
+// binary.
+func buildBinary(t testing.TB, c *Combiner, flags ...string) string {
+	t.Helper()
+
+	if err := c.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	goBinary, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+
+	binary := filepath.Join(t.TempDir(), filepath.Base(c.outputDir))
+
+	args := append([]string{"build", "-o", binary}, flags...)
+
+	cmd := exec.Command(goBinary, append(args, ".")...)
+	cmd.Dir = c.outputDir
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to build %s: %s\n%s", c.outputDir, err, out)
+	}
+
+	return binary
+}
+
+// runBinary runs binary with args through a symlink called name next to
+// it, or directly if name is empty, and returns its combined output and
+// exit code.
+func runBinary(t testing.TB, binary string, name string, args ...string) (string, int) {
+	t.Helper()
+
+	if name != "" {
+		link := filepath.Join(filepath.Dir(binary), name)
+		if _, err := os.Lstat(link); err != nil {
+			if err := os.Symlink(filepath.Base(binary), link); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		binary = link
+	}
+
+	out, err := exec.Command(binary, args...).CombinedOutput()
+
+	var exitErr *exec.ExitError
+
+	switch {
+	case errors.As(err, &exitErr):
+		return string(out), exitErr.ExitCode()
+	case err != nil:
+		t.Fatal(err)
+	}
+
+	return string(out), 0
+}
+
+// commandNames returns the sorted command names collected by c.
+func commandNames(c *Combiner) []string {
+	names := []string{}
+	for _, m := range c.packages {
+		names = append(names, m.Command)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func TestDuplicateCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		allow    bool
+		files    map[string]string
+		commands []string
+		err      string
+	}{
+		{
+			name: "fail",
+			err:  `duplicate command "server" found in directories bar/server, foo/server`,
+		},
+		{
+			name:     "allow",
+			allow:    true,
+			commands: []string{"bar.server", "foo.server", "worker"},
+		},
+		{
+			name:  "dotted name taken",
+			allow: true,
+			files: map[string]string{"x/foo.server/main.go": mainFile("x")},
+			err:   `duplicate command "foo.server" found in directories foo/server, x/foo.server`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{
+				"foo/server/main.go": mainFile("foo"),
+				"bar/server/main.go": mainFile("bar"),
+				"cmd/worker/main.go": mainFile("worker"),
+			}
+			for name, data := range tt.files {
+				files[name] = data
+			}
+
+			dir := newModule(t, files)
+
+			c := newCombiner(t, dir, WithAllowDuplicateCommands(tt.allow))
+
+			err := c.Collect()
+			if tt.err != "" {
+				var dup *DuplicateCommandError
+				if !errors.As(err, &dup) {
+					t.Fatalf("expected a *DuplicateCommandError, got %v", err)
+				}
+
+				if err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %q", tt.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := commandNames(c); !reflect.DeepEqual(got, tt.commands) {
+				t.Fatalf("expected commands %v, got %v", tt.commands, got)
+			}
+
+			buildOutput(t, c)
+		})
+	}
+}
+
+// syntheticTree writes a module with commands main packages of files files
+// each, and returns its directory.
+func syntheticTree(t testing.TB, commands int, files int) string {
+	t.Helper()
+
+	tree := make(map[string]string)
+
+	for i := 0; i < commands; i++ {
+		cmd := fmt.Sprintf("cmd/group%d/command%d", i%10, i)
+		tree[cmd+"/main.go"] = mainFile(cmd)
+
+		for j := 1; j < files; j++ {
+			tree[fmt.Sprintf("%s/helper%d.go", cmd, j)] = fmt.Sprintf("package main\n\nimport \"strings\"\n\nfunc helper%d(s string) string {\n\treturn strings.ToUpper(s)\n}\n", j)
+		}
+	}
+
+	return newModule(t, tree)
+}
+
+func TestCollectDeterministic(t *testing.T) {
+	dir := syntheticTree(t, 50, 3)
+
+	var first map[string][]byte
+
+	for i := 0; i < 3; i++ {
+		files := generate(t, dir)
+		if first == nil {
+			first = files
+			continue
+		}
+
+		if !reflect.DeepEqual(files, first) {
+			t.Fatal("collecting the same tree twice generated different output")
+		}
+	}
+
+	if len(first) != 50*3+1 {
+		t.Fatalf("expected %d files, got %d", 50*3+1, len(first))
+	}
+}
+
+func TestParallelLowestError(t *testing.T) {
+	err := parallel(100, func(i int) error {
+		if i%10 == 7 {
+			return fmt.Errorf("error %d", i)
+		}
+
+		return nil
+	})
+
+	if err == nil || err.Error() != "error 7" {
+		t.Fatalf("expected the error of the lowest index, got %v", err)
+	}
+}
+
+func BenchmarkCollect(b *testing.B) {
+	dir := syntheticTree(b, 200, 5)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		c := newCombiner(b, dir)
+		if err := c.Collect(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestRelative(t *testing.T) {
+	root := filepath.Join(string(filepath.Separator), "src", "repo")
+
+	tests := []struct {
+		name     string
+		fullPath string
+		want     string
+	}{
+		{name: "root", fullPath: root, want: ""},
+		{name: "child", fullPath: filepath.Join(root, "cmd"), want: "cmd"},
+		{name: "nested", fullPath: filepath.Join(root, "cmd", "server", "main.go"), want: "cmd/server/main.go"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := relative(root, tt.fullPath); got != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSlashSeparatedPaths(t *testing.T) {
+	dir := newModule(t, map[string]string{
+		"cmd/admin/users/main.go": mainFile("users"),
+		"vendor/x/main.go":        mainFile("vendored"),
+	})
+
+	c := collected(t, dir, WithInclude(filepath.Join("cmd", "admin")))
+
+	m := c.packages["cmd/admin/users"]
+	if m == nil {
+		t.Fatalf("expected cmd/admin/users to be collected, got %v", c.packages)
+	}
+
+	if m.SourceDir != "cmd/admin/users" || m.ImportPath != testModule+"/cmd/combined/cmd_admin_users" {
+		t.Fatalf("expected slash separated paths, got %s and %s", m.SourceDir, m.ImportPath)
+	}
+
+	if m.OutputDir != filepath.Join(dir, "cmd", "combined", "cmd_admin_users") {
+		t.Fatalf("expected an output directory with native separators, got %s", m.OutputDir)
+	}
+}
+
+// readTree returns the contents of every file below dir, keyed by slash
+// separated path relative to dir.
+func readTree(t testing.TB, dir string) map[string]string {
+	t.Helper()
+
+	files := make(map[string]string)
+
+	err := filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+
+		files[relative(dir, name)] = string(data)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return files
+}
+
+func TestOutputInsideInclude(t *testing.T) {
+	dir := newModule(t, map[string]string{
+		"cmd/server/main.go": mainFile("server"),
+		"cmd/worker/main.go": mainFile("worker"),
+	})
+
+	var first map[string]string
+
+	for run := 1; run <= 2; run++ {
+		c := collected(t, dir, WithInclude("cmd"))
+
+		if got, want := commandNames(c), []string{"server", "worker"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("run %d: expected commands %v, got %v", run, want, got)
+		}
+
+		buildOutput(t, c)
+
+		files := readTree(t, c.outputDir)
+		if first == nil {
+			first = files
+			continue
+		}
+
+		if !reflect.DeepEqual(files, first) {
+			t.Fatalf("second run changed the output: %v became %v", first, files)
+		}
+	}
+}
+
+func TestLogging(t *testing.T) {
+	tests := []struct {
+		name      string
+		verbosity int
+		want      []string
+		notWant   []string
+	}{
+		{
+			name:      "quiet",
+			verbosity: 0,
+			notWant:   []string{"skipping pkg/lib/lib.go"},
+		},
+		{
+			name:      "verbose",
+			verbosity: 1,
+			want: []string{
+				"skipping pkg/lib/lib.go: package lib, not main\n",
+				"skipping cmd/server/main_test.go: test file\n",
+				"skipping directory tools: excluded\n",
+				"wrote ",
+			},
+			notWant: []string{"considering "},
+		},
+		{
+			name:      "debug",
+			verbosity: 2,
+			want: []string{
+				"skipping pkg/lib/lib.go: package lib, not main\n",
+				"skipping cmd/server/README.md: not a Go file\n",
+				"considering cmd/server/main.go\n",
+				"entering directory ",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go":      mainFile("server"),
+				"cmd/server/main_test.go": "package main\n",
+				"cmd/server/README.md":    "server\n",
+				"pkg/lib/lib.go":          "package lib\n",
+				"tools/lint/main.go":      mainFile("lint"),
+			})
+
+			var buf bytes.Buffer
+
+			c := collected(t, dir, WithExclude("tools"), WithLogger(log.New(&buf, "", 0), tt.verbosity))
+			if err := c.Write(); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, want := range tt.want {
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("expected the log to contain %q:\n%s", want, buf.String())
+				}
+			}
+
+			for _, notWant := range tt.notWant {
+				if strings.Contains(buf.String(), notWant) {
+					t.Errorf("expected the log not to contain %q:\n%s", notWant, buf.String())
+				}
+			}
+		})
+	}
+}
+
+func TestEmptyInclude(t *testing.T) {
+	tests := []struct {
+		name     string
+		include  []string
+		allow    bool
+		commands []string
+		err      string
+	}{
+		{name: "library", include: []string{"cmd", "pkg"}, err: "no main packages found in included directories pkg"},
+		{name: "misspelled", include: []string{"cmdd", "pkg"}, err: "no main packages found in included directories cmdd, pkg"},
+		{name: "allowed", include: []string{"cmd", "pkg"}, allow: true, commands: []string{"server"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go": mainFile("server"),
+				"pkg/lib/lib.go":     "package lib\n",
+			})
+
+			c := newCombiner(t, dir, WithInclude(tt.include...), WithAllowEmptyInclude(tt.allow))
+
+			err := c.Collect()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := commandNames(c); !reflect.DeepEqual(got, tt.commands) {
+				t.Fatalf("expected commands %v, got %v", tt.commands, got)
+			}
+		})
+	}
+}
+
+func TestIncludeTests(t *testing.T) {
+	tests := []struct {
+		name    string
+		include bool
+	}{
+		{name: "skip"},
+		{name: "include", include: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go":  mainFile("server"),
+				"cmd/server/state.go": "package main\n\nvar started bool\n",
+				"cmd/server/main_test.go": `package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	started = true
+	os.Exit(m.Run())
+}
+
+func TestStarted(t *testing.T) {
+	if !started {
+		t.Fatal("TestMain did not run")
+	}
+}
+`,
+				"cmd/testonly/main_test.go": "package main\n\nfunc main() {}\n",
+			})
+
+			var buf bytes.Buffer
+
+			c := collected(t, dir, WithIncludeTests(tt.include), WithLogger(log.New(&buf, "", 0), 0))
+
+			if want := "warning: cmd/testonly declares package main only in test files and is skipped\n"; buf.String() != want {
+				t.Fatalf("expected the log %q, got %q", want, buf.String())
+			}
+
+			files, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, ok := files["cmd_server/main_test.go"]; ok != tt.include {
+				t.Fatalf("expected main_test.go to be generated: %v, got %v", tt.include, ok)
+			}
+
+			if err := c.Write(); err != nil {
+				t.Fatal(err)
+			}
+
+			goBinary, err := exec.LookPath("go")
+			if err != nil {
+				t.Skip("go command not found")
+			}
+
+			cmd := exec.Command(goBinary, "test", "./...")
+			cmd.Dir = c.outputDir
+
+			out, err := cmd.CombinedOutput()
+			if err != nil {
+				t.Fatalf("tests of the generated packages failed: %s\n%s", err, out)
+			}
+
+			if ran := strings.Contains(string(out), "ok  \t"+testModule+"/cmd/combined/cmd_server"); ran != tt.include {
+				t.Fatalf("expected the tests of cmd_server to run: %v, got:\n%s", tt.include, out)
+			}
+		})
+	}
+}
+
+func TestMultipleInputs(t *testing.T) {
+	tests := []struct {
+		name     string
+		allow    bool
+		commands []string
+		err      string
+	}{
+		{
+			name: "overlapping commands",
+			err:  `duplicate command "server" found in directories cmd/server, example.com/other/cmd/server`,
+		},
+		{
+			name:     "disambiguated",
+			allow:    true,
+			commands: []string{"cmd.server", "example.com.other.cmd.server", "worker"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go": mainFile("server"),
+			})
+
+			other := newModule(t, map[string]string{
+				"go.mod": "module example.com/other\n\ngo 1.16\n",
+				"cmd/server/main.go": `package main
+
+import (
+	"fmt"
+
+	"example.com/other/lib"
+)
+
+func main() {
+	fmt.Println(lib.Name)
+}
+`,
+				"cmd/worker/main.go": mainFile("worker"),
+				"lib/lib.go":         "package lib\n\nconst Name = \"other\"\n",
+			})
+
+			c := newCombiner(t, dir, WithInputs(other), WithAllowDuplicateCommands(tt.allow), WithEmitGoMod(true))
+
+			err := c.Collect()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := commandNames(c); !reflect.DeepEqual(got, tt.commands) {
+				t.Fatalf("expected commands %v, got %v", tt.commands, got)
+			}
+
+			if m := c.packages["example.com/other/cmd/worker"]; m == nil || m.Module != "example.com/other" {
+				t.Fatalf("expected worker to belong to example.com/other, got %+v", m)
+			}
+
+			binary := buildBinary(t, c)
+
+			if out, code := runBinary(t, binary, "example.com.other.cmd.server"); code != 0 || out != "other\n" {
+				t.Fatalf("expected the command of the other module to run, got %q and exit code %d", out, code)
+			}
+		})
+	}
+}
+
+func TestGetModuleName(t *testing.T) {
+	tests := []struct {
+		name   string
+		fsys   fstest.MapFS
+		module string
+		err    string
+	}{
+		{
+			name:   "valid",
+			fsys:   fstest.MapFS{"go.mod": {Data: []byte("module example.com/fx\n\ngo 1.16\n")}},
+			module: "example.com/fx",
+		},
+		{
+			name: "empty",
+			fsys: fstest.MapFS{"go.mod": {Data: []byte("")}},
+			err:  "go.mod at /src/go.mod has no module directive",
+		},
+		{
+			name: "malformed",
+			fsys: fstest.MapFS{"go.mod": {Data: []byte("go 1.16\nrequire (\n")}},
+			err:  "go.mod at /src/go.mod has no module directive",
+		},
+		{
+			name: "missing",
+			fsys: fstest.MapFS{},
+			err:  "no go.mod found at /src/go.mod; the input directory must be the root of a module",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			module, err := getModuleName(tt.fsys, "/src")
+			if tt.err != "" {
+				var moduleErr *ModuleError
+				if !errors.As(err, &moduleErr) || moduleErr.Path != "/src/go.mod" {
+					t.Fatalf("expected a *ModuleError for /src/go.mod, got %v", err)
+				}
+
+				if err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %q", tt.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if module != tt.module {
+				t.Fatalf("expected module %s, got %s", tt.module, module)
+			}
+		})
+	}
+}
+
+func TestImportPrefix(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  func(root string) string
+		files   map[string]string
+		options []Option
+		want    string
+		err     string
+		build   bool
+	}{
+		{
+			name:   "inside the service directory",
+			output: func(string) string { return "cmd/combined" },
+			want:   testModule + "/cmd/combined",
+			build:  true,
+		},
+		{
+			name:   "nested module",
+			output: func(string) string { return "tools/combined" },
+			files:  map[string]string{"fx/tools/go.mod": "module example.com/tools\n\ngo 1.16\n"},
+			want:   "example.com/tools/combined",
+		},
+		{
+			name:   "outside the service directory",
+			output: func(root string) string { return filepath.Join(root, "other", "combined") },
+			files:  map[string]string{"other/go.mod": "module example.com/other\n\ngo 1.16\n"},
+			want:   "example.com/other/combined",
+		},
+		{
+			name:    "override",
+			output:  func(root string) string { return filepath.Join(root, "other", "combined") },
+			options: []Option{WithImportPrefix("example.com/custom/combined")},
+			want:    "example.com/custom/combined",
+		},
+		{
+			name:    "invalid override",
+			output:  func(string) string { return "cmd/combined" },
+			options: []Option{WithImportPrefix("example.com/bad path")},
+			err:     "invalid import prefix: ",
+		},
+		{
+			name:   "outside any module",
+			output: func(root string) string { return filepath.Join(root, "other", "combined") },
+			err:    " is not inside a module; set an import prefix",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+
+			files := map[string]string{
+				"fx/go.mod":             "module " + testModule + "\n\ngo 1.16\n",
+				"fx/cmd/server/main.go": mainFile("server"),
+			}
+			for name, data := range tt.files {
+				files[name] = data
+			}
+
+			writeFiles(t, root, files)
+
+			c, err := New(filepath.Join(root, "fx"), tt.output(root), tt.options...)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := c.outputImportPath(); got != tt.want {
+				t.Fatalf("expected import prefix %s, got %s", tt.want, got)
+			}
+
+			if err := c.Collect(); err != nil {
+				t.Fatal(err)
+			}
+
+			generated, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := strconv.Quote(tt.want + "/cmd_server"); !strings.Contains(string(generated["main.go"]), want) {
+				t.Fatalf("expected the dispatcher to import %s:\n%s", want, generated["main.go"])
+			}
+
+			if tt.build {
+				buildOutput(t, c)
+			}
+		})
+	}
+}
+
+func TestImportPrefixFS(t *testing.T) {
+	const module = "module example.com/virtual\n\ngo 1.16\n"
+
+	tests := []struct {
+		name    string
+		output  string
+		fsys    fstest.MapFS
+		options []Option
+		want    string
+	}{
+		{
+			name:   "root module",
+			output: "cmd/combined",
+			fsys:   fstest.MapFS{"go.mod": {Data: []byte(module)}},
+			want:   "example.com/virtual/cmd/combined",
+		},
+		{
+			name:   "nested module",
+			output: "tools/combined",
+			fsys: fstest.MapFS{
+				"go.mod":       {Data: []byte(module)},
+				"tools/go.mod": {Data: []byte("module example.com/tools\n\ngo 1.16\n")},
+			},
+			want: "example.com/tools/combined",
+		},
+		{
+			name:    "emitted go.mod",
+			output:  "cmd/combined",
+			fsys:    fstest.MapFS{"go.mod": {Data: []byte(module)}, "cmd/combined/go.mod": {Data: []byte("module example.com/stale\n")}},
+			options: []Option{WithEmitGoMod(true)},
+			want:    "example.com/virtual/cmd/combined",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fsys["cmd/server/main.go"] = &fstest.MapFile{Data: []byte(mainFile("server"))}
+
+			c, err := New("/virtual/root", tt.output, append([]Option{WithFS(tt.fsys)}, tt.options...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := c.outputImportPath(); got != tt.want {
+				t.Fatalf("expected import prefix %s, got %s", tt.want, got)
+			}
+
+			if err := c.Collect(); err != nil {
+				t.Fatal(err)
+			}
+
+			generated, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := strconv.Quote(tt.want + "/cmd_server"); !strings.Contains(string(generated["main.go"]), want) {
+				t.Fatalf("expected the dispatcher to import %s:\n%s", want, generated["main.go"])
+			}
+		})
+	}
+
+	t.Run("build", func(t *testing.T) {
+		// go.mod only exists in fsys until the output is built
+		dir := t.TempDir()
+
+		c, err := New(dir, "cmd/combined", WithFS(fstest.MapFS{
+			"go.mod":             {Data: []byte(module)},
+			"cmd/server/main.go": {Data: []byte(mainFile("server"))},
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.Collect(); err != nil {
+			t.Fatal(err)
+		}
+
+		writeFiles(t, dir, map[string]string{"go.mod": module})
+
+		if out, code := runBinary(t, buildBinary(t, c), "server"); code != 0 || out != "server\n" {
+			t.Fatalf("expected server to print its name, got %q and exit code %d", out, code)
+		}
+	})
+}
+
+func TestSymlinks(t *testing.T) {
+	tests := []struct {
+		name   string
+		follow bool
+		want   []string
+	}{
+		{name: "skipped", want: []string{"server", "worker"}},
+		{name: "followed", follow: true, want: []string{"external", "server", "worker"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go": mainFile("server"),
+				"src/worker.txt":     mainFile("worker"),
+			})
+
+			external := t.TempDir()
+			writeFiles(t, external, map[string]string{"main.go": mainFile("external")})
+
+			links := map[string]string{
+				// loops back to a directory being walked
+				"cmd/server/loop": "..",
+				"cmd/self":        ".",
+				// a symlinked file is always read
+				"cmd/worker/main.go": filepath.Join("..", "..", "src", "worker.txt"),
+				"cmd/external":       external,
+				"cmd/broken":         "missing",
+			}
+
+			for name, target := range links {
+				link := filepath.Join(dir, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(link), 0o755); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := os.Symlink(target, link); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			done := make(chan *Combiner)
+
+			go func() {
+				c, err := New(dir, "out/combined", WithFollowSymlinks(tt.follow))
+				if err == nil {
+					err = c.Collect()
+				}
+
+				if err != nil {
+					t.Error(err)
+				}
+
+				done <- c
+			}()
+
+			var c *Combiner
+
+			select {
+			case c = <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatal("collecting a tree with a symlink loop did not finish")
+			}
+
+			if c == nil {
+				return
+			}
+
+			var got []string
+			for _, m := range c.packages {
+				got = append(got, filepath.Base(m.SourceDir))
+			}
+
+			sort.Strings(got)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected commands from %q, got %q", tt.want, got)
+			}
+
+			buildOutput(t, c)
+		})
+	}
+}
+
+func TestMissingMainFunc(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		options []Option
+		log     string
+	}{
+		{
+			name:  "no main",
+			files: map[string]string{"cmd/tool/tool.go": "package main\n\nfunc helper() {}\n"},
+			log:   "warning: cmd/tool declares package main but no func main and is skipped\n",
+		},
+		{
+			name: "main in a test file",
+			files: map[string]string{
+				"cmd/tool/tool.go":      "package main\n\nfunc helper() {}\n",
+				"cmd/tool/main_test.go": mainFile("tool"),
+			},
+			options: []Option{WithIncludeTests(true)},
+			log:     "warning: cmd/tool declares package main but no func main and is skipped\n",
+		},
+		{
+			name: "main excluded by build tags",
+			files: map[string]string{
+				"cmd/tool/tool.go": "package main\n\nfunc helper() {}\n",
+				"cmd/tool/main.go": "//go:build tool\n\n" + mainFile("tool"),
+			},
+			options: []Option{WithBuildTags("other")},
+			log:     "skipping cmd/tool: func main is excluded by build constraints\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{"cmd/server/main.go": mainFile("server")}
+			for name, data := range tt.files {
+				files[name] = data
+			}
+
+			dir := newModule(t, files)
+
+			var logs bytes.Buffer
+
+			c := collected(t, dir, append([]Option{WithLogger(log.New(&logs, "", 0), 1)}, tt.options...)...)
+
+			if !strings.Contains(logs.String(), tt.log) {
+				t.Fatalf("expected the log to contain %q:\n%s", tt.log, logs.String())
+			}
+
+			if got := commandNames(c); !reflect.DeepEqual(got, []string{"server"}) {
+				t.Fatalf("expected only the server command, got %q", got)
+			}
+
+			buildOutput(t, c)
+		})
+	}
+}
+
+func TestMultipleMainFuncs(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		err   string
+	}{
+		{
+			name: "excluding tags",
+			files: map[string]string{
+				"cmd/tool/main_a.go": "//go:build a\n\n" + mainFile("a"),
+				"cmd/tool/main_b.go": "//go:build !a\n\n" + mainFile("b"),
+			},
+		},
+		{
+			name: "excluding file names",
+			files: map[string]string{
+				"cmd/tool/main_linux.go":   mainFile("linux"),
+				"cmd/tool/main_windows.go": mainFile("windows"),
+			},
+		},
+		{
+			name: "independent tags",
+			files: map[string]string{
+				"cmd/tool/main_a.go": "//go:build a\n\n" + mainFile("a"),
+				"cmd/tool/main_b.go": "//go:build b\n\n" + mainFile("b"),
+			},
+			err: "func main is declared in both %[1]s/cmd/tool/main_a.go and %[1]s/cmd/tool/main_b.go, which can be built together",
+		},
+		{
+			name: "tag and file name",
+			files: map[string]string{
+				"cmd/tool/main_debug.go": "//go:build debug\n\n" + mainFile("debug"),
+				"cmd/tool/main_linux.go": mainFile("linux"),
+			},
+			err: "func main is declared in both %[1]s/cmd/tool/main_debug.go and %[1]s/cmd/tool/main_linux.go, which can be built together",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, tt.files)
+
+			c := newCombiner(t, dir)
+
+			err := c.Collect()
+			if tt.err != "" {
+				if want := fmt.Sprintf(tt.err, filepath.ToSlash(dir)); err == nil || filepath.ToSlash(err.Error()) != want {
+					t.Fatalf("expected error %q, got %v", want, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			buildOutput(t, c)
+		})
+	}
+}
+
+func TestSkipCommands(t *testing.T) {
+	tests := []struct {
+		name string
+		skip []string
+		want []string
+		err  bool
+	}{
+		{name: "none", err: true},
+		{name: "by key", skip: []string{"tools.server"}, want: []string{"cmd/server", "cmd/worker"}},
+		{name: "by name", skip: []string{"server"}, want: []string{"cmd/worker"}},
+		{name: "unknown", skip: []string{"other", "tools.server"}, want: []string{"cmd/server", "cmd/worker"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go":   mainFile("server"),
+				"tools/server/main.go": mainFile("tools server"),
+				"cmd/worker/main.go":   mainFile("worker"),
+			})
+
+			c := newCombiner(t, dir, WithSkipCommands(tt.skip...))
+
+			err := c.Collect()
+			if tt.err {
+				var dup *DuplicateCommandError
+				if !errors.As(err, &dup) || dup.Command != "server" {
+					t.Fatalf("expected a *DuplicateCommandError for server, got %v", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for key := range c.packages {
+				got = append(got, key)
+			}
+
+			sort.Strings(got)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected packages %q, got %q", tt.want, got)
+			}
+
+			files, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, ok := files["tools_server/main.go"]; ok {
+				t.Fatal("the skipped command was written")
+			}
+
+			binary := buildBinary(t, c)
+
+			for _, name := range commandNames(c) {
+				if out, code := runBinary(t, binary, name); code != 0 || out != name+"\n" {
+					t.Fatalf("expected %s to print its name, got %q and exit code %d", name, out, code)
+				}
+			}
+		})
+	}
+}
+
+func TestKeepGoing(t *testing.T) {
+	tests := []struct {
+		name      string
+		keepGoing bool
+		broken    []string
+		want      []string
+	}{
+		{name: "fail fast", broken: []string{"cmd/broken/main.go"}},
+		{name: "one broken command", keepGoing: true, broken: []string{"cmd/broken/main.go"}, want: []string{"server", "tool", "worker"}},
+		{name: "broken helper", keepGoing: true, broken: []string{"cmd/tool/helper.go"}, want: []string{"broken", "server", "worker"}},
+		{
+			name:      "several broken files",
+			keepGoing: true,
+			broken:    []string{"cmd/broken/main.go", "cmd/tool/helper.go", "pkg/lib/lib.go"},
+			want:      []string{"server", "worker"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{
+				"cmd/server/main.go": mainFile("server"),
+				"cmd/worker/main.go": mainFile("worker"),
+				"cmd/broken/main.go": mainFile("broken"),
+				"cmd/tool/main.go":   mainFile("tool"),
+				"cmd/tool/helper.go": "package main\n",
+				"pkg/lib/lib.go":     "package lib\n",
+			}
+			for _, name := range tt.broken {
+				files[name] = "package main\n\nfunc main( {\n"
+			}
+
+			dir := newModule(t, files)
+
+			c := newCombiner(t, dir, WithKeepGoing(tt.keepGoing))
+
+			err := c.Collect()
+			if !tt.keepGoing {
+				var parseErr *ParseError
+				if !errors.As(err, &parseErr) || parseErr.File != filepath.Join(dir, "cmd", "broken", "main.go") {
+					t.Fatalf("expected a *ParseError for cmd/broken/main.go, got %v", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var failed []string
+			for _, err := range c.ParseErrors() {
+				var parseErr *ParseError
+				if !errors.As(err, &parseErr) {
+					t.Fatalf("expected a *ParseError, got %v", err)
+				}
+
+				failed = append(failed, relative(dir, parseErr.File))
+			}
+
+			sort.Strings(failed)
+
+			if !reflect.DeepEqual(failed, tt.broken) {
+				t.Fatalf("expected parse errors for %q, got %q", tt.broken, failed)
+			}
+
+			if got := commandNames(c); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected commands %q, got %q", tt.want, got)
+			}
+
+			buildOutput(t, c)
+		})
+	}
+}
+
+func TestOutputDir(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  func(root string) string
+		options []Option
+		want    string
+		prefix  string
+		err     string
+	}{
+		{
+			name:   "relative",
+			output: func(string) string { return "cmd/combined" },
+			want:   "fx/cmd/combined",
+			prefix: testModule + "/cmd/combined",
+		},
+		{
+			name:   "relative and unclean",
+			output: func(string) string { return "./tools/../cmd/combined/" },
+			want:   "fx/cmd/combined",
+			prefix: testModule + "/cmd/combined",
+		},
+		{
+			name:   "absolute inside the service directory",
+			output: func(root string) string { return filepath.Join(root, "fx", "cmd", "combined") },
+			want:   "fx/cmd/combined",
+			prefix: testModule + "/cmd/combined",
+		},
+		{
+			name:    "absolute outside the service directory",
+			output:  func(root string) string { return filepath.Join(root, "out", "combined") },
+			options: []Option{WithImportPrefix("example.com/out"), WithEmitGoMod(true)},
+			want:    "out/combined",
+			prefix:  "example.com/out",
+		},
+		{
+			name:    "relative outside the service directory",
+			output:  func(string) string { return filepath.Join("..", "out", "combined") },
+			options: []Option{WithImportPrefix("example.com/out"), WithEmitGoMod(true)},
+			want:    "out/combined",
+			prefix:  "example.com/out",
+		},
+		{
+			name:   "containing the service directory",
+			output: func(root string) string { return root },
+			err:    "output directory %s contains the service directory",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+
+			writeFiles(t, root, map[string]string{
+				"fx/go.mod":             "module " + testModule + "\n\ngo 1.16\n",
+				"fx/cmd/server/main.go": mainFile("server"),
+			})
+
+			c, err := New(filepath.Join(root, "fx"), tt.output(root), tt.options...)
+			if tt.err != "" {
+				if want := fmt.Sprintf(tt.err, root); err == nil || err.Error() != want {
+					t.Fatalf("expected error %q, got %v", want, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := relative(root, c.outputDir); got != tt.want {
+				t.Fatalf("expected output directory %s, got %s", tt.want, got)
+			}
+
+			if got := c.outputImportPath(); got != tt.prefix {
+				t.Fatalf("expected import prefix %s, got %s", tt.prefix, got)
+			}
+
+			if err := c.Collect(); err != nil {
+				t.Fatal(err)
+			}
+
+			if out, code := runBinary(t, buildBinary(t, c), "server"); code != 0 || out != "server\n" {
+				t.Fatalf("expected server to print its name, got %q and exit code %d", out, code)
+			}
+
+			// the written output is never collected again
+			c, err = New(filepath.Join(root, "fx"), tt.output(root), tt.options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := c.Collect(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := commandNames(c); !reflect.DeepEqual(got, []string{"server"}) {
+				t.Fatalf("expected only the server command after writing, got %q", got)
+			}
+		})
+	}
+}
+
+func TestAlwaysIgnore(t *testing.T) {
+	files := map[string]string{
+		"cmd/server/main.go":                    mainFile("server"),
+		"cmd/server/vendor/x/main.go":           mainFile("vendored"),
+		".github/actions/check/main.go":         mainFile("check"),
+		"web/node_modules/tool/main.go":         mainFile("node"),
+		"node_modules/other/main.go":            mainFile("other"),
+		"tools/legacy/main.go":                  mainFile("legacy"),
+		"cmd/tools/legacy/main.go":              mainFile("nested legacy"),
+		"testdata/fixture/main.go":              mainFile("fixture"),
+		"internal/testdata/fixture/cmd/main.go": mainFile("internal fixture"),
+	}
+
+	tests := []struct {
+		name   string
+		ignore []string
+		want   []string
+	}{
+		{
+			name: "defaults",
+			want: []string{"cmd/server", "cmd/tools/legacy", "internal/testdata/fixture/cmd", "node_modules/other", "testdata/fixture", "tools/legacy", "web/node_modules/tool"},
+		},
+		{
+			name:   "name at any depth",
+			ignore: []string{"node_modules", "testdata"},
+			want:   []string{"cmd/server", "cmd/tools/legacy", "tools/legacy"},
+		},
+		{
+			name:   "path from the input directory",
+			ignore: []string{"tools/legacy/"},
+			want:   []string{"cmd/server", "cmd/tools/legacy", "internal/testdata/fixture/cmd", "node_modules/other", "testdata/fixture", "web/node_modules/tool"},
+		},
+		{
+			name:   "native separators",
+			ignore: []string{filepath.Join("tools", "legacy")},
+			want:   []string{"cmd/server", "cmd/tools/legacy", "internal/testdata/fixture/cmd", "node_modules/other", "testdata/fixture", "web/node_modules/tool"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, files)
+
+			c := collected(t, dir, WithAllowDuplicateCommands(true), WithAlwaysIgnore(tt.ignore...))
+
+			var got []string
+			for key := range c.packages {
+				got = append(got, key)
+			}
+
+			sort.Strings(got)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+
+			buildOutput(t, c)
+		})
+	}
+}
+
+func TestNestedVendor(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+	}{
+		{name: "sub/vendor", files: map[string]string{"sub/vendor/example.com/tool/main.go": mainFile("tool")}},
+		{name: "deeply nested", files: map[string]string{"a/b/c/vendor/tool/main.go": mainFile("tool")}},
+		{name: "vendor as the package", files: map[string]string{"sub/vendor/main.go": mainFile("vendor")}},
+		{name: "sub/.idea", files: map[string]string{"sub/.idea/tool/main.go": mainFile("tool")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{"cmd/server/main.go": mainFile("server")}
+			for name, data := range tt.files {
+				files[name] = data
+			}
+
+			dir := newModule(t, files)
+
+			c := collected(t, dir)
+
+			if got := commandNames(c); !reflect.DeepEqual(got, []string{"server"}) {
+				t.Fatalf("expected only server to be collected, got %v", got)
+			}
+
+			commands, err := c.Discover()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(commands) != 1 || commands[0].Key != "cmd/server" {
+				t.Fatalf("expected Discover to find only cmd/server, got %+v", commands)
+			}
+
+			buildOutput(t, c)
+		})
+	}
+}
+
+func TestEmptyTree(t *testing.T) {
+	trees := map[string]map[string]string{
+		"go.mod only":      nil,
+		"library only":     {"pkg/lib/lib.go": "package lib\n"},
+		"test files only":  {"cmd/server/main_test.go": "package main\n"},
+		"ignored commands": {"vendor/tool/main.go": mainFile("tool"), ".idea/fixture/main.go": mainFile("fixture")},
+	}
+
+	modes := []struct {
+		name string
+		opts []Option
+	}{
+		{name: "argv0"},
+		{name: "subcommand", opts: []Option{WithDispatch(DispatchSubcommand)}},
+		{name: "registry", opts: []Option{WithDispatch(DispatchRegistry)}},
+	}
+
+	for name, files := range trees {
+		t.Run(name, func(t *testing.T) {
+			dir := newModule(t, files)
+
+			err := newCombiner(t, dir).Collect()
+			if want := "no main packages found under " + dir; err == nil || err.Error() != want {
+				t.Fatalf("expected error %q, got %v", want, err)
+			}
+
+			for _, mode := range modes {
+				t.Run(mode.name, func(t *testing.T) {
+					c := collected(t, dir, append([]Option{WithAllowEmpty(true)}, mode.opts...)...)
+
+					if len(c.packages) != 0 {
+						t.Fatalf("expected no commands, got %v", commandNames(c))
+					}
+
+					// the dispatcher still builds, and knows no command
+					if out, code := runBinary(t, buildBinary(t, c), "server", "server"); code == 0 {
+						t.Fatalf("expected server to be an unknown command, got %q", out)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	files := map[string]string{
+		"tools/main.go":                mainFile("tools"),
+		"cmd/server/main.go":           mainFile("server"),
+		"cmd/admin/users/main.go":      mainFile("users"),
+		"cmd/server/tools/gen/main.go": mainFile("gen"),
+	}
+
+	tests := []struct {
+		depth int
+		want  []string
+	}{
+		{depth: 0, want: []string{"gen", "server", "tools", "users"}},
+		{depth: 1, want: []string{"tools"}},
+		{depth: 2, want: []string{"server", "tools"}},
+		{depth: 3, want: []string{"server", "tools", "users"}},
+		{depth: 4, want: []string{"gen", "server", "tools", "users"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.depth), func(t *testing.T) {
+			dir := newModule(t, files)
+
+			var logs bytes.Buffer
+
+			c := collected(t, dir, WithMaxDepth(tt.depth), WithLogger(log.New(&logs, "", 0), 1))
+
+			if got := commandNames(c); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected commands %v, got %v", tt.want, got)
+			}
+
+			if tt.depth == 2 && !strings.Contains(logs.String(), "skipping directory cmd/admin/users: below the maximum depth of 2\n") {
+				t.Fatalf("expected the skipped directory to be logged:\n%s", logs.String())
+			}
+
+			buildOutput(t, c)
+		})
+	}
+
+	t.Run("negative", func(t *testing.T) {
+		dir := newModule(t, files)
+
+		if _, err := New(dir, "cmd/combined", WithMaxDepth(-1)); err == nil || err.Error() != "maximum depth must not be negative, got -1" {
+			t.Fatalf("expected a negative depth to be rejected, got %v", err)
+		}
+	})
+}
+
+func TestHelperFiles(t *testing.T) {
+	const main = "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(greeting())\n}\n"
+	const helpers = "package main\n\nfunc greeting() string {\n\treturn \"hello\"\n}\n"
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  []string
+		log   string
+	}{
+		{
+			name:  "helpers",
+			files: map[string]string{"cmd/server/helpers.go": helpers},
+			want:  []string{"cmd_server/helpers.go", "cmd_server/main.go"},
+		},
+		{
+			name: "helpers in several files",
+			files: map[string]string{
+				"cmd/server/helpers.go": "package main\n\nfunc greeting() string {\n\treturn word\n}\n",
+				"cmd/server/words.go":   "package main\n\nconst word = \"hello\"\n",
+			},
+			want: []string{"cmd_server/helpers.go", "cmd_server/main.go", "cmd_server/words.go"},
+		},
+		{
+			name: "file of another package",
+			files: map[string]string{
+				"cmd/server/helpers.go": helpers,
+				"cmd/server/doc.go":     "// Package server serves.\npackage server\n",
+			},
+			want: []string{"cmd_server/helpers.go", "cmd_server/main.go"},
+			log:  "warning: cmd/server/doc.go is package server but cmd/server is package main, so the go tool can't build it; the file is not combined\n",
+		},
+		{
+			name: "file of another package kept out of the build",
+			files: map[string]string{
+				"cmd/server/helpers.go": helpers,
+				"cmd/server/doc.go":     "//go:build ignore\n\npackage server\n",
+			},
+			want: []string{"cmd_server/helpers.go", "cmd_server/main.go"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{"cmd/server/main.go": main}
+			for name, data := range tt.files {
+				files[name] = data
+			}
+
+			dir := newModule(t, files)
+
+			var logs bytes.Buffer
+
+			c := collected(t, dir, WithLogger(log.New(&logs, "", 0), 0))
+
+			if tt.log == "" && logs.Len() > 0 || !strings.Contains(logs.String(), tt.log) {
+				t.Fatalf("expected the log to be %q, got:\n%s", tt.log, logs.String())
+			}
+
+			generated, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for name := range generated {
+				if strings.HasPrefix(name, "cmd_server/") {
+					got = append(got, name)
+				}
+			}
+
+			sort.Strings(got)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected files %v, got %v", tt.want, got)
+			}
+
+			if out, code := runBinary(t, buildBinary(t, c), "server"); code != 0 || out != "hello\n" {
+				t.Fatalf("expected server to print hello, got %q and exit code %d", out, code)
+			}
+		})
+	}
+}
+
+func TestMatchSegments(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{pattern: "internal", name: "internal", want: true},
+		{pattern: "internal", name: "internal/tool", want: false},
+		{pattern: "**/internal", name: "cmd/internal", want: true},
+		{pattern: "**/internal", name: "internal", want: true},
+		{pattern: "**/internal", name: "cmd/internal/tool", want: false},
+		{pattern: "cmd/**/tool", name: "cmd/a/b/tool", want: true},
+		{pattern: "cmd/**/tool", name: "cmd/tool", want: true},
+		{pattern: "cmd/**/tool", name: "cmd/a/other", want: false},
+		{pattern: "internal/**", name: "internal/a/b", want: true},
+		{pattern: "internal/**", name: "internal", want: true},
+		{pattern: "internal/**", name: "other/a", want: false},
+		{pattern: "**", name: "a/b/c", want: true},
+		{pattern: "tools/*", name: "tools/x", want: true},
+		{pattern: "tools/*", name: "tools", want: false},
+		{pattern: "tools/*", name: "tools/x/y", want: false},
+		{pattern: "*", name: "a/b", want: false},
+		{pattern: "cmd/*-tool", name: "cmd/admin-tool", want: true},
+		{pattern: "cmd/?", name: "cmd/a", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.name, func(t *testing.T) {
+			got, err := matchSegments(strings.Split(tt.pattern, "/"), strings.Split(tt.name, "/"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Fatalf("expected %q to match %q: %v, got %v", tt.pattern, tt.name, tt.want, got)
+			}
+		})
+	}
+
+	t.Run("bad pattern", func(t *testing.T) {
+		dir := newModule(t, map[string]string{"cmd/server/main.go": mainFile("server")})
+
+		if _, err := New(dir, "cmd/combined", WithExclude("cmd/[")); err == nil || !strings.Contains(err.Error(), `invalid exclude pattern "cmd/["`) {
+			t.Fatalf("expected a bad pattern to be rejected, got %v", err)
+		}
+	})
+}
+
+func TestExclude(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		commands []string
+	}{
+		{
+			name:     "nothing excluded",
+			commands: []string{"internal-tool", "nested", "server", "x", "y"},
+		},
+		{
+			name:     "trailing globstar",
+			opts:     []Option{WithExclude("internal/**")},
+			commands: []string{"nested", "server", "x", "y"},
+		},
+		{
+			name:     "leading globstar",
+			opts:     []Option{WithExclude("**/nested")},
+			commands: []string{"internal-tool", "server", "x", "y"},
+		},
+		{
+			name:     "exclude wins over include",
+			opts:     []Option{WithInclude("tools"), WithExclude("tools/*")},
+			commands: []string{},
+		},
+		{
+			name:     "exclude part of an include",
+			opts:     []Option{WithInclude("tools"), WithExclude("tools/x")},
+			commands: []string{"y"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go":             mainFile("server"),
+				"cmd/a/b/nested/main.go":         mainFile("nested"),
+				"internal/internal-tool/main.go": mainFile("internal-tool"),
+				"tools/x/main.go":                mainFile("x"),
+				"tools/y/main.go":                mainFile("y"),
+			})
+
+			opts := append([]Option{WithAllowEmpty(true), WithAllowEmptyInclude(true)}, tt.opts...)
+
+			c := collected(t, dir, opts...)
+
+			if got := commandNames(c); !reflect.DeepEqual(got, tt.commands) {
+				t.Fatalf("expected commands %v, got %v", tt.commands, got)
+			}
+
+			if len(tt.commands) > 0 {
+				buildOutput(t, c)
+			}
+		})
+	}
+}
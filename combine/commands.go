@@ -0,0 +1,74 @@
+package combine
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"path"
+	"sort"
+)
+
+// commandsPackage is the name of the generated package that maps command
+// names to their main functions, for use without the dispatcher.
+const commandsPackage = "commands"
+
+// CommandsImportPath returns the import path of the package generated with
+// WithEmitCommands.
+func (c *Combiner) CommandsImportPath() string {
+	return path.Join(c.outputImportPath(), commandsPackage)
+}
+
+// commandsSource returns the commands package for outputs. With context the
+// main functions it holds take a context.Context.
+func (c *Combiner) commandsSource(outputs []*MainPackage) ([]byte, error) {
+	var buf bytes.Buffer
+	if c.standaloneTag != "" {
+		_, _ = fmt.Fprintf(&buf, "//go:build %s\n// +build %s\n\n", c.standaloneTag, c.standaloneTag)
+	}
+
+	_, _ = buf.WriteString(generatedHeader + "\n\n")
+	_, _ = buf.WriteString(`// Package commands maps command names to the main functions of the
+// combined commands, so they can be run in-process without the dispatcher.
+package commands
+`)
+
+	mainType := "func()"
+	if c.contextEntrypoint {
+		mainType = "func(context.Context)"
+	}
+
+	byImportPath := append([]*MainPackage(nil), outputs...)
+	sort.Slice(byImportPath, func(i, j int) bool {
+		return byImportPath[i].ImportPath < byImportPath[j].ImportPath
+	})
+
+	_, _ = buf.WriteString("\nimport (\n")
+
+	if c.contextEntrypoint {
+		_, _ = buf.WriteString("\t\"context\"\n\n")
+	}
+
+	for _, m := range byImportPath {
+		_, _ = fmt.Fprintf(&buf, "\t%s %q\n", m.PackageName, m.ImportPath)
+	}
+
+	_, _ = fmt.Fprintf(&buf, ")\n\n// Commands maps each command name to its main function.\nvar Commands = map[string]%s{\n", mainType)
+
+	for _, m := range outputs {
+		main := m.PackageName + "." + c.entrypointName
+		if c.contextEntrypoint && !m.context {
+			main = fmt.Sprintf("func(context.Context) { %s() }", main)
+		}
+
+		_, _ = fmt.Fprintf(&buf, "\t%q: %s,\n", m.Command, main)
+	}
+
+	_, _ = buf.WriteString("}\n")
+
+	out, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, &FormatError{File: path.Join(commandsPackage, "commands.go"), Source: buf.Bytes(), Err: err}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,139 @@
+package combine
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// commandsCaller is a main package that prints the sorted keys of the
+// generated Commands map, then runs the command named by its argument
+// in-process. call is how a main function is called.
+const commandsCaller = `package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"%s"
+)
+
+var _ = context.Background
+
+func main() {
+	var names []string
+	for name := range commands.Commands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	fmt.Println(names)
+
+	commands.Commands[os.Args[1]]%s
+}
+`
+
+func TestEmitCommands(t *testing.T) {
+	contextMain := "package main\n\nimport (\n\t\"context\"\n\t\"fmt\"\n)\n\nfunc main(ctx context.Context) {\n\tfmt.Println(\"worker\", ctx.Err())\n}\n"
+
+	tests := []struct {
+		name   string
+		opts   []Option
+		worker string
+		call   string
+		tags   []string
+		want   map[string]string
+	}{
+		{
+			name: "plain",
+			call: "()",
+			want: map[string]string{"server": "[server worker]\nserver\n", "worker": "[server worker]\nworker\n"},
+		},
+		{
+			name:   "context",
+			opts:   []Option{WithContextEntrypoint(true)},
+			worker: contextMain,
+			call:   "(context.Background())",
+			want:   map[string]string{"server": "[server worker]\nserver\n", "worker": "[server worker]\nworker <nil>\n"},
+		},
+		{
+			name: "subcommand dispatch",
+			opts: []Option{WithDispatch(DispatchSubcommand)},
+			call: "()",
+			want: map[string]string{"worker": "[server worker]\nworker\n"},
+		},
+		{
+			name: "standalone tag",
+			opts: []Option{WithWrapper(true), WithStandaloneTag("combined")},
+			call: "()",
+			tags: []string{"-tags", "combined"},
+			want: map[string]string{"server": "[server worker]\nserver\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			worker := tt.worker
+			if worker == "" {
+				worker = mainFile("worker")
+			}
+
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go": mainFile("server"),
+				"cmd/worker/main.go": worker,
+			})
+
+			c := collected(t, dir, append([]Option{WithEmitCommands(true)}, tt.opts...)...)
+
+			if want := testModule + "/cmd/combined/commands"; c.CommandsImportPath() != want {
+				t.Fatalf("expected the commands package at %s, got %s", want, c.CommandsImportPath())
+			}
+
+			// the dispatcher still builds next to the commands package
+			buildBinary(t, c, tt.tags...)
+
+			writeFiles(t, dir, map[string]string{"cmd/app/main.go": fmt.Sprintf(commandsCaller, c.CommandsImportPath(), tt.call)})
+
+			binary := filepath.Join(t.TempDir(), "app")
+
+			cmd := exec.Command("go", append(append([]string{"build", "-o", binary}, tt.tags...), "./cmd/app")...)
+			cmd.Dir = dir
+
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("failed to build a caller of the commands package: %s\n%s", err, out)
+			}
+
+			for name, want := range tt.want {
+				if out, code := runBinary(t, binary, "", name); code != 0 || out != want {
+					t.Fatalf("expected %q for %s, got %q and exit code %d", want, name, out, code)
+				}
+			}
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		c := collected(t, newModule(t, map[string]string{"cmd/server/main.go": mainFile("server")}))
+
+		generated, err := c.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := generated["commands/commands.go"]; ok {
+			t.Fatal("unexpected commands package without WithEmitCommands")
+		}
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		dir := newModule(t, map[string]string{"commands/main.go": mainFile("commands")})
+
+		_, err := collected(t, dir, WithEmitCommands(true)).Generate()
+		if err == nil || !strings.Contains(err.Error(), "conflicts with the generated commands package") {
+			t.Fatalf("expected a conflict with the commands package, got %v", err)
+		}
+	})
+}
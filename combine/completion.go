@@ -0,0 +1,74 @@
+package combine
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Shell selects a shell to write a completion script for.
+type Shell string
+
+const (
+	// ShellBash writes completion.bash, to be sourced.
+	ShellBash Shell = "bash"
+	// ShellZsh writes _<binary>, to be placed on $fpath.
+	ShellZsh Shell = "zsh"
+	// ShellFish writes <binary>.fish, to be placed in a fish completions
+	// directory.
+	ShellFish Shell = "fish"
+)
+
+var nonIdentifier = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// completionName returns the name of the completion script for shell.
+func (c *Combiner) completionName(shell Shell) string {
+	binary := filepath.Base(c.outputDir)
+
+	switch shell {
+	case ShellZsh:
+		return "_" + binary
+	case ShellFish:
+		return binary + ".fish"
+	default:
+		return "completion.bash"
+	}
+}
+
+// completionScript generates a script for shell that completes the first
+// argument of the combined binary to a command name.
+func (c *Combiner) completionScript(shell Shell, outputs []*MainPackage) []byte {
+	binary := filepath.Base(c.outputDir)
+
+	var names []string
+	for _, m := range outputs {
+		names = append(names, m.Command)
+	}
+
+	var buf bytes.Buffer
+
+	switch shell {
+	case ShellZsh:
+		var escaped []string
+		for _, name := range names {
+			escaped = append(escaped, strings.ReplaceAll(name, " ", `\ `))
+		}
+
+		_, _ = fmt.Fprintf(&buf, "#compdef %s\n# %s\n\n", binary, generatedNotice)
+		_, _ = fmt.Fprintf(&buf, "_arguments %s '*::arguments:_files'\n", shellQuote("1:command:("+strings.Join(escaped, " ")+")"))
+	case ShellFish:
+		_, _ = fmt.Fprintf(&buf, "# %s\n\n", generatedNotice)
+		_, _ = fmt.Fprintf(&buf, "complete -c %s -f -n __fish_use_subcommand -a %s\n", shellQuote(binary), shellQuote(strings.Join(names, " ")))
+	default:
+		function := "_" + nonIdentifier.ReplaceAllString(binary, "_")
+
+		_, _ = fmt.Fprintf(&buf, "# %s\n\n", generatedNotice)
+		_, _ = fmt.Fprintf(&buf, "%s() {\n\tif [ \"$COMP_CWORD\" -eq 1 ]; then\n", function)
+		_, _ = fmt.Fprintf(&buf, "\t\tCOMPREPLY=($(compgen -W %s -- \"${COMP_WORDS[1]}\"))\n", shellQuote(strings.Join(names, " ")))
+		_, _ = fmt.Fprintf(&buf, "\tfi\n}\n\ncomplete -o default -F %s %s\n", function, shellQuote(binary))
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,69 @@
+package combine
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompletion(t *testing.T) {
+	tests := []struct {
+		shell Shell
+		file  string
+		want  string
+	}{
+		{shell: ShellBash, file: "completion.bash", want: "compgen -W 'alpha server zworker'"},
+		{shell: ShellZsh, file: "_combined", want: "#compdef combined\n"},
+		{shell: ShellFish, file: "combined.fish", want: "complete -c 'combined' -f -n __fish_use_subcommand -a 'alpha server zworker'\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.shell), func(t *testing.T) {
+			dir := newModule(t, commandTree)
+
+			c := collected(t, dir, WithDispatch(DispatchSubcommand), WithSortBy(SortByCommand), WithEmitCompletion(tt.shell))
+			if err := c.Write(); err != nil {
+				t.Fatal(err)
+			}
+
+			script := readTree(t, c.outputDir)[tt.file]
+			if !strings.Contains(script, tt.want) {
+				t.Fatalf("expected the %s completion to contain %q:\n%s", tt.shell, tt.want, script)
+			}
+
+			for _, name := range commandNames(c) {
+				if !strings.Contains(script, name) {
+					t.Fatalf("command %s is missing from the %s completion:\n%s", name, tt.shell, script)
+				}
+			}
+
+			if tt.shell != ShellBash {
+				return
+			}
+
+			bash, err := exec.LookPath("bash")
+			if err != nil {
+				t.Skip("bash is not installed")
+			}
+
+			// complete the first argument as bash would
+			out, err := exec.Command(bash, "-c", `source "$1"; COMP_WORDS=(combined z); COMP_CWORD=1; _combined; echo "${COMPREPLY[@]}"`,
+				"bash", filepath.Join(c.outputDir, tt.file)).CombinedOutput()
+			if err != nil {
+				t.Fatalf("failed to run the bash completion: %v\n%s", err, out)
+			}
+
+			if string(out) != "zworker\n" {
+				t.Fatalf("expected z to complete to zworker, got %q", out)
+			}
+		})
+	}
+}
+
+func TestCompletionDispatch(t *testing.T) {
+	_, err := New(newModule(t, commandTree), "cmd/combined", WithEmitCompletion(ShellBash))
+	if err == nil || err.Error() != "completion scripts require subcommand dispatch" {
+		t.Fatalf("expected completion without subcommand dispatch to fail, got %v", err)
+	}
+}
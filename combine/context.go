@@ -0,0 +1,44 @@
+package combine
+
+import (
+	"go/ast"
+)
+
+// takesContext reports whether the main function declared in sources has
+// the signature func main(ctx context.Context), which only builds when
+// combined with WithContextEntrypoint.
+func takesContext(sources []*sourceFile) bool {
+	for _, src := range sources {
+		for _, decl := range src.file.Decls {
+			fd, ok := decl.(*ast.FuncDecl)
+			if !ok || fd.Recv != nil || fd.Name.Name != "main" {
+				continue
+			}
+
+			params := fd.Type.Params.List
+			if len(params) != 1 || len(params[0].Names) > 1 {
+				return false
+			}
+
+			sel, ok := params[0].Type.(*ast.SelectorExpr)
+			if !ok || sel.Sel.Name != "Context" {
+				return false
+			}
+
+			pkg, ok := sel.X.(*ast.Ident)
+			if !ok {
+				return false
+			}
+
+			for _, spec := range src.file.Imports {
+				if importPath(spec) == "context" && importName(spec) == pkg.Name {
+					return true
+				}
+			}
+
+			return false
+		}
+	}
+
+	return false
+}
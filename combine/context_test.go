@@ -0,0 +1,143 @@
+package combine
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestContextEntrypoint(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		context bool
+		out     string
+		err     string
+	}{
+		{
+			name:    "no arguments",
+			source:  mainFile("plain"),
+			context: true,
+			out:     "plain\n",
+		},
+		{
+			name:    "context",
+			source:  "package main\n\nimport (\n\t\"context\"\n\t\"fmt\"\n)\n\nfunc main(ctx context.Context) {\n\tfmt.Println(ctx.Err())\n}\n",
+			context: true,
+			out:     "<nil>\n",
+		},
+		{
+			name:    "renamed import",
+			source:  "package main\n\nimport (\n\tstdcontext \"context\"\n\t\"fmt\"\n)\n\nfunc main(ctx stdcontext.Context) {\n\tfmt.Println(ctx.Err())\n}\n",
+			context: true,
+			out:     "<nil>\n",
+		},
+		{
+			name:   "context without the option",
+			source: "package main\n\nimport \"context\"\n\nfunc main(ctx context.Context) {}\n",
+			err:    "func main must have no arguments and no return values",
+		},
+		{
+			name:    "other argument",
+			source:  "package main\n\nfunc main(name string) {}\n",
+			context: true,
+			err:     "func main must have no arguments and no return values",
+		},
+		{
+			name:    "return value",
+			source:  "package main\n\nimport \"context\"\n\nfunc main(ctx context.Context) error {\n\treturn nil\n}\n",
+			context: true,
+			err:     "func main must have no arguments and no return values",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/command/main.go": tt.source,
+				"cmd/other/main.go":   mainFile("other"),
+			})
+
+			c := newCombiner(t, dir, WithContextEntrypoint(tt.context))
+
+			err := c.Collect()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			binary := buildBinary(t, c)
+
+			if out, code := runBinary(t, binary, "command"); code != 0 || out != tt.out {
+				t.Fatalf("expected command to print %q, got %q and exit code %d", tt.out, out, code)
+			}
+
+			if out, code := runBinary(t, binary, "other"); code != 0 || out != "other\n" {
+				t.Fatalf("expected other to print its name, got %q and exit code %d", out, code)
+			}
+		})
+	}
+}
+
+func TestContextCanceled(t *testing.T) {
+	dir := newModule(t, map[string]string{
+		"cmd/server/main.go": `package main
+
+import (
+	"context"
+	"fmt"
+)
+
+func main(ctx context.Context) {
+	fmt.Println("ready")
+	<-ctx.Done()
+	fmt.Println("stopped:", ctx.Err())
+}
+`,
+	})
+
+	binary := buildBinary(t, collected(t, dir, WithContextEntrypoint(true)))
+
+	link := filepath.Join(filepath.Dir(binary), "server")
+	if err := os.Symlink(filepath.Base(binary), link); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(link)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := bufio.NewScanner(stdout)
+	if !lines.Scan() || lines.Text() != "ready" {
+		t.Fatalf("expected server to print ready, got %q", lines.Text())
+	}
+
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		t.Fatal(err)
+	}
+
+	if !lines.Scan() || lines.Text() != "stopped: context canceled" {
+		t.Fatalf("expected the interrupt to cancel the context, got %q", lines.Text())
+	}
+
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,70 @@
+package combine
+
+import (
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// collectCopies reads every file in the directory of m, or below it, with
+// an extension passed to WithCopyExtensions into m.Copied. Like embedding,
+// it skips directories starting with . or _ and nested modules, as well as
+// the directories that are always ignored and the output directory.
+func (c *Combiner) collectCopies(m *MainPackage) error {
+	if len(c.copyExtensions) == 0 {
+		return nil
+	}
+
+	dir := m.SourceDir
+
+	return fs.WalkDir(m.input.fsys, dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if name == dir {
+				return nil
+			}
+
+			if strings.HasPrefix(d.Name(), ".") || strings.HasPrefix(d.Name(), "_") {
+				return fs.SkipDir
+			}
+
+			if c.inOutputDir(filepath.Join(m.input.dir, filepath.FromSlash(name))) {
+				return fs.SkipDir
+			}
+
+			for _, ignore := range c.alwaysIgnore {
+				if alwaysIgnored(ignore, name) {
+					return fs.SkipDir
+				}
+			}
+
+			if _, err := fs.Stat(m.input.fsys, path.Join(name, goModName)); err == nil {
+				return fs.SkipDir
+			}
+
+			return nil
+		}
+
+		if !d.Type().IsRegular() || !c.copyExtensions[path.Ext(name)] {
+			return nil
+		}
+
+		data, err := fs.ReadFile(m.input.fsys, name)
+		if err != nil {
+			return err
+		}
+
+		rel := name
+		if dir != "." {
+			rel = strings.TrimPrefix(name, dir+"/")
+		}
+
+		m.Copied[rel] = data
+
+		return nil
+	})
+}
@@ -0,0 +1,140 @@
+package combine
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCopyExtensions(t *testing.T) {
+	const schema = "CREATE TABLE users (\n\tid INTEGER PRIMARY KEY\n);\n"
+
+	tests := []struct {
+		name  string
+		exts  []string
+		files map[string]string
+		// want are the copied and embedded files of cmd_server and their
+		// contents
+		want map[string]string
+	}{
+		{
+			name:  "sql",
+			exts:  []string{".sql"},
+			files: map[string]string{"cmd/server/schema.sql": schema},
+			want:  map[string]string{"cmd_server/schema.sql": schema},
+		},
+		{
+			name: "layout",
+			exts: []string{".sql", "tmpl"},
+			files: map[string]string{
+				"cmd/server/schema.sql":           schema,
+				"cmd/server/queries/users.sql":    "SELECT id FROM users;\n",
+				"cmd/server/templates/index.tmpl": "{{ .Name }}\n",
+				"cmd/server/README.md":            "server\n",
+			},
+			want: map[string]string{
+				"cmd_server/schema.sql":           schema,
+				"cmd_server/queries/users.sql":    "SELECT id FROM users;\n",
+				"cmd_server/templates/index.tmpl": "{{ .Name }}\n",
+			},
+		},
+		{
+			name: "skipped directories",
+			exts: []string{".sql"},
+			files: map[string]string{
+				"cmd/server/schema.sql":        schema,
+				"cmd/server/.cache/old.sql":    schema,
+				"cmd/server/_old/old.sql":      schema,
+				"cmd/server/nested/go.mod":     "module example.com/nested\n\ngo 1.16\n",
+				"cmd/server/nested/nested.sql": schema,
+			},
+			want: map[string]string{"cmd_server/schema.sql": schema},
+		},
+		{
+			name: "embedded and copied",
+			exts: []string{".sql"},
+			files: map[string]string{
+				"cmd/server/main.go":    "package main\n\nimport (\n\t_ \"embed\"\n\t\"fmt\"\n)\n\n//go:embed schema.sql\nvar schema string\n\nfunc main() {\n\tfmt.Print(schema)\n}\n",
+				"cmd/server/schema.sql": schema,
+			},
+			want: map[string]string{"cmd_server/schema.sql": schema},
+		},
+		{
+			name:  "no extensions",
+			files: map[string]string{"cmd/server/schema.sql": schema},
+			want:  map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{"cmd/server/main.go": mainFile("server")}
+			for name, data := range tt.files {
+				files[name] = data
+			}
+
+			c := collected(t, newModule(t, files), WithCopyExtensions(tt.exts...))
+
+			if err := c.Write(); err != nil {
+				t.Fatal(err)
+			}
+
+			generated, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make(map[string]string)
+			for name, data := range generated {
+				if strings.HasPrefix(name, "cmd_server/") && !strings.HasSuffix(name, ".go") {
+					got[name] = string(data)
+				}
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected copied files %v, got %v", tt.want, got)
+			}
+
+			// the files are written verbatim
+			for name, want := range tt.want {
+				data, err := ioutil.ReadFile(filepath.Join(c.outputDir, filepath.FromSlash(name)))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if string(data) != want {
+					t.Fatalf("expected %s to be copied verbatim, got %q", name, data)
+				}
+			}
+
+			want := "server\n"
+			if strings.Contains(files["cmd/server/main.go"], "go:embed") {
+				want = schema
+			}
+
+			if out, code := runBinary(t, buildBinary(t, c), "server"); code != 0 || out != want {
+				t.Fatalf("expected server to print %q, got %q and exit code %d", want, out, code)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		dir := newModule(t, map[string]string{"cmd/server/main.go": mainFile("server")})
+
+		for ext, want := range map[string]string{
+			".":       "invalid extension",
+			"sql/x":   "invalid extension",
+			"go":      "go files can't be copied verbatim",
+			".go":     "go files can't be copied verbatim",
+			".ok.sql": "",
+		} {
+			_, err := New(dir, "cmd/combined", WithCopyExtensions(ext))
+
+			if want == "" && err != nil || want != "" && (err == nil || !strings.Contains(err.Error(), want)) {
+				t.Fatalf("expected %q to fail with %q, got %v", ext, want, err)
+			}
+		}
+	})
+}
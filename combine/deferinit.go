@@ -0,0 +1,73 @@
+package combine
+
+import (
+	"fmt"
+	"go/ast"
+	"strconv"
+)
+
+const initName = "InitFunction_"
+
+// deferInit renames every init function in sources to InitFunction_<n> and
+// calls them, in the order the go tool would have run them, at the start of
+// main. Package level variable initialization still happens at startup.
+// With several mains split by build constraints, each calls only the init
+// functions of the files that can be built with it.
+func deferInit(sources []*sourceFile) error {
+	type funcDecl struct {
+		src  *sourceFile
+		decl *ast.FuncDecl
+	}
+
+	var inits, mains []funcDecl
+
+	for _, src := range sources {
+		for _, decl := range src.file.Decls {
+			fd, ok := decl.(*ast.FuncDecl)
+			if !ok || fd.Recv != nil {
+				continue
+			}
+
+			switch fd.Name.Name {
+			case "init":
+				fd.Name.Name = initName + strconv.Itoa(len(inits)+1)
+				inits = append(inits, funcDecl{src: src, decl: fd})
+			case "main":
+				mains = append(mains, funcDecl{src: src, decl: fd})
+			}
+		}
+	}
+
+	if len(inits) == 0 {
+		return nil
+	}
+
+	if len(mains) == 0 {
+		return fmt.Errorf("cannot defer init functions of %s: no main function body", sources[0].filename)
+	}
+
+	for _, main := range mains {
+		if main.decl.Body == nil {
+			return fmt.Errorf("cannot defer init functions of %s: no main function body", main.src.filename)
+		}
+
+		var calls []ast.Stmt
+
+		for _, init := range inits {
+			ok, err := buildableTogether(init.src, main.src)
+			if err != nil {
+				return err
+			}
+
+			if ok {
+				calls = append(calls, &ast.ExprStmt{
+					X: &ast.CallExpr{Fun: ast.NewIdent(init.decl.Name.Name)},
+				})
+			}
+		}
+
+		main.decl.Body.List = append(calls, main.decl.Body.List...)
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package combine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDeferInit(t *testing.T) {
+	dir := newModule(t, map[string]string{
+		"cmd/server/main.go": `package main
+
+import "fmt"
+
+func init() {
+	fmt.Println("main init 1")
+}
+
+func init() {
+	fmt.Println("main init 2")
+}
+
+func main() {
+	fmt.Println("server")
+}
+`,
+		"cmd/server/helpers.go": `package main
+
+import "fmt"
+
+func init() {
+	fmt.Println("helpers init")
+}
+`,
+		"cmd/worker/main.go": mainFile("worker"),
+	})
+
+	c := collected(t, dir, WithDeferInit(true))
+
+	files, err := c.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mainGo := string(files["cmd_server/main.go"])
+	for _, want := range []string{"func InitFunction_2() {", "func InitFunction_3() {", "func MainFunction() {\n\tInitFunction_1()\n\tInitFunction_2()\n\tInitFunction_3()\n"} {
+		if !strings.Contains(mainGo, want) {
+			t.Fatalf("expected the transformed main.go to contain %q:\n%s", want, mainGo)
+		}
+	}
+
+	if helpers := string(files["cmd_server/helpers.go"]); !strings.Contains(helpers, "func InitFunction_1() {") {
+		t.Fatalf("expected the init function of helpers.go, which the go tool runs first, to be renamed first:\n%s", helpers)
+	}
+
+	binary := buildBinary(t, c)
+
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{command: "server", want: "helpers init\nmain init 1\nmain init 2\nserver\n"},
+		{command: "worker", want: "worker\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			out, code := runBinary(t, binary, tt.command)
+			if code != 0 || out != tt.want {
+				t.Fatalf("expected %q and exit code 0, got %q and %d", tt.want, out, code)
+			}
+		})
+	}
+}
+
+func TestDeferInitTaggedMains(t *testing.T) {
+	dir := newModule(t, map[string]string{
+		"cmd/server/a.go": "//go:build tagone\n\npackage main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"a\")\n}\n",
+		"cmd/server/b.go": "//go:build !tagone\n\npackage main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"b\")\n}\n",
+		"cmd/server/c.go": "package main\n\nimport \"fmt\"\n\nfunc init() {\n\tfmt.Println(\"init\")\n}\n",
+		"cmd/server/d.go": "//go:build tagone\n\npackage main\n\nimport \"fmt\"\n\nfunc init() {\n\tfmt.Println(\"tagone init\")\n}\n",
+	})
+
+	c := collected(t, dir, WithDeferInit(true))
+
+	files, err := c.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each main only calls the init functions built with it
+	for name, want := range map[string]string{
+		"cmd_server/a.go": "func MainFunction() {\n\tInitFunction_1()\n\tInitFunction_2()\n\tfmt.Println(\"a\")\n",
+		"cmd_server/b.go": "func MainFunction() {\n\tInitFunction_1()\n\tfmt.Println(\"b\")\n",
+	} {
+		if !strings.Contains(string(files[name]), want) {
+			t.Fatalf("expected %s to contain %q:\n%s", name, want, files[name])
+		}
+	}
+
+	tests := []struct {
+		name  string
+		flags []string
+		want  string
+	}{
+		{name: "default", want: "init\nb\n"},
+		{name: "tagone", flags: []string{"-tags", "tagone"}, want: "init\ntagone init\na\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			binary := buildBinary(t, c, tt.flags...)
+
+			if out, code := runBinary(t, binary, "server"); code != 0 || out != tt.want {
+				t.Fatalf("expected %q and exit code 0, got %q and %d", tt.want, out, code)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package combine
+
+import "sort"
+
+// CommandInfo describes a main package found by Discover.
+type CommandInfo struct {
+	// Command is the name the command would be dispatched by, before
+	// duplicates are renamed.
+	Command string
+	// Key identifies the package, see Packages.
+	Key string
+	// SourceDir is the slash separated directory of the package, relative
+	// to the input directory it was found in.
+	SourceDir string
+	// Module is the path of the module the package belongs to.
+	Module string
+	// HasMain is set if a non-test file of the package declares func main.
+	HasMain bool
+}
+
+// Discover walks the inputs and returns the main packages Collect would
+// consider, sorted by Key, without transforming anything. Packages are
+// filtered and named as by Collect, so marker mode, //combiner:command
+// comments and skipped commands apply.
+func (c *Combiner) Discover() ([]CommandInfo, error) {
+	candidates, sources, err := c.parseCandidates()
+	if err != nil {
+		return nil, err
+	}
+
+	packages, _, err := c.mainPackages(candidates, sources)
+	if err != nil {
+		return nil, err
+	}
+
+	commands := []CommandInfo{}
+
+	for _, m := range packages {
+		if c.isSkipped(m) {
+			continue
+		}
+
+		commands = append(commands, CommandInfo{
+			Command:   m.Command,
+			Key:       m.key,
+			SourceDir: m.SourceDir,
+			Module:    m.Module,
+			HasMain:   declaresMain(m.sources),
+		})
+	}
+
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Key < commands[j].Key
+	})
+
+	return commands, nil
+}
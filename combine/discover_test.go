@@ -0,0 +1,80 @@
+package combine
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestDiscover(t *testing.T) {
+	server := CommandInfo{Command: "server", Key: "cmd/server", SourceDir: "cmd/server", Module: testModule, HasMain: true}
+	worker := CommandInfo{Command: "worker", Key: "cmd/worker", SourceDir: "cmd/worker", Module: testModule, HasMain: true}
+	admin := CommandInfo{Command: "admin", Key: "cmd/internal-admin-tool", SourceDir: "cmd/internal-admin-tool", Module: testModule, HasMain: true}
+	helpers := CommandInfo{Command: "helpers", Key: "cmd/helpers", SourceDir: "cmd/helpers", Module: testModule}
+
+	tests := []struct {
+		name    string
+		options []Option
+		want    []CommandInfo
+	}{
+		{name: "default", want: []CommandInfo{helpers, admin, server, worker}},
+		{name: "marker", options: []Option{WithMarker(true)}, want: []CommandInfo{admin, worker}},
+		{name: "skipped by name", options: []Option{WithSkipCommands("worker", "admin")}, want: []CommandInfo{helpers, server}},
+		{name: "skipped by key", options: []Option{WithSkipCommands("cmd.server")}, want: []CommandInfo{helpers, admin, worker}},
+		{name: "excluded", options: []Option{WithExclude("cmd/helpers")}, want: []CommandInfo{admin, server, worker}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go":                 mainFile("server"),
+				"cmd/server/main_test.go":            "package main\n",
+				"cmd/worker/main.go":                 includeDirective + "\n\n" + mainFile("worker"),
+				"cmd/internal-admin-tool/main.go":    commandDirective + " admin\n" + includeDirective + "\n\n" + mainFile("admin"),
+				"cmd/helpers/helpers.go":             "package main\n\nfunc helper() {}\n",
+				"cmd/tested/main_test.go":            mainFile("tested"),
+				"pkg/lib/lib.go":                     "package lib\n",
+				"cmd/internal-admin-tool/README.md":  "admin\n",
+				"cmd/internal-admin-tool/lib/lib.go": "package lib\n",
+			})
+
+			c := newCombiner(t, dir, tt.options...)
+
+			got, err := c.Discover()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected %+v, got %+v", tt.want, got)
+			}
+
+			if len(c.packages) != 0 {
+				t.Fatal("discover collected packages")
+			}
+
+			// the commands with func main are those Collect combines
+			if err := c.Collect(); err != nil {
+				t.Fatal(err)
+			}
+
+			var discovered []string
+			for _, info := range got {
+				if info.HasMain {
+					discovered = append(discovered, info.Key+" "+info.Command)
+				}
+			}
+
+			var collected []string
+			for _, m := range c.packages {
+				collected = append(collected, m.key+" "+m.Command)
+			}
+
+			sort.Strings(collected)
+
+			if !reflect.DeepEqual(discovered, collected) {
+				t.Fatalf("discovered %q, but collected %q", discovered, collected)
+			}
+		})
+	}
+}
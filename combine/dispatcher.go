@@ -0,0 +1,476 @@
+package combine
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"path"
+	"sort"
+	"text/template"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// Dispatch selects how the generated dispatcher picks a command.
+type Dispatch string
+
+const (
+	// DispatchArgv0 runs the command named by the base name of os.Args[0],
+	// for use with busybox style symlinks.
+	DispatchArgv0 Dispatch = "argv0"
+	// DispatchSubcommand runs the command named by os.Args[1], e.g.
+	// "combined server --port 8080". The command is run with os.Args[1]
+	// removed, so it sees "server --port 8080".
+	DispatchSubcommand Dispatch = "subcommand"
+	// DispatchRegistry runs the command named by the base name of
+	// os.Args[0] like DispatchArgv0, but looks it up in a generated registry
+	// package each command registers itself with from an init function,
+	// rather than in a switch.
+	DispatchRegistry Dispatch = "registry"
+)
+
+// DefaultDispatcherFilename is the name of the generated dispatcher unless
+// configured otherwise.
+const DefaultDispatcherFilename = "main.go"
+
+type dispatcherCommand struct {
+	Name        string
+	PackageName string
+	ImportPath  string
+	// SourceImportPath is the import path of the original package
+	SourceImportPath string
+	// Context is set if the command is called with the context
+	Context bool
+}
+
+type dispatcherData struct {
+	Header   string
+	Dispatch Dispatch
+	MainName string
+	Commands []dispatcherCommand
+	// ListCommand, if set, is the name that prints every command.
+	ListCommand string
+	// Names are the command names, sorted.
+	Names []string
+	// UnknownExitCode is the exit code for an unknown command.
+	UnknownExitCode int
+	// CommandPrefix, if set, is stripped from the binary name before it is
+	// matched against the commands.
+	CommandPrefix string
+	// TrimSuffixes are stripped from the binary name, in order, before it
+	// is matched against the commands.
+	TrimSuffixes []string
+	// ExitImportPath, if set, is the import path of the package whose
+	// Error commands panic with instead of calling os.Exit.
+	ExitImportPath string
+	// RegistryImportPath, if set, is the import path of the package
+	// commands register themselves with.
+	RegistryImportPath string
+	// Context is set if a context canceled on SIGINT and SIGTERM is passed
+	// to commands.
+	Context bool
+	// Default, if set, is the command run when the name matches no command.
+	Default *dispatcherCommand
+	// Library, if set, is the package of a dispatcher that provides
+	// Run(args []string) int rather than main.
+	Library string
+	// PreserveArgv0 is set if, in subcommand mode, os.Args[0] is left as
+	// the path of the combined binary rather than the command name.
+	PreserveArgv0 bool
+	// Prolog and Epilog, if set, are statements run before and after
+	// every command.
+	Prolog string
+	Epilog string
+}
+
+// checkStatements makes sure that snippet, given with WithWrap, is a list
+// of Go statements. Errors are reported at positions within snippet, in a
+// file called name.
+func checkStatements(name string, snippet string) error {
+	src := "package p\n\nfunc _() {\n//line " + name + ":1\n" + snippet + "\n}\n"
+	if _, err := parser.ParseFile(token.NewFileSet(), "", src, 0); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// parseDispatcherTemplate parses a dispatcher template given with
+// WithDispatcherTemplate.
+func parseDispatcherTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("dispatcher").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dispatcher template: %w", err)
+	}
+
+	return tmpl, nil
+}
+
+var dispatcherTemplate = template.Must(template.New("dispatcher").Parse(`{{ .Header }}
+
+package {{ if .Library }}{{ .Library }}{{ else }}main{{ end }}
+
+import (
+{{- if .Context }}
+	"context"
+{{- end }}
+{{- if or (not .Default) .ListCommand (eq .Dispatch "subcommand") }}
+	"fmt"
+{{- end }}
+	"os"
+{{- if .Context }}
+	"os/signal"
+{{- end }}
+{{- if not (and .Default (eq .Dispatch "subcommand")) }}
+	"path/filepath"
+{{- end }}
+{{- if or .CommandPrefix .TrimSuffixes }}
+	"strings"
+{{- end }}
+{{- if .Context }}
+	"syscall"
+{{- end }}
+{{ if .ExitImportPath }}
+	combinedexit {{ printf "%q" .ExitImportPath }}
+{{- end }}
+{{- if .RegistryImportPath }}
+	combinedregistry {{ printf "%q" .RegistryImportPath }}
+{{- range .Commands }}
+	// {{ .Name }} from {{ .SourceImportPath }}
+	_ {{ printf "%q" .ImportPath }}
+{{- end }}
+{{- else }}
+{{- range .Commands }}
+	{{ .PackageName }} {{ printf "%q" .ImportPath }}
+{{- end }}
+{{- end }}
+)
+
+{{- if .Library }}
+
+// Run runs the command selected by args, which are laid out like os.Args,
+// and returns its exit code. It sets os.Args for the command, which may
+// still exit the process itself.
+func Run(args []string) (code int) {
+	os.Args = args
+{{ else }}
+
+func main() {
+{{- end }}
+{{- if eq .Dispatch "subcommand" }}
+{{- if .Default }}
+{{- if not .Library }}
+	// the default command sees the arguments as given
+	args := os.Args
+{{- end }}
+	name := ""
+
+	if len(os.Args) > 1 {
+		name = os.Args[1]
+
+{{- if .PreserveArgv0 }}
+
+		// the command sees the path of the combined binary, followed by
+		// its own arguments
+		os.Args = append([]string{os.Args[0]}, os.Args[2:]...)
+{{- else }}
+
+		// the command sees the same arguments it would as a standalone
+		// binary named after the command
+		os.Args = append([]string{name}, os.Args[2:]...)
+{{- end }}
+	}
+{{- else }}
+	binary := filepath.Base(os.Args[0])
+
+	if len(os.Args) < 2 {
+		usage(binary)
+{{- if .Library }}
+		return 2
+{{- else }}
+		os.Exit(2)
+{{- end }}
+	}
+
+	name := os.Args[1]
+{{- if .PreserveArgv0 }}
+
+	// the command sees the path of the combined binary, followed by its
+	// own arguments
+	os.Args = append([]string{os.Args[0]}, os.Args[2:]...)
+{{- else }}
+
+	// the command sees the same arguments it would as a standalone
+	// binary named after the command
+	os.Args = append([]string{name}, os.Args[2:]...)
+{{- end }}
+{{- end }}
+{{- else }}
+	// os.Args is left as invoked, so commands see the path of the symlink
+	// that selected them
+	name := filepath.Base(os.Args[0])
+{{- if .TrimSuffixes }}
+
+	// on Windows, copies of the binary are named like server.exe
+{{- range .TrimSuffixes }}
+	name = strings.TrimSuffix(name, {{ printf "%q" . }})
+{{- end }}
+{{- end }}
+{{- if .CommandPrefix }}
+
+	// symlinks are installed with a prefix to avoid collisions on $PATH
+	name = strings.TrimPrefix(name, {{ printf "%q" .CommandPrefix }})
+{{- end }}
+{{- end }}
+{{- if .ExitImportPath }}
+
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(combinedexit.Error); ok {
+{{- if .Library }}
+				code = e.Code
+				return
+{{- else }}
+				os.Exit(e.Code)
+{{- end }}
+			}
+
+			panic(r)
+		}
+	}()
+{{- end }}
+{{- if .Context }}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+{{- end }}
+
+{{- if .RegistryImportPath }}
+{{- if .ListCommand }}
+
+	if name == {{ printf "%q" .ListCommand }} {
+		listCommands()
+		return{{ if .Library }} 0{{ end }}
+	}
+{{- end }}
+
+	command, ok := combinedregistry.Lookup(name)
+	if !ok {
+{{- if .Default }}
+		// no command matched, so the default command runs
+		command, _ = combinedregistry.Lookup({{ printf "%q" .Default.Name }})
+{{- else }}
+		fmt.Fprintf(os.Stderr, "unknown command %s\n", name)
+{{- if .Library }}
+		return {{ .UnknownExitCode }}
+{{- else }}
+		os.Exit({{ .UnknownExitCode }})
+{{- end }}
+{{- end }}
+	}
+
+{{- if .Prolog }}
+
+	{{ .Prolog }}
+{{- end }}
+
+	command({{ if .Context }}ctx{{ end }})
+{{- if .Epilog }}
+
+	{{ .Epilog }}
+{{- end }}
+{{- else }}
+
+	switch name {
+{{- range .Commands }}
+	// from {{ .SourceImportPath }}, generated as {{ .ImportPath }}
+	case {{ printf "%q" .Name }}:
+{{- if $.Prolog }}
+		{{ $.Prolog }}
+{{- end }}
+		{{ .PackageName }}.{{ $.MainName }}({{ if .Context }}ctx{{ end }})
+{{- if $.Epilog }}
+		{{ $.Epilog }}
+{{- end }}
+{{- end }}
+{{- if .ListCommand }}
+	case {{ printf "%q" .ListCommand }}:
+		listCommands()
+{{- end }}
+
+	default:
+{{- if .Default }}
+		// no command matched, so the default command runs
+{{- if eq .Dispatch "subcommand" }}
+		os.Args = args
+{{- end }}
+{{- if .Prolog }}
+		{{ .Prolog }}
+{{- end }}
+		{{ .Default.PackageName }}.{{ $.MainName }}({{ if .Default.Context }}ctx{{ end }})
+{{- if .Epilog }}
+		{{ .Epilog }}
+{{- end }}
+{{- else }}
+		fmt.Fprintf(os.Stderr, "unknown command %s\n", name)
+{{- if eq .Dispatch "subcommand" }}
+		usage(binary)
+{{- end }}
+{{- if .Library }}
+		return {{ .UnknownExitCode }}
+{{- else }}
+		os.Exit({{ .UnknownExitCode }})
+{{- end }}
+{{- end }}
+	}
+{{- end }}
+{{- if .Library }}
+
+	return 0
+{{- end }}
+}
+{{- if .ListCommand }}
+
+func listCommands() {
+{{- if .RegistryImportPath }}
+	for _, name := range combinedregistry.Names() {
+		fmt.Println(name)
+	}
+{{- else }}
+{{- range .Names }}
+	fmt.Println({{ printf "%q" . }})
+{{- end }}
+{{- end }}
+}
+{{- end }}
+{{- if eq .Dispatch "subcommand" }}
+
+func usage(binary string) {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [arguments]\n\ncommands:\n", binary)
+{{- range .Commands }}
+	fmt.Fprintln(os.Stderr, {{ printf "  %s" .Name | printf "%q" }})
+{{- end }}
+}
+{{- end }}
+`))
+
+// listCommandName is the reserved name that prints every command: --list
+// in subcommand mode, otherwise <binary>-list, e.g. combined-list.
+func (c *Combiner) listCommandName(binary string) string {
+	if c.dispatch == DispatchSubcommand {
+		return "--list"
+	}
+
+	return binary + "-list"
+}
+
+// dispatcher generates the dispatcher of the binary called binary, which
+// runs outputs.
+func (c *Combiner) dispatcher(binary string, outputs []*MainPackage) ([]byte, error) {
+	data := dispatcherData{
+		Header:          generatedHeader,
+		Dispatch:        c.dispatch,
+		MainName:        c.entrypointName,
+		UnknownExitCode: c.unknownExitCode,
+		CommandPrefix:   c.commandPrefix,
+		Library:         c.dispatcherPackage,
+		PreserveArgv0:   c.preserveArgv0,
+		Prolog:          c.prolog,
+		Epilog:          c.epilog,
+	}
+
+	if c.dispatch != DispatchSubcommand {
+		data.TrimSuffixes = append([]string{".exe"}, c.trimSuffixes...)
+	}
+
+	for _, m := range outputs {
+		data.Commands = append(data.Commands, dispatcherCommand{
+			Name:             m.Command,
+			PackageName:      m.PackageName,
+			ImportPath:       m.ImportPath,
+			SourceImportPath: path.Join(m.Module, m.SourceDir),
+			Context:          m.context,
+		})
+
+		if m.context {
+			data.Context = true
+		}
+
+		data.Names = append(data.Names, m.Command)
+	}
+
+	sort.Strings(data.Names)
+
+	for i, command := range data.Commands {
+		if command.Name == c.defaultCommand {
+			data.Default = &data.Commands[i]
+		}
+	}
+
+	if c.trapExit {
+		data.ExitImportPath = c.exitImportPath()
+	}
+
+	if c.dispatch == DispatchRegistry {
+		data.RegistryImportPath = c.registryImportPath()
+
+		// registered commands always take the context
+		data.Context = c.contextEntrypoint
+	}
+
+	if c.emitListCommand {
+		data.ListCommand = c.listCommandName(binary)
+
+		for _, m := range outputs {
+			if m.Command == data.ListCommand {
+				return nil, fmt.Errorf("command %q from %s conflicts with the list command", m.Command, m.SourceDir)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := c.dispatcherTemplate.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to generate dispatcher: %w", err)
+	}
+
+	fset := token.NewFileSet()
+	mainAST, err := parser.ParseFile(fset, c.dispatcherFilename, buf.Bytes(), parser.ParseComments)
+	if err != nil {
+		return nil, &FormatError{File: c.dispatcherFilename, Source: buf.Bytes(), Err: err}
+	}
+
+	packageName := "main"
+	if c.dispatcherPackage != "" {
+		packageName = c.dispatcherPackage
+	}
+
+	if mainAST.Name.Name != packageName {
+		return nil, fmt.Errorf("generated dispatcher is package %s, not %s", mainAST.Name.Name, packageName)
+	}
+
+	for _, p := range c.wrapImports {
+		astutil.AddImport(fset, mainAST, p)
+	}
+
+	if c.simplify {
+		simplify(mainAST)
+	}
+
+	buf.Reset()
+	if err := format.Node(&buf, fset, mainAST); err != nil {
+		return nil, &FormatError{File: c.dispatcherFilename, Err: err}
+	}
+
+	out, err := groupImports(c.dispatcherFilename, buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	if c.standaloneTag != "" {
+		return withTag(c.dispatcherFilename, out, c.standaloneTag, false)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,985 @@
+package combine
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// commandTree is a module with three commands whose import paths and names
+// sort differently.
+var commandTree = map[string]string{
+	"cmd/server/main.go":   mainFile("server"),
+	"tools/alpha/main.go":  mainFile("alpha"),
+	"cmd/zworker/main.go":  mainFile("zworker"),
+	"pkg/lib/lib.go":       "package lib\n",
+	"cmd/server/helper.go": "package main\n",
+}
+
+func TestListCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		dispatch Dispatch
+		argv0    string
+		args     []string
+	}{
+		{name: "argv0", dispatch: DispatchArgv0, argv0: "combined-list"},
+		{name: "subcommand", dispatch: DispatchSubcommand, args: []string{"--list"}},
+		{name: "registry", dispatch: DispatchRegistry, argv0: "combined-list"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, commandTree)
+
+			c := collected(t, dir, WithDispatch(tt.dispatch), WithEmitListCommand(true))
+			binary := buildBinary(t, c)
+
+			out, code := runBinary(t, binary, tt.argv0, tt.args...)
+			if code != 0 {
+				t.Fatalf("list command failed with exit code %d: %s", code, out)
+			}
+
+			if want := strings.Join(commandNames(c), "\n") + "\n"; out != want {
+				t.Fatalf("expected the list command to print %q, got %q", want, out)
+			}
+		})
+	}
+}
+
+func TestUnknownExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		code int
+		err  string
+	}{
+		{name: "default", code: DefaultUnknownExitCode},
+		{name: "configured", opts: []Option{WithUnknownExitCode(42)}, code: 42},
+		{name: "zero", opts: []Option{WithUnknownExitCode(0)}, err: "unknown command exit code 0 must be between 1 and 125"},
+		{name: "too large", opts: []Option{WithUnknownExitCode(126)}, err: "unknown command exit code 126 must be between 1 and 125"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, commandTree)
+
+			c, err := New(dir, "cmd/combined", tt.opts...)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := c.Collect(); err != nil {
+				t.Fatal(err)
+			}
+
+			files, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := fmt.Sprintf("os.Exit(%d)", tt.code); !strings.Contains(string(files["main.go"]), want) {
+				t.Fatalf("expected the dispatcher to contain %s:\n%s", want, files["main.go"])
+			}
+
+			out, code := runBinary(t, buildBinary(t, c), "unknown")
+			if code != tt.code || out != "unknown command unknown\n" {
+				t.Fatalf("expected exit code %d, got %d: %s", tt.code, code, out)
+			}
+		})
+	}
+}
+
+// flagCommand prints how its arguments were parsed.
+const flagCommand = `package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+func main() {
+	port := flag.Int("port", 0, "port")
+	flag.Parse()
+
+	fmt.Println(filepath.Base(os.Args[0]), *port, flag.Args())
+}
+`
+
+func TestCommandArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		argv0    string
+		args     []string
+		want     string
+		wantCode int
+	}{
+		{
+			name:  "argv0",
+			argv0: "server",
+			args:  []string{"-port", "8080", "extra"},
+			want:  "server 8080 [extra]\n",
+		},
+		{
+			name: "subcommand",
+			opts: []Option{WithDispatch(DispatchSubcommand)},
+			args: []string{"server", "-port", "8080", "extra"},
+			want: "server 8080 [extra]\n",
+		},
+		{
+			name: "preserved argv0",
+			opts: []Option{WithDispatch(DispatchSubcommand), WithPreserveArgv0(true)},
+			args: []string{"server", "-port", "8080"},
+			want: "combined 8080 []\n",
+		},
+		{
+			name:     "flag error",
+			opts:     []Option{WithDispatch(DispatchSubcommand)},
+			args:     []string{"server", "-bogus"},
+			want:     "flag provided but not defined: -bogus\nUsage of server:\n",
+			wantCode: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{"cmd/server/main.go": flagCommand})
+
+			c := collected(t, dir, tt.opts...)
+
+			out, code := runBinary(t, buildBinary(t, c), tt.argv0, tt.args...)
+			if code != tt.wantCode || !strings.HasPrefix(out, tt.want) {
+				t.Fatalf("expected %q and exit code %d, got %q and %d", tt.want, tt.wantCode, out, code)
+			}
+		})
+	}
+}
+
+func TestDefaultCommand(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []Option
+		argv0 string
+		args  []string
+		want  string
+		// defaultCase is the default case of the generated switch, if any
+		defaultCase string
+	}{
+		{
+			name:        "argv0 unknown name",
+			argv0:       "other",
+			args:        []string{"-port", "8080", "extra"},
+			want:        "other 8080 [extra]\n",
+			defaultCase: "\tdefault:\n\t\t// no command matched, so the default command runs\n\t\tcmd_server.MainFunction()\n\t}\n",
+		},
+		{
+			name:  "argv0 known name",
+			argv0: "worker",
+			want:  "worker\n",
+		},
+		{
+			name:        "subcommand without arguments",
+			opts:        []Option{WithDispatch(DispatchSubcommand)},
+			want:        "combined 0 []\n",
+			defaultCase: "\tdefault:\n\t\t// no command matched, so the default command runs\n\t\tos.Args = args\n\t\tcmd_server.MainFunction()\n\t}\n",
+		},
+		{
+			name: "subcommand unknown name",
+			opts: []Option{WithDispatch(DispatchSubcommand)},
+			args: []string{"-port", "8080", "extra"},
+			want: "combined 8080 [extra]\n",
+		},
+		{
+			name: "subcommand known name",
+			opts: []Option{WithDispatch(DispatchSubcommand)},
+			args: []string{"worker"},
+			want: "worker\n",
+		},
+		{
+			name:  "registry",
+			opts:  []Option{WithDispatch(DispatchRegistry)},
+			argv0: "other",
+			want:  "other 0 []\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go": flagCommand,
+				"cmd/worker/main.go": mainFile("worker"),
+			})
+
+			c := collected(t, dir, append([]Option{WithDefaultCommand("server")}, tt.opts...)...)
+
+			files, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if dispatcher := string(files["main.go"]); !strings.Contains(dispatcher, tt.defaultCase) || strings.Contains(dispatcher, "unknown command") {
+				t.Fatalf("expected the default case to run server:\n%s", dispatcher)
+			}
+
+			if out, code := runBinary(t, buildBinary(t, c), tt.argv0, tt.args...); code != 0 || out != tt.want {
+				t.Fatalf("expected %q, got %q and exit code %d", tt.want, out, code)
+			}
+		})
+	}
+
+	t.Run("unknown default", func(t *testing.T) {
+		dir := newModule(t, map[string]string{"cmd/server/main.go": mainFile("server")})
+
+		err := newCombiner(t, dir, WithDefaultCommand("nope")).Collect()
+		if err == nil || err.Error() != `default command "nope" is not one of the collected commands` {
+			t.Fatalf("expected an unknown default command to be rejected, got %v", err)
+		}
+	})
+}
+
+// libraryCaller is a main package that runs the dispatcher package combined
+// with its own arguments, and prints the exit code Run returns.
+const libraryCaller = `package main
+
+import (
+	"fmt"
+	"os"
+
+	combined "example.com/fx/cmd/combined"
+)
+
+func main() {
+	code := combined.Run(os.Args[1:])
+	fmt.Println("exit", code)
+}
+`
+
+func TestDispatcherPackage(t *testing.T) {
+	exiting := "package main\n\nimport \"os\"\n\nfunc main() {\n\tos.Exit(3)\n}\n"
+
+	tests := []struct {
+		name string
+		opts []Option
+		args []string
+		want string
+	}{
+		{name: "argv0", args: []string{"server"}, want: "server\nexit 0\n"},
+		{name: "unknown command", args: []string{"nope"}, want: "unknown command nope\nexit 11\n"},
+		{name: "subcommand", opts: []Option{WithDispatch(DispatchSubcommand)}, args: []string{"combined", "server"}, want: "server\nexit 0\n"},
+		{name: "registry", opts: []Option{WithDispatch(DispatchRegistry)}, args: []string{"server"}, want: "server\nexit 0\n"},
+		{name: "trapped exit", opts: []Option{WithTrapExit(true)}, args: []string{"exiting"}, want: "exit 3\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go":  mainFile("server"),
+				"cmd/exiting/main.go": exiting,
+			})
+
+			c := collected(t, dir, append([]Option{WithDispatcherPackage("combined")}, tt.opts...)...)
+
+			files, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := parser.ParseFile(token.NewFileSet(), "main.go", files["main.go"], 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if f.Name.Name != "combined" {
+				t.Fatalf("expected package combined, got %s", f.Name.Name)
+			}
+
+			var run *ast.FuncDecl
+
+			for _, decl := range f.Decls {
+				if fn, ok := decl.(*ast.FuncDecl); ok {
+					if fn.Name.Name == "main" {
+						t.Fatal("unexpected func main in the dispatcher package")
+					}
+
+					if fn.Name.Name == "Run" {
+						run = fn
+					}
+				}
+			}
+
+			if run == nil {
+				t.Fatalf("no func Run in the dispatcher:\n%s", files["main.go"])
+			}
+
+			if got := fieldTypes(run.Type.Params); got != "[]string" {
+				t.Fatalf("expected Run to take []string, got %s", got)
+			}
+
+			if got := fieldTypes(run.Type.Results); got != "int" {
+				t.Fatalf("expected Run to return int, got %s", got)
+			}
+
+			if err := c.Write(); err != nil {
+				t.Fatal(err)
+			}
+
+			writeFiles(t, dir, map[string]string{"cmd/app/main.go": libraryCaller})
+
+			binary := filepath.Join(t.TempDir(), "app")
+
+			cmd := exec.Command("go", "build", "-o", binary, "./cmd/app")
+			cmd.Dir = dir
+
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("failed to build a caller of Run: %s\n%s", err, out)
+			}
+
+			if out, code := runBinary(t, binary, "", tt.args...); code != 0 || out != tt.want {
+				t.Fatalf("expected %q, got %q and exit code %d", tt.want, out, code)
+			}
+		})
+	}
+
+	for _, name := range []string{"main", "_", "not-valid"} {
+		t.Run("invalid "+name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{"cmd/server/main.go": mainFile("server")})
+
+			if _, err := New(dir, "cmd/combined", WithDispatcherPackage(name)); err == nil || !strings.Contains(err.Error(), "must be a Go identifier other than main") {
+				t.Fatalf("expected package %q to be rejected, got %v", name, err)
+			}
+		})
+	}
+}
+
+// fieldTypes returns the types of fields, without their names, separated by
+// commas.
+func fieldTypes(fields *ast.FieldList) string {
+	var names []string
+
+	for _, field := range fields.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+
+		for i := 0; i < n; i++ {
+			names = append(names, types.ExprString(field.Type))
+		}
+	}
+
+	return strings.Join(names, ", ")
+}
+
+func TestWrap(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		imports []string
+		prolog  string
+		argv0   string
+		args    []string
+		want    string
+		// calls is how often the prolog appears in the dispatcher
+		calls int
+	}{
+		{
+			name:    "argv0",
+			imports: []string{"log"},
+			prolog:  `log.Println("start", name)`,
+			argv0:   "server",
+			want:    "start server\nserver\ndone server\n",
+			calls:   2,
+		},
+		{
+			name:    "argv0 default",
+			opts:    []Option{WithDefaultCommand("server")},
+			imports: []string{"log"},
+			prolog:  `log.Println("start", name)`,
+			argv0:   "other",
+			want:    "start other\nserver\ndone other\n",
+			calls:   3,
+		},
+		{
+			name:    "subcommand",
+			opts:    []Option{WithDispatch(DispatchSubcommand)},
+			imports: []string{"log"},
+			prolog:  `log.Println("start", name)`,
+			args:    []string{"worker"},
+			want:    "start worker\nworker\ndone worker\n",
+			calls:   2,
+		},
+		{
+			name:    "subcommand default",
+			opts:    []Option{WithDispatch(DispatchSubcommand), WithDefaultCommand("server")},
+			imports: []string{"log"},
+			prolog:  `log.Println("start", name)`,
+			want:    "start \nserver\ndone \n",
+			calls:   3,
+		},
+		{
+			name:    "registry default",
+			opts:    []Option{WithDispatch(DispatchRegistry), WithDefaultCommand("server")},
+			imports: []string{"log"},
+			prolog:  `log.Println("start", name)`,
+			argv0:   "other",
+			want:    "start other\nserver\ndone other\n",
+			calls:   1,
+		},
+		{
+			name:    "import the dispatcher already has",
+			imports: []string{"fmt", "log"},
+			prolog:  `fmt.Fprintln(os.Stderr, "start", name)`,
+			argv0:   "server",
+			want:    "start server\nserver\ndone server\n",
+			calls:   2,
+		},
+		{
+			name:    "import the dispatcher only has without a default",
+			opts:    []Option{WithDefaultCommand("server")},
+			imports: []string{"fmt", "log"},
+			prolog:  `fmt.Fprintln(os.Stderr, "start", name)`,
+			argv0:   "server",
+			want:    "start server\nserver\ndone server\n",
+			calls:   3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go": mainFile("server"),
+				"cmd/worker/main.go": mainFile("worker"),
+			})
+
+			opts := append([]Option{
+				WithWrapImports(tt.imports...),
+				// log prints just the message, so the output is predictable
+				WithWrap("log.SetFlags(0)\n"+tt.prolog, `log.Println("done", name)`),
+			}, tt.opts...)
+
+			c := collected(t, dir, opts...)
+
+			files, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dispatcher := string(files["main.go"])
+
+			if got := strings.Count(dispatcher, tt.prolog); got != tt.calls {
+				t.Fatalf("expected the prolog %d times, got %d:\n%s", tt.calls, got, dispatcher)
+			}
+
+			for _, p := range tt.imports {
+				if got := strings.Count(dispatcher, strconv.Quote(p)+"\n"); got != 1 {
+					t.Fatalf("expected %s to be imported once, got %d:\n%s", p, got, dispatcher)
+				}
+			}
+
+			if out, code := runBinary(t, buildBinary(t, c), tt.argv0, tt.args...); code != 0 || out != tt.want {
+				t.Fatalf("expected %q, got %q and exit code %d", tt.want, out, code)
+			}
+		})
+	}
+
+	t.Run("invalid import", func(t *testing.T) {
+		dir := newModule(t, map[string]string{"cmd/server/main.go": mainFile("server")})
+
+		if _, err := New(dir, "cmd/combined", WithWrapImports("bad path")); err == nil || !strings.HasPrefix(err.Error(), "invalid wrap import: ") {
+			t.Fatalf("expected the import path to be rejected, got %v", err)
+		}
+	})
+}
+
+func TestArgvRewriting(t *testing.T) {
+	const rewrite = "\t// the command sees the same arguments it would as a standalone\n\t// binary named after the command\n\tos.Args = append([]string{name}, os.Args[2:]...)\n"
+	const preserve = "\t// the command sees the path of the combined binary, followed by its\n\t// own arguments\n\tos.Args = append([]string{os.Args[0]}, os.Args[2:]...)\n"
+
+	// begin and beginDefault start func main, without and with a default
+	// command, up to where the arguments are rewritten
+	const begin = "func main() {\n\tbinary := filepath.Base(os.Args[0])\n\n\tif len(os.Args) < 2 {\n\t\tusage(binary)\n\t\tos.Exit(2)\n\t}\n\n\tname := os.Args[1]\n\n"
+	const beginDefault = "func main() {\n\t// the default command sees the arguments as given\n\targs := os.Args\n\tname := \"\"\n\n\tif len(os.Args) > 1 {\n\t\tname = os.Args[1]\n\n"
+
+	tests := []struct {
+		name   string
+		opts   []Option
+		golden string
+		// args are passed to the binary, whose server prints os.Args
+		args []string
+		want string
+	}{
+		{
+			name:   "rewritten",
+			golden: begin + rewrite + "\n\tswitch name {\n",
+			args:   []string{"server", "-v"},
+			want:   "[server -v]\n",
+		},
+		{
+			name:   "preserved",
+			opts:   []Option{WithPreserveArgv0(true)},
+			golden: begin + preserve + "\n\tswitch name {\n",
+			args:   []string{"server", "-v"},
+			want:   "[combined -v]\n",
+		},
+		{
+			name:   "rewritten with a default",
+			opts:   []Option{WithDefaultCommand("server")},
+			golden: beginDefault + "\t\t// the command sees the same arguments it would as a standalone\n\t\t// binary named after the command\n\t\tos.Args = append([]string{name}, os.Args[2:]...)\n\t}\n\n\tswitch name {\n",
+			args:   []string{"server", "-v"},
+			want:   "[server -v]\n",
+		},
+		{
+			name:   "preserved with a default",
+			opts:   []Option{WithPreserveArgv0(true), WithDefaultCommand("server")},
+			golden: beginDefault + "\t\t// the command sees the path of the combined binary, followed by\n\t\t// its own arguments\n\t\tos.Args = append([]string{os.Args[0]}, os.Args[2:]...)\n\t}\n\n\tswitch name {\n",
+			args:   []string{"server", "-v"},
+			want:   "[combined -v]\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go": "package main\n\nimport (\n\t\"fmt\"\n\t\"os\"\n\t\"path/filepath\"\n)\n\nfunc main() {\n\tos.Args[0] = filepath.Base(os.Args[0])\n\tfmt.Println(os.Args)\n}\n",
+			})
+
+			c := collected(t, dir, append([]Option{WithDispatch(DispatchSubcommand)}, tt.opts...)...)
+
+			files, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dispatcher := string(files["main.go"])
+
+			start := strings.Index(dispatcher, "func main() {")
+			end := strings.Index(dispatcher, "\tswitch name {\n")
+			if start < 0 || end < 0 {
+				t.Fatalf("no func main with a switch in the dispatcher:\n%s", dispatcher)
+			}
+
+			if got := dispatcher[start : end+len("\tswitch name {\n")]; got != tt.golden {
+				t.Fatalf("expected the arguments to be rewritten by:\n%s\ngot:\n%s", tt.golden, got)
+			}
+
+			if out, code := runBinary(t, buildBinary(t, c), "", tt.args...); code != 0 || out != tt.want {
+				t.Fatalf("expected %q, got %q and exit code %d", tt.want, out, code)
+			}
+		})
+	}
+}
+
+func TestDispatcherFilename(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		err      string
+	}{
+		{name: "custom", filename: "cmd.go"},
+		{name: "not go", filename: "cmd.txt", err: `dispatcher filename "cmd.txt" must be a file name ending in .go`},
+		{name: "path", filename: "sub/cmd.go", err: `dispatcher filename "sub/cmd.go" must be a file name ending in .go`},
+		{name: "test", filename: "cmd_test.go", err: `dispatcher filename "cmd_test.go" must be a file name ending in .go`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, commandTree)
+
+			c, err := New(dir, "cmd/combined", WithDispatcherFilename(tt.filename))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := c.Collect(); err != nil {
+				t.Fatal(err)
+			}
+
+			files, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, ok := files[tt.filename]; !ok {
+				t.Fatalf("expected the dispatcher to be generated as %s", tt.filename)
+			}
+
+			if _, ok := files[DefaultDispatcherFilename]; ok {
+				t.Fatalf("expected no %s", DefaultDispatcherFilename)
+			}
+
+			// the transformed main.go of each command is kept
+			if _, ok := files["cmd_server/main.go"]; !ok {
+				t.Fatal("expected cmd_server/main.go to be generated")
+			}
+
+			if out, code := runBinary(t, buildBinary(t, c), "server"); code != 0 || out != "server\n" {
+				t.Fatalf("expected server to run, got %q and exit code %d", out, code)
+			}
+		})
+	}
+}
+
+func TestCaseComments(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []Option
+	}{
+		{name: "argv0"},
+		{name: "subcommand", options: []Option{WithDispatch(DispatchSubcommand)}},
+		{name: "sorted by command", options: []Option{WithSortBy(SortByCommand)}},
+		{name: "simplified", options: []Option{WithSimplify(true)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, commandTree)
+
+			c := collected(t, dir, tt.options...)
+
+			files, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			fset := token.NewFileSet()
+
+			f, err := parser.ParseFile(fset, "main.go", files["main.go"], parser.ParseComments)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the comment on the line above each case
+			comments := make(map[string]string)
+
+			ast.Inspect(f, func(n ast.Node) bool {
+				clause, ok := n.(*ast.CaseClause)
+				if !ok || len(clause.List) != 1 {
+					return true
+				}
+
+				name, err := strconv.Unquote(clause.List[0].(*ast.BasicLit).Value)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				for _, cg := range f.Comments {
+					if fset.Position(cg.End()).Line == fset.Position(clause.Pos()).Line-1 {
+						comments[name] = cg.Text()
+					}
+				}
+
+				return true
+			})
+
+			for _, m := range c.packages {
+				want := fmt.Sprintf("from %s/%s, generated as %s\n", testModule, m.key, m.ImportPath)
+				if got := comments[m.Command]; got != want {
+					t.Errorf("expected the case of %s to be commented %q, got %q", m.Command, want, got)
+				}
+			}
+
+			buildOutput(t, c)
+		})
+	}
+}
+
+// customTemplate is a dispatcher recovering from panics in commands.
+const customTemplate = `{{ .Header }}
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+{{ range .Commands }}
+	{{ .PackageName }} {{ printf "%q" .ImportPath }}
+{{- end }}
+)
+
+var commands = map[string]func(){
+{{- range .Commands }}
+	{{ printf "%q" .Name }}: {{ .PackageName }}.{{ $.MainName }},
+{{- end }}
+}
+
+func main() {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("recovered:", r)
+			os.Exit(3)
+		}
+	}()
+
+	command, ok := commands[filepath.Base(os.Args[0])]
+	if !ok {
+		fmt.Println("no such command")
+		os.Exit(2)
+	}
+
+	command()
+}
+`
+
+func TestDispatcherTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		newErr   string
+		err      string
+	}{
+		{name: "custom", template: customTemplate},
+		{name: "invalid", template: "{{ .Header ", newErr: "invalid dispatcher template: "},
+		{name: "failing", template: "{{ .Missing }}", err: "failed to generate dispatcher: "},
+		{name: "not Go", template: "{{ .Header }}\n\npackage main\n\nfunc main( {\n", err: "main.go:"},
+		{name: "other package", template: "package lib\n", err: "generated dispatcher is package lib, not main"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go": mainFile("server"),
+				"cmd/panics/main.go": "package main\n\nfunc main() {\n\tpanic(\"boom\")\n}\n",
+			})
+
+			c, err := New(dir, "cmd/combined", WithDispatcherTemplate(tt.template))
+			if tt.newErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.newErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.newErr, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := c.Collect(); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = c.Generate()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			binary := buildBinary(t, c)
+
+			for _, run := range []struct {
+				name string
+				out  string
+				code int
+			}{
+				{name: "server", out: "server\n"},
+				{name: "panics", out: "recovered: boom\n", code: 3},
+				{name: "unknown", out: "no such command\n", code: 2},
+			} {
+				if out, code := runBinary(t, binary, run.name); code != run.code || out != run.out {
+					t.Fatalf("expected %s to print %q with exit code %d, got %q and exit code %d", run.name, run.out, run.code, out, code)
+				}
+			}
+		})
+	}
+}
+
+func TestCommandPrefix(t *testing.T) {
+	dir := newModule(t, commandTree)
+
+	c := collected(t, dir, WithCommandPrefix("myorg-"))
+
+	files, err := c.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher := string(files["main.go"])
+
+	trim := strings.Index(dispatcher, `name = strings.TrimPrefix(name, "myorg-")`)
+	if trim < 0 || trim > strings.Index(dispatcher, "switch name {") {
+		t.Fatalf("expected the dispatcher to strip the prefix before switching:\n%s", dispatcher)
+	}
+
+	binary := buildBinary(t, c)
+
+	tests := []struct {
+		invoked string
+		out     string
+		code    int
+	}{
+		{invoked: "myorg-server", out: "server\n"},
+		{invoked: "myorg-alpha", out: "alpha\n"},
+		{invoked: "myorg-server.exe", out: "server\n"},
+		{invoked: "server", out: "server\n"},
+		{invoked: "other-server", out: "unknown command other-server\n", code: DefaultUnknownExitCode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.invoked, func(t *testing.T) {
+			if out, code := runBinary(t, binary, tt.invoked); code != tt.code || out != tt.out {
+				t.Fatalf("expected %q and exit code %d, got %q and exit code %d", tt.out, tt.code, out, code)
+			}
+		})
+	}
+
+	t.Run("subcommand", func(t *testing.T) {
+		_, err := New(dir, "cmd/combined", WithCommandPrefix("myorg-"), WithDispatch(DispatchSubcommand))
+		if err == nil || err.Error() != "a command prefix can't be used with subcommand dispatch" {
+			t.Fatalf("expected a command prefix to be rejected with subcommand dispatch, got %v", err)
+		}
+	})
+}
+
+func TestTrimSuffixes(t *testing.T) {
+	tests := []struct {
+		name     string
+		suffixes []string
+		invoked  string
+		out      string
+		code     int
+	}{
+		{name: "exe", invoked: "server.exe", out: "server\n"},
+		{name: "plain", invoked: "server", out: "server\n"},
+		{name: "unconfigured suffix", invoked: "server.sh", out: "unknown command server.sh\n", code: DefaultUnknownExitCode},
+		{name: "configured suffix", suffixes: []string{".sh"}, invoked: "server.sh", out: "server\n"},
+		{name: "configured and exe", suffixes: []string{"-v2"}, invoked: "server-v2.exe", out: "server\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, commandTree)
+
+			c := collected(t, dir, WithTrimSuffixes(tt.suffixes...))
+
+			files, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dispatcher := string(files["main.go"])
+			for _, suffix := range append([]string{".exe"}, tt.suffixes...) {
+				if want := fmt.Sprintf("name = strings.TrimSuffix(name, %q)", suffix); !strings.Contains(dispatcher, want) {
+					t.Fatalf("expected the dispatcher to contain %s:\n%s", want, dispatcher)
+				}
+			}
+
+			if out, code := runBinary(t, buildBinary(t, c), tt.invoked); code != tt.code || out != tt.out {
+				t.Fatalf("expected %q and exit code %d, got %q and exit code %d", tt.out, tt.code, out, code)
+			}
+		})
+	}
+}
+
+func TestSortBy(t *testing.T) {
+	tests := []struct {
+		name   string
+		sortBy SortBy
+		want   []string
+	}{
+		{name: "default", want: []string{"server", "zworker", "alpha"}},
+		{name: "import path", sortBy: SortByImportPath, want: []string{"server", "zworker", "alpha"}},
+		{name: "command", sortBy: SortByCommand, want: []string{"alpha", "server", "zworker"}},
+	}
+
+	for _, tt := range tests {
+		for _, dispatch := range []Dispatch{DispatchArgv0, DispatchSubcommand} {
+			t.Run(tt.name+"/"+string(dispatch), func(t *testing.T) {
+				dir := newModule(t, commandTree)
+
+				opts := []Option{WithDispatch(dispatch)}
+				if tt.sortBy != "" {
+					opts = append(opts, WithSortBy(tt.sortBy))
+				}
+
+				c := collected(t, dir, opts...)
+
+				files, err := c.Generate()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				f, err := parser.ParseFile(token.NewFileSet(), "main.go", files["main.go"], 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var cases []string
+
+				ast.Inspect(f, func(n ast.Node) bool {
+					if clause, ok := n.(*ast.CaseClause); ok && len(clause.List) == 1 {
+						name, err := strconv.Unquote(clause.List[0].(*ast.BasicLit).Value)
+						if err != nil {
+							t.Fatal(err)
+						}
+
+						cases = append(cases, name)
+					}
+
+					return true
+				})
+
+				if strings.Join(cases, " ") != strings.Join(tt.want, " ") {
+					t.Fatalf("expected cases in order %v, got %v", tt.want, cases)
+				}
+
+				binary := buildBinary(t, c)
+
+				if dispatch != DispatchSubcommand {
+					return
+				}
+
+				// the usage message lists commands in the same order
+				out, code := runBinary(t, binary, "")
+				if code != 2 {
+					t.Fatalf("expected usage and exit code 2, got %q and %d", out, code)
+				}
+
+				if want := "commands:\n  " + strings.Join(tt.want, "\n  ") + "\n"; !strings.HasSuffix(out, want) {
+					t.Fatalf("expected the usage message to end with %q, got %q", want, out)
+				}
+			})
+		}
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		dir := newModule(t, commandTree)
+
+		if _, err := New(dir, "cmd/combined", WithSortBy("size")); err == nil || err.Error() != `unknown command order "size"` {
+			t.Fatalf("expected an unknown order to be rejected, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,104 @@
+package combine
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+const dockerfileName = "Dockerfile"
+
+// dockerfile generates a multi-stage Dockerfile that builds the combined
+// binary from the service directory and, unless commands are selected by
+// argument, runs the install script to symlink every command to it.
+func (c *Combiner) dockerfile(outputs []*MainPackage) ([]byte, error) {
+	binary := filepath.Base(c.outputDir)
+
+	rel, inside := relativeInside(c.serviceDir, c.outputDir)
+	if !inside {
+		return nil, fmt.Errorf("%s is built from %s, so the output directory must be inside it", dockerfileName, c.serviceDir)
+	}
+
+	image := "golang"
+	if version, err := c.goVersion(); err != nil {
+		return nil, err
+	} else if version != "" {
+		image += ":" + version
+	}
+
+	cgo := false
+	for _, m := range outputs {
+		for _, src := range m.nonTestSources() {
+			if importsC(src.file) {
+				cgo = true
+			}
+		}
+	}
+
+	build := "go build"
+	if tags := c.goBuildTags(); len(tags) > 0 {
+		build += " -tags " + strings.Join(tags, ",")
+	}
+
+	// a static binary runs on busybox; cgo needs a C library
+	base := "busybox:1"
+	if cgo {
+		base = "debian:stable-slim"
+	} else {
+		build = "CGO_ENABLED=0 " + build
+	}
+
+	dir := path.Join("/src", rel)
+
+	root := c.module
+	if root == "" {
+		root = "the workspace"
+	}
+
+	var buf bytes.Buffer
+	_, _ = fmt.Fprintf(&buf, "# %s\n\n", generatedNotice)
+	_, _ = fmt.Fprintf(&buf, "# Build from the root of %s:\n#   docker build -f %s .\n\n", root, path.Join(rel, dockerfileName))
+	_, _ = fmt.Fprintf(&buf, "FROM %s AS build\nWORKDIR /src\nCOPY . .\nWORKDIR %s\nRUN %s -o /out/%s .\n\n", image, dir, build, binary)
+	_, _ = fmt.Fprintf(&buf, "FROM %s\nCOPY --from=build /out/%s /usr/local/bin/%s\n", base, binary, binary)
+
+	if c.dispatch == DispatchSubcommand {
+		_, _ = fmt.Fprintf(&buf, "ENTRYPOINT [%q]\n", "/usr/local/bin/"+binary)
+	} else {
+		_, _ = fmt.Fprintf(&buf, "COPY --from=build %s /tmp/%s\n", path.Join(dir, installScriptName), installScriptName)
+		_, _ = fmt.Fprintf(&buf, "RUN sh /tmp/%s /usr/local/bin && rm /tmp/%s\n", installScriptName, installScriptName)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// goVersion returns the go version of the service directory's go.mod, or
+// of its go.work if it is a workspace without a module of its own, or "" if
+// it has none.
+func (c *Combiner) goVersion() (string, error) {
+	if c.module == "" {
+		return c.workspaceGo, nil
+	}
+
+	in := c.inputs[0]
+
+	data, err := fs.ReadFile(in.fsys, goModName)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := modfile.ParseLax(goModName, data, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s %w", filepath.Join(in.dir, goModName), err)
+	}
+
+	if f.Go == nil {
+		return "", nil
+	}
+
+	return f.Go.Version, nil
+}
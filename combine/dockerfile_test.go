@@ -0,0 +1,154 @@
+package combine
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestDockerfile(t *testing.T) {
+	cgo := "package main\n\n// int answer(void) { return 42; }\nimport \"C\"\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"worker\", C.answer())\n}\n"
+
+	tests := []struct {
+		name     string
+		files    map[string]string
+		options  []Option
+		contains []string
+		symlinks []string
+	}{
+		{
+			name:     "argv0",
+			contains: []string{"FROM golang:1.16 AS build\n", "RUN CGO_ENABLED=0 go build -o /out/combined .\n", "FROM busybox:1\n", "RUN sh /tmp/install.sh /usr/local/bin"},
+			symlinks: []string{"server", "worker"},
+		},
+		{
+			name:     "prefix",
+			options:  []Option{WithCommandPrefix("myorg-")},
+			symlinks: []string{"myorg-server", "myorg-worker"},
+		},
+		{
+			name:     "build tags",
+			options:  []Option{WithBuildTags("debug")},
+			contains: []string{"RUN CGO_ENABLED=0 go build -tags debug -o /out/combined .\n"},
+			symlinks: []string{"server", "worker"},
+		},
+		{
+			name:     "cgo",
+			files:    map[string]string{"cmd/worker/main.go": cgo},
+			contains: []string{"RUN go build -o /out/combined .\n", "FROM debian:stable-slim\n"},
+			symlinks: []string{"server", "worker"},
+		},
+		{
+			name:     "subcommand",
+			options:  []Option{WithDispatch(DispatchSubcommand)},
+			contains: []string{`ENTRYPOINT ["/usr/local/bin/combined"]` + "\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{
+				"cmd/server/main.go": mainFile("server"),
+				"cmd/worker/main.go": mainFile("worker"),
+			}
+			for name, data := range tt.files {
+				files[name] = data
+			}
+
+			dir := newModule(t, files)
+
+			c := collected(t, dir, append([]Option{WithEmitDockerfile(true)}, tt.options...)...)
+			if err := c.Write(); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := ioutil.ReadFile(filepath.Join(c.outputDir, dockerfileName))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dockerfile := string(data)
+
+			for _, want := range tt.contains {
+				if !strings.Contains(dockerfile, want) {
+					t.Fatalf("expected %q in the Dockerfile:\n%s", want, dockerfile)
+				}
+			}
+
+			_, err = os.Stat(filepath.Join(c.outputDir, installScriptName))
+			if hasScript := err == nil; hasScript != (len(tt.symlinks) > 0) {
+				t.Fatalf("expected an install script to be written: %v, got %v", len(tt.symlinks) > 0, hasScript)
+			}
+
+			// run the build stage here, with /out in a temporary directory
+			out := t.TempDir()
+
+			for _, line := range strings.Split(dockerfile, "\n") {
+				if !strings.Contains(line, " go build ") {
+					continue
+				}
+
+				cmd := exec.Command("sh", "-c", strings.ReplaceAll(strings.TrimPrefix(line, "RUN "), "/out/", out+"/"))
+				cmd.Dir = c.outputDir
+
+				if output, err := cmd.CombinedOutput(); err != nil {
+					t.Fatalf("failed to run %q: %s\n%s", line, err, output)
+				}
+			}
+
+			if len(tt.symlinks) == 0 {
+				return
+			}
+
+			if output, err := exec.Command("sh", filepath.Join(c.outputDir, installScriptName), out).CombinedOutput(); err != nil {
+				t.Fatalf("install script failed: %s\n%s", err, output)
+			}
+
+			entries, err := os.ReadDir(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var links []string
+
+			for _, entry := range entries {
+				if entry.Name() != "combined" {
+					links = append(links, entry.Name())
+				}
+			}
+
+			sort.Strings(links)
+
+			if strings.Join(links, " ") != strings.Join(tt.symlinks, " ") {
+				t.Fatalf("expected symlinks %v in the image, got %v", tt.symlinks, links)
+			}
+
+			for _, name := range tt.symlinks {
+				if output, code := runBinary(t, filepath.Join(out, name), ""); code != 0 || !strings.HasPrefix(output, strings.TrimPrefix(name, "myorg-")) {
+					t.Fatalf("expected %s to run its command, got %q and exit code %d", name, output, code)
+				}
+			}
+		})
+	}
+
+	t.Run("outside the service directory", func(t *testing.T) {
+		dir := newModule(t, map[string]string{"cmd/server/main.go": mainFile("server")})
+
+		c, err := New(dir, filepath.Join(t.TempDir(), "combined"), WithEmitDockerfile(true), WithImportPrefix("example.com/combined"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.Collect(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := c.Generate(); err == nil || !strings.Contains(err.Error(), "the output directory must be inside it") {
+			t.Fatalf("expected an error about the output directory, got %v", err)
+		}
+	})
+}
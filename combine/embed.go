@@ -0,0 +1,153 @@
+package combine
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const embedDirective = "//go:embed "
+
+// collectEmbeds reads every file matched by a //go:embed directive in the
+// sources of m into m.Embedded, so it can be copied next to the transformed
+// source.
+func (c *Combiner) collectEmbeds(m *MainPackage) error {
+	for _, src := range m.sources {
+		dir := path.Dir(m.input.name(src.filename))
+
+		for _, cg := range src.file.Comments {
+			for _, comment := range cg.List {
+				if !strings.HasPrefix(comment.Text, embedDirective) {
+					continue
+				}
+
+				patterns, err := embedPatterns(strings.TrimPrefix(comment.Text, embedDirective))
+				if err != nil {
+					return fmt.Errorf("%s: invalid //go:embed directive: %w", src.fset.Position(comment.Pos()), err)
+				}
+
+				for _, pattern := range patterns {
+					if err := embedPattern(m.input.fsys, dir, pattern, m.Embedded); err != nil {
+						return fmt.Errorf("%s: %w", src.fset.Position(comment.Pos()), err)
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// embedPattern adds the files in dir, a name in fsys, matched by pattern to
+// files, keyed by their slash separated path relative to dir. Matched
+// directories are included recursively, skipping files starting with . or _
+// unless the pattern has the all: prefix.
+func embedPattern(fsys fs.FS, dir string, pattern string, files map[string][]byte) error {
+	all := strings.HasPrefix(pattern, "all:")
+	pattern = strings.TrimPrefix(pattern, "all:")
+
+	matches, err := fs.Glob(fsys, path.Join(dir, pattern))
+	if err != nil {
+		return fmt.Errorf("invalid embed pattern %q: %w", pattern, err)
+	}
+
+	if len(matches) == 0 {
+		return fmt.Errorf("embed pattern %q matches no files", pattern)
+	}
+
+	for _, match := range matches {
+		err := fs.WalkDir(fsys, match, func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			base := d.Name()
+
+			if name != match && !all && (strings.HasPrefix(base, ".") || strings.HasPrefix(base, "_")) {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+
+				return nil
+			}
+
+			if d.IsDir() {
+				return nil
+			}
+
+			data, err := fs.ReadFile(fsys, name)
+			if err != nil {
+				return err
+			}
+
+			files[strings.TrimPrefix(name, dir+"/")] = data
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// embedPatterns splits the arguments of a //go:embed directive, which are
+// separated by spaces and may be quoted.
+func embedPatterns(args string) ([]string, error) {
+	var patterns []string
+
+	for {
+		args = strings.TrimLeftFunc(args, unicode.IsSpace)
+		if args == "" {
+			return patterns, nil
+		}
+
+		var pattern string
+
+		switch args[0] {
+		case '"', '`':
+			quote := args[0]
+
+			i := 1
+			for ; i < len(args) && args[i] != quote; i++ {
+				if quote == '"' && args[i] == '\\' {
+					i++
+				}
+			}
+
+			if i >= len(args) {
+				return nil, fmt.Errorf("unterminated quoted pattern %s", args)
+			}
+
+			var err error
+			pattern, err = strconv.Unquote(args[:i+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted pattern %s: %w", args[:i+1], err)
+			}
+
+			args = args[i+1:]
+
+			if r, _ := utf8.DecodeRuneInString(args); args != "" && !unicode.IsSpace(r) {
+				return nil, fmt.Errorf("invalid quoted pattern %s", pattern)
+			}
+		default:
+			i := strings.IndexFunc(args, unicode.IsSpace)
+			if i < 0 {
+				i = len(args)
+			}
+
+			pattern, args = args[:i], args[i:]
+		}
+
+		if path.IsAbs(pattern) || strings.Contains("/"+pattern+"/", "/../") || strings.Contains("/"+pattern+"/", "/./") {
+			return nil, fmt.Errorf("invalid pattern %s: must be relative to the package directory", pattern)
+		}
+
+		patterns = append(patterns, pattern)
+	}
+}
@@ -0,0 +1,154 @@
+package combine
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestEmbed(t *testing.T) {
+	tests := []struct {
+		name      string
+		directive string
+		decl      string
+		print     string
+		want      []string
+		out       string
+		err       string
+	}{
+		{
+			name:      "file",
+			directive: "hello.txt",
+			decl:      "var hello string",
+			print:     "hello",
+			want:      []string{"hello.txt"},
+			out:       "hello\n",
+		},
+		{
+			name:      "glob",
+			directive: "static/*.txt",
+			decl:      "var static embed.FS",
+			print:     "list(static, \"static\")",
+			want:      []string{"static/_draft.txt", "static/a.txt", "static/b.txt"},
+			out:       "static/_draft.txt static/a.txt static/b.txt\n",
+		},
+		{
+			name:      "directory",
+			directive: "static",
+			decl:      "var static embed.FS",
+			print:     "list(static, \"static\")",
+			want:      []string{"static/a.txt", "static/b.txt", "static/sub/c.other", "static/sub/c.txt"},
+			out:       "static/a.txt static/b.txt static/sub/c.other static/sub/c.txt\n",
+		},
+		{
+			name:      "all",
+			directive: "all:static",
+			decl:      "var static embed.FS",
+			print:     "list(static, \"static\")",
+			want:      []string{"static/.hidden", "static/_draft.txt", "static/a.txt", "static/b.txt", "static/sub/c.other", "static/sub/c.txt"},
+			out:       "static/.hidden static/_draft.txt static/a.txt static/b.txt static/sub/c.other static/sub/c.txt\n",
+		},
+		{
+			name:      "quoted",
+			directive: "\"with space.txt\" hello.txt",
+			decl:      "var files embed.FS",
+			print:     "list(files, \".\")",
+			want:      []string{"hello.txt", "with space.txt"},
+			out:       "hello.txt with space.txt\n",
+		},
+		{
+			name:      "no match",
+			directive: "missing/*.txt",
+			decl:      "var missing embed.FS",
+			print:     "list(missing, \".\")",
+			err:       `embed pattern "missing/*.txt" matches no files`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go": `package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+//go:embed ` + tt.directive + `
+` + tt.decl + `
+
+var _ embed.FS
+
+// list returns the files below dir in fsys.
+func list(fsys fs.FS, dir string) string {
+	var names []string
+
+	_ = fs.WalkDir(fsys, dir, func(name string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			names = append(names, name)
+		}
+
+		return err
+	})
+
+	return strings.Join(names, " ")
+}
+
+func main() {
+	fmt.Println(` + tt.print + `)
+}
+`,
+				"cmd/server/hello.txt":          "hello",
+				"cmd/server/with space.txt":     "space",
+				"cmd/server/static/a.txt":       "a",
+				"cmd/server/static/b.txt":       "b",
+				"cmd/server/static/.hidden":     "hidden",
+				"cmd/server/static/_draft.txt":  "draft",
+				"cmd/server/static/sub/c.txt":   "c",
+				"cmd/server/static/sub/c.other": "other",
+			})
+
+			c := newCombiner(t, dir)
+
+			err := c.Collect()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			files, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+
+			for name := range files {
+				if strings.HasPrefix(name, "cmd_server/") && !strings.HasSuffix(name, ".go") {
+					got = append(got, strings.TrimPrefix(name, "cmd_server/"))
+				}
+			}
+
+			sort.Strings(got)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected embedded files %q, got %q", tt.want, got)
+			}
+
+			if out, code := runBinary(t, buildBinary(t, c), "server"); code != 0 || out != tt.out {
+				t.Fatalf("expected server to print %q, got %q and exit code %d", tt.out, out, code)
+			}
+		})
+	}
+}
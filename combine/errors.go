@@ -0,0 +1,127 @@
+package combine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/scanner"
+	"io/fs"
+	"strings"
+)
+
+// ParseError is returned when a Go file can't be parsed.
+type ParseError struct {
+	// File is the path of the file.
+	File string
+	// Err is the error of the parser, usually a scanner.ErrorList.
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("failed to parse %s %s", e.File, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// FormatError is returned when code generated for a file can't be
+// formatted, which means a transform produced invalid Go. Its message
+// includes the lines of the generated code around the first error.
+type FormatError struct {
+	// File is the path of the original file, or of the generated file
+	// relative to the output directory if it has no original.
+	File string
+	// Source is the generated code, nil if it was never printed.
+	Source []byte
+	// Err is the error of the formatter, usually a scanner.ErrorList.
+	Err error
+}
+
+// formatContext is the number of lines shown around the error.
+const formatContext = 2
+
+func (e *FormatError) Error() string {
+	msg := fmt.Sprintf("generated code for %s is not valid Go: %s", e.File, e.Err)
+
+	line := 0
+
+	var list scanner.ErrorList
+	var single *scanner.Error
+
+	switch {
+	case errors.As(e.Err, &list) && len(list) > 0:
+		line = list[0].Pos.Line
+	case errors.As(e.Err, &single):
+		line = single.Pos.Line
+	}
+
+	if line == 0 || e.Source == nil {
+		return msg
+	}
+
+	var b strings.Builder
+	_, _ = b.WriteString(msg)
+
+	lines := bytes.Split(e.Source, []byte("\n"))
+	for n := line - formatContext; n <= line+formatContext; n++ {
+		if n < 1 || n > len(lines) {
+			continue
+		}
+
+		marker := " "
+		if n == line {
+			marker = ">"
+		}
+
+		_, _ = fmt.Fprintf(&b, "\n\t%s %4d | %s", marker, n, lines[n-1])
+	}
+
+	return b.String()
+}
+
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
+
+// errNoModuleDirective is the Err of a ModuleError for a go.mod without a
+// module directive.
+var errNoModuleDirective = errors.New("no module directive")
+
+// ModuleError is returned when the module path of an input directory can't
+// be read from its go.mod.
+type ModuleError struct {
+	// Path is the path of the go.mod.
+	Path string
+	// Err is why it can't be read. It matches fs.ErrNotExist if there is no
+	// go.mod.
+	Err error
+}
+
+func (e *ModuleError) Error() string {
+	switch {
+	case errors.Is(e.Err, fs.ErrNotExist):
+		return fmt.Sprintf("no go.mod found at %s; the input directory must be the root of a module", e.Path)
+	case errors.Is(e.Err, errNoModuleDirective):
+		return fmt.Sprintf("go.mod at %s has no module directive", e.Path)
+	default:
+		return fmt.Sprintf("failed to read %s: %s", e.Path, e.Err)
+	}
+}
+
+func (e *ModuleError) Unwrap() error {
+	return e.Err
+}
+
+// DuplicateCommandError is returned by Collect when several directories
+// produce the same command name and WithAllowDuplicateCommands is not set,
+// or one of them sets the name with a //combiner:command comment.
+type DuplicateCommandError struct {
+	Command string
+	// Dirs are the directories of the commands, sorted.
+	Dirs []string
+}
+
+func (e *DuplicateCommandError) Error() string {
+	return fmt.Sprintf("duplicate command %q found in directories %s", e.Command, strings.Join(e.Dirs, ", "))
+}
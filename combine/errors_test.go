@@ -0,0 +1,209 @@
+package combine
+
+import (
+	"errors"
+	"go/ast"
+	"go/scanner"
+	"go/token"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestErrorTypes(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		fsys  fstest.MapFS
+		// check extracts the expected error type from err, which may be
+		// wrapped, and checks its fields
+		check func(t *testing.T, dir string, err error)
+	}{
+		{
+			name:  "parse error",
+			files: map[string]string{"cmd/broken/main.go": "package main\n\nfunc main() {\n"},
+			check: func(t *testing.T, dir string, err error) {
+				var parseErr *ParseError
+				if !errors.As(err, &parseErr) {
+					t.Fatalf("expected a *ParseError, got %v", err)
+				}
+
+				if want := filepath.Join(dir, "cmd", "broken", "main.go"); parseErr.File != want {
+					t.Fatalf("expected the error for %s, got %s", want, parseErr.File)
+				}
+
+				var list scanner.ErrorList
+				if !errors.As(err, &list) {
+					t.Fatalf("expected the parse error to wrap a scanner.ErrorList, got %v", parseErr.Err)
+				}
+			},
+		},
+		{
+			name: "duplicate command",
+			files: map[string]string{
+				"cmd/server/main.go":   mainFile("server"),
+				"tools/server/main.go": mainFile("tools server"),
+			},
+			check: func(t *testing.T, dir string, err error) {
+				var dup *DuplicateCommandError
+				if !errors.As(err, &dup) {
+					t.Fatalf("expected a *DuplicateCommandError, got %v", err)
+				}
+
+				if dup.Command != "server" || !reflect.DeepEqual(dup.Dirs, []string{"cmd/server", "tools/server"}) {
+					t.Fatalf("expected server in cmd/server and tools/server, got %s in %v", dup.Command, dup.Dirs)
+				}
+			},
+		},
+		{
+			name: "missing go.mod",
+			fsys: fstest.MapFS{"cmd/server/main.go": {Data: []byte(mainFile("server"))}},
+			check: func(t *testing.T, dir string, err error) {
+				var moduleErr *ModuleError
+				if !errors.As(err, &moduleErr) {
+					t.Fatalf("expected a *ModuleError, got %v", err)
+				}
+
+				if want := filepath.Join(dir, "go.mod"); moduleErr.Path != want {
+					t.Fatalf("expected the error for %s, got %s", want, moduleErr.Path)
+				}
+
+				if !errors.Is(err, fs.ErrNotExist) {
+					t.Fatalf("expected the error to match fs.ErrNotExist, got %v", err)
+				}
+			},
+		},
+		{
+			name: "no module directive",
+			fsys: fstest.MapFS{"go.mod": {Data: []byte("go 1.16\n")}},
+			check: func(t *testing.T, dir string, err error) {
+				var moduleErr *ModuleError
+				if !errors.As(err, &moduleErr) || !errors.Is(moduleErr, errNoModuleDirective) {
+					t.Fatalf("expected a *ModuleError without a module directive, got %v", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, tt.files)
+
+			var opts []Option
+			if tt.fsys != nil {
+				opts = append(opts, WithFS(tt.fsys))
+			}
+
+			// errors are returned by New or by Collect
+			c, err := New(dir, "cmd/combined", opts...)
+			if err == nil {
+				err = c.Collect()
+			}
+
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			tt.check(t, dir, err)
+		})
+	}
+}
+
+func TestFormatError(t *testing.T) {
+	t.Run("source file", func(t *testing.T) {
+		filename := filepath.Join(t.TempDir(), "cmd", "server", "main.go")
+
+		src, err := parseFile(fstest.MapFS{"main.go": {Data: []byte(mainFile("server"))}}, "main.go", filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// a transform producing a name that is not an identifier prints
+		// code that does not parse
+		ast.Inspect(src.file, func(n ast.Node) bool {
+			if fn, ok := n.(*ast.FuncDecl); ok {
+				fn.Name.Name = "not valid"
+			}
+
+			return true
+		})
+
+		_, err = render(src)
+
+		var formatErr *FormatError
+		if !errors.As(err, &formatErr) {
+			t.Fatalf("expected a *FormatError, got %v", err)
+		}
+
+		if formatErr.File != filename {
+			t.Fatalf("expected the error for %s, got %s", filename, formatErr.File)
+		}
+
+		for _, want := range []string{"generated code for " + filename + " is not valid Go: ", "> ", "func not valid() {"} {
+			if !strings.Contains(err.Error(), want) {
+				t.Fatalf("expected %q in the error, got:\n%s", want, err)
+			}
+		}
+	})
+
+	t.Run("dispatcher", func(t *testing.T) {
+		dir := newModule(t, map[string]string{"cmd/server/main.go": mainFile("server")})
+
+		c := collected(t, dir, WithDispatcherTemplate("package main\n\nfunc main() {\n\tgo\n}\n"))
+
+		_, err := c.Generate()
+
+		var formatErr *FormatError
+		if !errors.As(err, &formatErr) {
+			t.Fatalf("expected a *FormatError, got %v", err)
+		}
+
+		if formatErr.File != "main.go" {
+			t.Fatalf("expected the error for main.go, got %s", formatErr.File)
+		}
+
+		if want := "\n\t>    5 | }"; !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected %q in the error, got:\n%s", want, err)
+		}
+	})
+
+	source := []byte("package main\n\nfunc main() {\n\tgo\n}\n\nvar x int\n")
+
+	tests := []struct {
+		name string
+		err  *FormatError
+		want string
+	}{
+		{
+			name: "context",
+			err:  &FormatError{File: "main.go", Source: source, Err: scanner.ErrorList{{Pos: token.Position{Filename: "main.go", Line: 5}, Msg: "expected expression"}}},
+			want: "generated code for main.go is not valid Go: main.go:5: expected expression\n\t     3 | func main() {\n\t     4 | \tgo\n\t>    5 | }\n\t     6 | \n\t     7 | var x int",
+		},
+		{
+			name: "first line",
+			err:  &FormatError{File: "main.go", Source: source, Err: &scanner.Error{Pos: token.Position{Filename: "main.go", Line: 1}, Msg: "bad"}},
+			want: "generated code for main.go is not valid Go: main.go:1: bad\n\t>    1 | package main\n\t     2 | \n\t     3 | func main() {",
+		},
+		{
+			name: "no source",
+			err:  &FormatError{File: "main.go", Err: scanner.ErrorList{{Pos: token.Position{Filename: "main.go", Line: 5}, Msg: "expected expression"}}},
+			want: "generated code for main.go is not valid Go: main.go:5: expected expression",
+		},
+		{
+			name: "no position",
+			err:  &FormatError{File: "main.go", Source: source, Err: errors.New("printer failed")},
+			want: "generated code for main.go is not valid Go: printer failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.err.Error(); got != tt.want {
+				t.Fatalf("expected:\n%s\ngot:\n%s", tt.want, got)
+			}
+		})
+	}
+}
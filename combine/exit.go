@@ -0,0 +1,128 @@
+package combine
+
+import (
+	"bytes"
+	"go/ast"
+	"path"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// exitPackage is the name of the generated package holding the value main
+// functions panic with instead of calling os.Exit.
+const exitPackage = "exit"
+
+const exitImportName = "combinedexit"
+
+func (c *Combiner) exitImportPath() string {
+	return path.Join(c.outputImportPath(), exitPackage)
+}
+
+func exitSource() []byte {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString(generatedHeader + "\n\n")
+	_, _ = buf.WriteString(`// Package exit holds the panic value that replaces os.Exit in combined
+// commands.
+package exit
+
+import "strconv"
+
+// Error is raised with panic by a command that called os.Exit.
+type Error struct {
+	Code int
+}
+
+func (e Error) Error() string {
+	return "exit status " + strconv.Itoa(e.Code)
+}
+`)
+
+	return buf.Bytes()
+}
+
+// trapExit replaces every call to os.Exit within body, including calls in
+// nested blocks and function literals, with a panic of exit.Error. It reports
+// whether any call was replaced.
+func trapExit(f *ast.File, body *ast.BlockStmt) bool {
+	var names []string
+
+	for _, spec := range f.Imports {
+		if importPath(spec) != "os" {
+			continue
+		}
+
+		name := "os"
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+
+		names = append(names, name)
+	}
+
+	if len(names) == 0 {
+		return false
+	}
+
+	replaced := false
+
+	astutil.Apply(body, nil, func(cur *astutil.Cursor) bool {
+		call, ok := cur.Node().(*ast.CallExpr)
+		if !ok || len(call.Args) != 1 || !isExitCall(call, names) {
+			return true
+		}
+
+		cur.Replace(&ast.CallExpr{
+			Fun: ast.NewIdent("panic"),
+			Args: []ast.Expr{
+				&ast.CompositeLit{
+					Type: &ast.SelectorExpr{
+						X:   ast.NewIdent(exitImportName),
+						Sel: ast.NewIdent("Error"),
+					},
+					Elts: []ast.Expr{
+						&ast.KeyValueExpr{
+							Key:   ast.NewIdent("Code"),
+							Value: call.Args[0],
+						},
+					},
+				},
+			},
+		})
+
+		replaced = true
+
+		return true
+	})
+
+	return replaced
+}
+
+// isExitCall reports whether call calls Exit from the os package imported
+// under one of names.
+func isExitCall(call *ast.CallExpr, names []string) bool {
+	switch fun := call.Fun.(type) {
+	case *ast.SelectorExpr:
+		ident, ok := fun.X.(*ast.Ident)
+		if !ok || ident.Obj != nil || fun.Sel.Name != "Exit" {
+			return false
+		}
+
+		for _, name := range names {
+			if ident.Name == name {
+				return true
+			}
+		}
+	case *ast.Ident:
+		if fun.Obj != nil || fun.Name != "Exit" {
+			return false
+		}
+
+		for _, name := range names {
+			if name == "." {
+				return true
+			}
+		}
+	}
+
+	return false
+}
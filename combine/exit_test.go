@@ -0,0 +1,99 @@
+package combine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTrapExit(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		code   int
+	}{
+		{
+			name: "plain",
+			source: `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	defer fmt.Println("cleanup")
+
+	os.Exit(3)
+}
+`,
+			code: 3,
+		},
+		{
+			name: "nested and aliased",
+			source: `package main
+
+import (
+	"fmt"
+	sys "os"
+)
+
+func main() {
+	defer fmt.Println("cleanup")
+
+	fail := func(code int) {
+		if code > 0 {
+			sys.Exit(code)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		fail(i)
+	}
+}
+`,
+			code: 1,
+		},
+		{
+			name: "dot import",
+			source: `package main
+
+import (
+	"fmt"
+	. "os"
+)
+
+func main() {
+	defer fmt.Println("cleanup")
+
+	if len(Args) > 0 {
+		Exit(5)
+	}
+}
+`,
+			code: 5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{"cmd/server/main.go": tt.source})
+
+			c := collected(t, dir, WithTrapExit(true))
+
+			files, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if data := string(files["cmd_server/main.go"]); strings.Contains(data, "Exit(") || !strings.Contains(data, "panic(combinedexit.Error{Code: ") {
+				t.Fatalf("expected every call to os.Exit to be replaced:\n%s", data)
+			}
+
+			// deferred functions only run if the exit was trapped
+			out, code := runBinary(t, buildBinary(t, c), "server")
+			if code != tt.code || out != "cleanup\n" {
+				t.Fatalf("expected exit code %d after cleanup, got %d: %q", tt.code, code, out)
+			}
+		})
+	}
+}
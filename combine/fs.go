@@ -0,0 +1,39 @@
+package combine
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// name returns the name of fullPath, a path below the input directory, in
+// the file system of the input.
+func (in *input) name(fullPath string) string {
+	rel := relative(in.dir, fullPath)
+	if rel == "" {
+		return "."
+	}
+
+	return rel
+}
+
+// realPath returns a path identifying the directory called name in the
+// file system of the input once symlinks are resolved. Only the operating
+// system's file system has symlinks.
+func (in *input) realPath(name string) (string, error) {
+	if !in.os {
+		return name, nil
+	}
+
+	return filepath.EvalSymlinks(filepath.Join(in.dir, filepath.FromSlash(name)))
+}
+
+// relativeInside returns the name of fullPath relative to dir, as with
+// input.name, and whether fullPath is dir or below it.
+func relativeInside(dir string, fullPath string) (string, bool) {
+	rel, err := filepath.Rel(dir, fullPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+
+	return filepath.ToSlash(rel), true
+}
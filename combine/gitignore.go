@@ -0,0 +1,163 @@
+package combine
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// gitignore matches paths against the rules of the .gitignore files loaded
+// so far. It implements patterns, including **, negation with !, and
+// directory only patterns ending in /.
+type gitignore struct {
+	in    *input
+	rules []ignoreRule
+}
+
+type ignoreRule struct {
+	// base is the directory of the .gitignore the rule came from
+	base     string
+	segments []string
+	// anchored rules contain a slash and are matched against the path
+	// relative to base, others against the base name at any level
+	anchored bool
+	negate   bool
+	dirOnly  bool
+}
+
+// newGitignore loads the .gitignore files above the directory of in, up to
+// the root of the git repository containing it. The .gitignore of the
+// directory itself and those below it are loaded as they are walked. Only
+// the operating system's file system has anything above the directory.
+func newGitignore(in *input) (*gitignore, error) {
+	g := &gitignore{in: in}
+
+	if !in.os {
+		return g, nil
+	}
+
+	dir := in.dir
+
+	var parents []string
+
+	for d := filepath.Dir(dir); ; d = filepath.Dir(d) {
+		parents = append(parents, d)
+
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			break
+		}
+
+		if filepath.Dir(d) == d {
+			// not in a repository, so only .gitignore files from dir down
+			// apply
+			parents = nil
+			break
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		parents = nil
+	}
+
+	for i := len(parents) - 1; i >= 0; i-- {
+		if err := g.load(parents[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return g, nil
+}
+
+// load adds the rules of the .gitignore in dir, if there is one. Rules
+// loaded later take precedence.
+func (g *gitignore) load(dir string) error {
+	var (
+		data []byte
+		err  error
+	)
+
+	if rel, inside := relativeInside(g.in.dir, dir); inside {
+		data, err = fs.ReadFile(g.in.fsys, path.Join(rel, ".gitignore"))
+	} else {
+		data, err = ioutil.ReadFile(filepath.Join(dir, ".gitignore"))
+	}
+
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{base: dir}
+
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+
+		rule.anchored = strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+
+		if line == "" {
+			continue
+		}
+
+		rule.segments = strings.Split(line, "/")
+		g.rules = append(g.rules, rule)
+	}
+
+	return scanner.Err()
+}
+
+// ignored reports whether fullPath is ignored. The last matching rule
+// decides.
+func (g *gitignore) ignored(fullPath string, isDir bool) bool {
+	ignored := false
+
+	for _, rule := range g.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+
+		rel, err := filepath.Rel(rule.base, fullPath)
+		if err != nil || rel == "." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || rel == ".." {
+			continue
+		}
+
+		var ok bool
+
+		if rule.anchored {
+			ok, err = matchSegments(rule.segments, strings.Split(filepath.ToSlash(rel), "/"))
+		} else {
+			ok, err = path.Match(rule.segments[0], filepath.Base(fullPath))
+		}
+
+		if err == nil && ok {
+			ignored = !rule.negate
+		}
+	}
+
+	return ignored
+}
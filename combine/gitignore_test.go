@@ -0,0 +1,127 @@
+package combine
+
+import (
+	"path"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRespectGitignore(t *testing.T) {
+	all := []string{"gen", "legacy", "server", "tool", "worker"}
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		// repository is the directory of a fake git repository
+		repository string
+		respect    bool
+		want       []string
+	}{
+		{
+			name:  "not respected",
+			files: map[string]string{"fx/.gitignore": "cmd/gen/\n"},
+			want:  all,
+		},
+		{
+			name:    "directory",
+			files:   map[string]string{"fx/.gitignore": "# generated commands\ncmd/gen/\n"},
+			respect: true,
+			want:    []string{"legacy", "server", "tool", "worker"},
+		},
+		{
+			name:    "base name at any level",
+			files:   map[string]string{"fx/.gitignore": "legacy\n"},
+			respect: true,
+			want:    []string{"gen", "server", "tool", "worker"},
+		},
+		{
+			name:    "anchored",
+			files:   map[string]string{"fx/.gitignore": "/legacy\n"},
+			respect: true,
+			want:    all,
+		},
+		{
+			name:    "negation",
+			files:   map[string]string{"fx/.gitignore": "cmd/*\n!cmd/server\n"},
+			respect: true,
+			want:    []string{"legacy", "server", "tool"},
+		},
+		{
+			name:    "double star",
+			files:   map[string]string{"fx/.gitignore": "**/generated\n"},
+			respect: true,
+			want:    []string{"gen", "legacy", "server", "worker"},
+		},
+		{
+			name:    "directory only pattern and a file",
+			files:   map[string]string{"fx/.gitignore": "main.go/\n"},
+			respect: true,
+			want:    all,
+		},
+		{
+			name:    "file",
+			files:   map[string]string{"fx/.gitignore": "cmd/worker/main.go\n"},
+			respect: true,
+			want:    []string{"gen", "legacy", "server", "tool"},
+		},
+		{
+			name:    "nested",
+			files:   map[string]string{"fx/cmd/.gitignore": "gen\n"},
+			respect: true,
+			want:    []string{"legacy", "server", "tool", "worker"},
+		},
+		{
+			name:       "parent in the repository",
+			files:      map[string]string{".gitignore": "legacy/\n"},
+			repository: ".",
+			respect:    true,
+			want:       []string{"gen", "server", "tool", "worker"},
+		},
+		{
+			name:       "parent outside the repository",
+			files:      map[string]string{".gitignore": "legacy/\n"},
+			repository: "fx",
+			respect:    true,
+			want:       all,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+
+			files := map[string]string{
+				"fx/go.mod":                             "module " + testModule + "\n\ngo 1.16\n",
+				"fx/cmd/server/main.go":                 mainFile("server"),
+				"fx/cmd/worker/main.go":                 mainFile("worker"),
+				"fx/cmd/gen/main.go":                    mainFile("gen"),
+				"fx/tools/legacy/main.go":               mainFile("legacy"),
+				"fx/internal/generated/tool/main.go":    mainFile("tool"),
+				"fx/internal/generated/tool/helpers.go": "package main\n",
+			}
+			for name, data := range tt.files {
+				files[name] = data
+			}
+
+			if tt.repository != "" {
+				files[path.Join(tt.repository, ".git/HEAD")] = "ref: refs/heads/main\n"
+			}
+
+			writeFiles(t, root, files)
+
+			dir := filepath.Join(root, "fx")
+
+			c := collected(t, dir, WithRespectGitignore(tt.respect))
+
+			if got := commandNames(c); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected commands %q, got %q", tt.want, got)
+			}
+
+			// the go tool fails to stamp a build in a fake repository
+			if tt.repository == "" {
+				buildOutput(t, c)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package combine
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
+)
+
+const goModName = "go.mod"
+
+// pseudoVersion is required by the go tool for modules that are only
+// resolved through a replace directive.
+const pseudoVersion = "v0.0.0-00010101000000-000000000000"
+
+// goMod generates a go.mod that makes the output directory its own module.
+// It requires each input module, replaced by a relative path back to its
+// directory, along with the input modules' own requirements.
+func (c *Combiner) goMod() ([]byte, error) {
+	f := &modfile.File{}
+	f.AddComment(generatedHeader)
+
+	if err := f.AddModuleStmt(c.outputImportPath()); err != nil {
+		return nil, err
+	}
+
+	// without a module of its own, the go version comes from the go.work
+	if c.module == "" && c.workspaceGo != "" {
+		if err := f.AddGoStmt(c.workspaceGo); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, in := range c.inputs {
+		if err := c.addInput(f, in); err != nil {
+			return nil, err
+		}
+	}
+
+	f.SortBlocks()
+	f.Cleanup()
+
+	return f.Format()
+}
+
+// addInput adds the requirements and replacements for an input module to f.
+func (c *Combiner) addInput(f *modfile.File, in *input) error {
+	filename := filepath.Join(in.dir, goModName)
+
+	data, err := fs.ReadFile(in.fsys, goModName)
+	if err != nil {
+		return err
+	}
+
+	parent, err := modfile.Parse(filename, data, nil)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s %w", filename, err)
+	}
+
+	if in.primary && parent.Go != nil {
+		if err := f.AddGoStmt(parent.Go.Version); err != nil {
+			return err
+		}
+	}
+
+	addRequire(f, in.module, pseudoVersion, false)
+
+	for _, r := range parent.Require {
+		if !c.isInput(r.Mod.Path) {
+			addRequire(f, r.Mod.Path, r.Mod.Version, r.Indirect)
+		}
+	}
+
+	dir, err := c.relativeToOutput(in.dir)
+	if err != nil {
+		return err
+	}
+
+	if err := f.AddReplace(in.module, "", dir, ""); err != nil {
+		return err
+	}
+
+	for _, r := range parent.Replace {
+		if c.isInput(r.Old.Path) {
+			continue
+		}
+
+		newPath := r.New.Path
+
+		if modfile.IsDirectoryPath(newPath) && !filepath.IsAbs(newPath) {
+			newPath, err = c.relativeToOutput(filepath.Join(in.dir, filepath.FromSlash(newPath)))
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := f.AddReplace(r.Old.Path, r.Old.Version, newPath, r.New.Version); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addRequire adds a requirement to f, keeping the higher version when
+// modulePath is already required.
+func addRequire(f *modfile.File, modulePath string, version string, indirect bool) {
+	for _, r := range f.Require {
+		if r.Mod.Path != modulePath {
+			continue
+		}
+
+		if semver.Compare(version, r.Mod.Version) > 0 {
+			_ = f.AddRequire(modulePath, version)
+		}
+
+		return
+	}
+
+	f.AddNewRequire(modulePath, version, indirect)
+}
+
+func (c *Combiner) isInput(modulePath string) bool {
+	for _, in := range c.inputs {
+		if in.module == modulePath {
+			return true
+		}
+	}
+
+	return false
+}
+
+// relativeToOutput returns dir as a slash separated path relative to the
+// output directory, suitable for a replace directive.
+func (c *Combiner) relativeToOutput(dir string) (string, error) {
+	rel, err := filepath.Rel(c.outputDir, dir)
+	if err != nil {
+		return "", err
+	}
+
+	rel = filepath.ToSlash(rel)
+	if !strings.HasPrefix(rel, "../") {
+		rel = "./" + rel
+	}
+
+	return rel, nil
+}
@@ -0,0 +1,68 @@
+package combine
+
+import (
+	"testing"
+
+	"golang.org/x/mod/modfile"
+)
+
+func TestEmitGoMod(t *testing.T) {
+	dir := newModule(t, map[string]string{
+		"go.mod":             "module " + testModule + "\n\ngo 1.16\n\nrequire example.com/dep v1.2.3\n\nreplace example.com/dep => ./dep\n",
+		"dep/go.mod":         "module example.com/dep\n\ngo 1.16\n",
+		"cmd/server/main.go": mainFile("server"),
+	})
+
+	c := collected(t, dir, WithEmitGoMod(true))
+
+	files, err := c.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := modfile.Parse(goModName, files[goModName], nil)
+	if err != nil {
+		t.Fatalf("generated %s does not parse: %s\n%s", goModName, err, files[goModName])
+	}
+
+	if got, want := f.Module.Mod.Path, testModule+"/cmd/combined"; got != want {
+		t.Fatalf("expected module %s, got %s", want, got)
+	}
+
+	if f.Go == nil || f.Go.Version != "1.16" {
+		t.Fatalf("expected the go version of the input module, got %v", f.Go)
+	}
+
+	requires := make(map[string]string)
+	for _, r := range f.Require {
+		requires[r.Mod.Path] = r.Mod.Version
+	}
+
+	replaces := make(map[string]string)
+	for _, r := range f.Replace {
+		replaces[r.Old.Path] = r.New.Path
+	}
+
+	tests := []struct {
+		module  string
+		version string
+		replace string
+	}{
+		{module: testModule, version: pseudoVersion, replace: "../.."},
+		{module: "example.com/dep", version: "v1.2.3", replace: "../../dep"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.module, func(t *testing.T) {
+			if got := requires[tt.module]; got != tt.version {
+				t.Errorf("expected %s to be required at %q, got %q", tt.module, tt.version, got)
+			}
+
+			if got := replaces[tt.module]; got != tt.replace {
+				t.Errorf("expected %s to be replaced by %q, got %q", tt.module, tt.replace, got)
+			}
+		})
+	}
+
+	buildOutput(t, c)
+}
@@ -0,0 +1,70 @@
+package combine
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// group is a set of commands built into one binary by their own dispatcher.
+type group struct {
+	// dir is the slash separated directory of the dispatcher relative to
+	// the output directory, "" for the output directory itself
+	dir      string
+	commands []*MainPackage
+}
+
+// groupBinary returns the name of the binary built from the dispatcher of
+// g.
+func (c *Combiner) groupBinary(g group) string {
+	if g.dir == "" {
+		return filepath.Base(c.outputDir)
+	}
+
+	return g.dir
+}
+
+// groups partitions outputs, in dispatcher order, into the groups that get
+// a dispatcher each. Without WithGroupBy every command is in a single group
+// in the output directory. Otherwise a command is in the group named after
+// the element of its source directory at that depth, so with a depth of 2
+// cmd/admin/users is in admin. Commands whose source directory is too
+// shallow to have such an element are in the output directory's group.
+func (c *Combiner) groups(outputs []*MainPackage) []group {
+	byDir := make(map[string][]*MainPackage)
+
+	for _, m := range outputs {
+		dir := ""
+
+		if c.groupBy > 0 && m.SourceDir != "." {
+			if elements := strings.Split(m.SourceDir, "/"); len(elements) > c.groupBy {
+				dir = elements[c.groupBy-1]
+			}
+		}
+
+		byDir[dir] = append(byDir[dir], m)
+	}
+
+	var groups []group
+	for dir, commands := range byDir {
+		groups = append(groups, group{dir: dir, commands: commands})
+	}
+
+	// the output directory always gets a dispatcher, even without commands
+	if len(groups) == 0 {
+		groups = append(groups, group{})
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].dir < groups[j].dir
+	})
+
+	return groups
+}
+
+// dispatcherName returns the path of the group's dispatcher relative to the
+// output directory.
+func (c *Combiner) dispatcherName(g group) string {
+	return path.Join(g.dir, c.dispatcherFilename)
+}
@@ -0,0 +1,138 @@
+package combine
+
+import (
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestGroupBy(t *testing.T) {
+	tests := []struct {
+		name   string
+		depth  int
+		groups map[string][]string
+	}{
+		{
+			name:   "no groups",
+			groups: map[string][]string{"": {"profile", "roles", "top", "users"}},
+		},
+		{
+			name:  "depth 2",
+			depth: 2,
+			groups: map[string][]string{
+				"":      {"top"},
+				"admin": {"roles", "users"},
+				"user":  {"profile"},
+			},
+		},
+		{
+			name:   "deeper than every command",
+			depth:  3,
+			groups: map[string][]string{"": {"profile", "roles", "top", "users"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/admin/users/main.go":  mainFile("users"),
+				"cmd/admin/roles/main.go":  mainFile("roles"),
+				"cmd/user/profile/main.go": mainFile("profile"),
+				"cmd/top/main.go":          mainFile("top"),
+			})
+
+			c := collected(t, dir, WithGroupBy(tt.depth))
+
+			generated, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// one dispatcher per group, with no other dispatchers
+			var dispatchers []string
+
+			for name := range generated {
+				if path := strings.TrimSuffix(name, "/main.go"); name == "main.go" || tt.groups[path] != nil {
+					dispatchers = append(dispatchers, name)
+				}
+			}
+
+			if len(dispatchers) != len(tt.groups) {
+				t.Fatalf("expected %d dispatchers, got %v", len(tt.groups), dispatchers)
+			}
+
+			if err := c.Write(); err != nil {
+				t.Fatal(err)
+			}
+
+			seen := make(map[string]string)
+
+			for group, commands := range tt.groups {
+				groupDir := filepath.Join(c.outputDir, filepath.FromSlash(group))
+				binary := filepath.Join(t.TempDir(), filepath.Base(groupDir))
+
+				cmd := exec.Command("go", "build", "-o", binary, ".")
+				cmd.Dir = groupDir
+
+				if out, err := cmd.CombinedOutput(); err != nil {
+					t.Fatalf("failed to build the dispatcher of group %q: %s\n%s", group, err, out)
+				}
+
+				for _, name := range commands {
+					if other, ok := seen[name]; ok {
+						t.Fatalf("%s is in groups %q and %q", name, other, group)
+					}
+
+					seen[name] = group
+
+					if out, code := runBinary(t, binary, name); code != 0 || out != name+"\n" {
+						t.Fatalf("expected %s to print its name in group %q, got %q and exit code %d", name, group, out, code)
+					}
+				}
+
+				// commands of other groups are unknown
+				for _, name := range []string{"profile", "roles", "top", "users"} {
+					if contains(commands, name) {
+						continue
+					}
+
+					if _, code := runBinary(t, binary, name); code == 0 {
+						t.Fatalf("expected %s to be unknown in group %q", name, group)
+					}
+				}
+			}
+
+			var all []string
+			for name := range seen {
+				all = append(all, name)
+			}
+
+			sort.Strings(all)
+
+			if !reflect.DeepEqual(all, commandNames(c)) {
+				t.Fatalf("expected every command in a group, got %v", all)
+			}
+		})
+	}
+
+	t.Run("negative depth", func(t *testing.T) {
+		dir := newModule(t, map[string]string{"cmd/top/main.go": mainFile("top")})
+
+		if _, err := New(dir, "cmd/combined", WithGroupBy(-1)); err == nil || !strings.Contains(err.Error(), "group depth must not be negative") {
+			t.Fatalf("expected a negative depth to be rejected, got %v", err)
+		}
+	})
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,185 @@
+package combine
+
+import (
+	"go/ast"
+	"go/token"
+	"path"
+	"regexp"
+	"strconv"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/imports"
+)
+
+// majorVersion matches import path elements like v2 that are usually not
+// the name of the imported package.
+var majorVersion = regexp.MustCompile(`^v[0-9]+$`)
+
+type importKey struct {
+	file *sourceFile
+	path string
+}
+
+// importUsage records which imports of sources are used, so imports that
+// become unused by transformation can be told apart from imports whose
+// package name cannot be guessed from their path.
+func importUsage(sources []*sourceFile) map[importKey]bool {
+	used := make(map[importKey]bool)
+
+	for _, src := range sources {
+		for _, spec := range src.file.Imports {
+			p := importPath(spec)
+			used[importKey{src, p}] = usesImport(src.file, spec)
+		}
+	}
+
+	return used
+}
+
+// reconcileImports removes imports that were used before transformation
+// but no longer are, then makes every file of the package refer to an
+// import path by the same name where that can be done safely.
+func reconcileImports(sources []*sourceFile, before map[importKey]bool) {
+	for _, src := range sources {
+		for _, spec := range src.file.Imports {
+			p := importPath(spec)
+			if !before[importKey{src, p}] || usesImport(src.file, spec) {
+				continue
+			}
+
+			name := ""
+			if spec.Name != nil {
+				name = spec.Name.Name
+			}
+
+			astutil.DeleteNamedImport(src.fset, src.file, name, p)
+		}
+	}
+
+	names := make(map[string]string)
+
+	for _, src := range sources {
+		for _, spec := range src.file.Imports {
+			p := importPath(spec)
+			name := importName(spec)
+
+			want, ok := names[p]
+			if !ok {
+				names[p] = name
+				continue
+			}
+
+			if name != want {
+				renameImport(src, spec, want)
+			}
+		}
+	}
+}
+
+// renameImport changes the local name of spec in src to name, unless name
+// is already used by any identifier in the file.
+func renameImport(src *sourceFile, spec *ast.ImportSpec, name string) {
+	old := importName(spec)
+	if old == "" || old == "_" || old == "." || name == "" || name == "_" || name == "." {
+		return
+	}
+
+	conflict := false
+
+	ast.Inspect(src.file, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Ident); ok && ident.Name == name {
+			conflict = true
+		}
+
+		return !conflict
+	})
+
+	if conflict {
+		return
+	}
+
+	for _, ident := range packageRefs(src.file, old) {
+		ident.Name = name
+	}
+
+	if name == guessName(importPath(spec)) {
+		spec.Name = nil
+	} else {
+		spec.Name = &ast.Ident{NamePos: spec.Path.Pos(), Name: name}
+	}
+}
+
+func usesImport(f *ast.File, spec *ast.ImportSpec) bool {
+	name := importName(spec)
+	if name == "" || name == "_" || name == "." {
+		return true
+	}
+
+	return len(packageRefs(f, name)) > 0
+}
+
+// packageRefs returns the identifiers in f that refer to the imported
+// package called name, i.e. unresolved identifiers qualifying a selector.
+func packageRefs(f *ast.File, name string) []*ast.Ident {
+	var refs []*ast.Ident
+
+	ast.Inspect(f, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+
+		if ident, ok := sel.X.(*ast.Ident); ok && ident.Obj == nil && ident.Name == name {
+			refs = append(refs, ident)
+		}
+
+		return true
+	})
+
+	return refs
+}
+
+// importName returns the name spec is referred to by, guessing from the path
+// when there is no explicit name. It returns "" if no guess can be made.
+func importName(spec *ast.ImportSpec) string {
+	if spec.Name != nil {
+		return spec.Name.Name
+	}
+
+	return guessName(importPath(spec))
+}
+
+func guessName(importPath string) string {
+	name := path.Base(importPath)
+	if !token.IsIdentifier(name) || majorVersion.MatchString(name) {
+		return ""
+	}
+
+	return name
+}
+
+func importPath(spec *ast.ImportSpec) string {
+	p, err := strconv.Unquote(spec.Path.Value)
+	if err != nil {
+		return spec.Path.Value
+	}
+
+	return p
+}
+
+// groupImports sorts the imports of the formatted source data the way
+// goimports does, with the standard library in its own group. Imports are
+// never added or removed.
+func groupImports(filename string, data []byte) ([]byte, error) {
+	out, err := imports.Process(filename, data, &imports.Options{
+		Comments:   true,
+		TabIndent:  true,
+		TabWidth:   8,
+		FormatOnly: true,
+	})
+	if err != nil {
+		return nil, &FormatError{File: filename, Source: data, Err: err}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,181 @@
+package combine
+
+import (
+	"go/parser"
+	"go/token"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReconcileImports(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		opts  []Option
+		// imports are the import paths of each generated file, by name
+		imports map[string][]string
+		// aliases are the import paths every file must refer to by the
+		// same name
+		aliases []string
+	}{
+		{
+			name: "unused after rename",
+			files: map[string]string{
+				"cmd/server/main.go": `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	fmt.Println(greeting())
+	os.Exit(3)
+}
+`,
+				"cmd/server/helpers.go": `package main
+
+import "fmt"
+
+func greeting() string {
+	return fmt.Sprint("server")
+}
+`,
+			},
+			opts: []Option{WithTrapExit(true)},
+			imports: map[string][]string{
+				"main.go":    {"fmt", testModule + "/cmd/combined/exit"},
+				"helpers.go": {"fmt"},
+			},
+		},
+		{
+			name: "aliases",
+			files: map[string]string{
+				"cmd/server/main.go": `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println(greeting())
+}
+`,
+				"cmd/server/helpers.go": `package main
+
+import format "fmt"
+
+func greeting() string {
+	return format.Sprint("server")
+}
+`,
+			},
+			imports: map[string][]string{
+				"main.go":    {"fmt"},
+				"helpers.go": {"fmt"},
+			},
+			aliases: []string{"fmt"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, tt.files)
+
+			c := collected(t, dir, tt.opts...)
+
+			files, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			names := make(map[string]map[string]bool)
+
+			for file, want := range tt.imports {
+				f, err := parser.ParseFile(token.NewFileSet(), file, files["cmd_server/"+file], parser.ImportsOnly)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var got []string
+
+				for _, spec := range f.Imports {
+					p := importPath(spec)
+					got = append(got, p)
+
+					if names[p] == nil {
+						names[p] = make(map[string]bool)
+					}
+
+					names[p][importName(spec)] = true
+				}
+
+				if strings.Join(got, " ") != strings.Join(want, " ") {
+					t.Errorf("expected %s to import %v, got %v", file, want, got)
+				}
+			}
+
+			for _, p := range tt.aliases {
+				if len(names[p]) != 1 {
+					t.Errorf("expected every file to refer to %s by the same name, got %v", p, names[p])
+				}
+			}
+
+			buildOutput(t, c)
+		})
+	}
+}
+
+func TestDispatcherImportGroups(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []Option
+		groups [][]string
+	}{
+		{
+			name: "argv0",
+			groups: [][]string{
+				{`"fmt"`, `"os"`, `"path/filepath"`, `"strings"`},
+				{`cmd_server "example.com/fx/cmd/combined/cmd_server"`, `cmd_zworker "example.com/fx/cmd/combined/cmd_zworker"`, `tools_alpha "example.com/fx/cmd/combined/tools_alpha"`},
+			},
+		},
+		{
+			name: "trap exit",
+			opts: []Option{WithTrapExit(true), WithDispatch(DispatchSubcommand)},
+			groups: [][]string{
+				{`"fmt"`, `"os"`, `"path/filepath"`},
+				{`cmd_server "example.com/fx/cmd/combined/cmd_server"`, `cmd_zworker "example.com/fx/cmd/combined/cmd_zworker"`, `combinedexit "example.com/fx/cmd/combined/exit"`, `tools_alpha "example.com/fx/cmd/combined/tools_alpha"`},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, commandTree)
+
+			files := generate(t, dir, tt.opts...)
+
+			data := string(files["main.go"])
+			start := strings.Index(data, "import (\n")
+			end := strings.Index(data, "\n)\n")
+
+			if start < 0 || end < start {
+				t.Fatalf("no import block in the dispatcher:\n%s", data)
+			}
+
+			var groups [][]string
+
+			for _, group := range strings.Split(data[start+len("import (\n"):end], "\n\n") {
+				var specs []string
+				for _, spec := range strings.Split(group, "\n") {
+					specs = append(specs, strings.TrimSpace(spec))
+				}
+
+				groups = append(groups, specs)
+			}
+
+			if !reflect.DeepEqual(groups, tt.groups) {
+				t.Fatalf("expected import groups %q, got %q", tt.groups, groups)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package combine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// stateName is the file in the output directory recording, in incremental
+// mode, the hash of the inputs of each generated package.
+const stateName = ".main-combiner-state.json"
+
+type state struct {
+	// Packages maps Packages keys to the hash of their inputs.
+	Packages map[string]string `json:"packages"`
+}
+
+// loadState reads the state written by an earlier incremental run. A
+// missing state file is not an error.
+func (c *Combiner) loadState() (*state, error) {
+	s := &state{Packages: make(map[string]string)}
+
+	data, err := ioutil.ReadFile(filepath.Join(c.outputDir, stateName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", stateName, err)
+	}
+
+	return s, nil
+}
+
+func (c *Combiner) stateFile() ([]byte, error) {
+	s := state{Packages: make(map[string]string)}
+
+	for key, m := range c.packages {
+		s.Packages[key] = m.hash
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(data, '\n'), nil
+}
+
+// packageHash hashes the sources of m together with every setting that
+// changes how they are rewritten, so a changed option regenerates the
+// package.
+func (c *Combiner) packageHash(m *MainPackage) string {
+	h := sha256.New()
+
+	_, _ = fmt.Fprintf(h, "%s\n%s\n%s\n%s\n%t\n%s\n%t\n%t\n%s\n%t\n%t\n%t\n%s\n%t\n",
+		m.PackageName,
+		c.outputImportPath(),
+		c.entrypointName,
+		c.exitImportPath(),
+		c.trapExit,
+		c.packageDoc,
+		c.prefixIdentifiers,
+		c.deferInit,
+		c.versionVar,
+		c.simplify,
+		c.singleFile,
+		c.lineDirectives,
+		c.standaloneTag,
+		c.wrapper,
+	)
+
+	// a predicate can't be compared between runs, but its decisions can
+	if rename := c.renamePredicate(); rename != nil {
+		var names []string
+		for name := range prefixedNames(m.sources, rename) {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+		_, _ = fmt.Fprintf(h, "%s\n", strings.Join(names, ","))
+	}
+
+	for _, src := range m.sources {
+		_, _ = fmt.Fprintf(h, "%s\n%t\n%d\n", filepath.Base(src.filename), src.test, len(src.data))
+		_, _ = h.Write(src.data)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// reuseOutput loads the files generated for m by an earlier run into
+// m.Contents. It reports false if any of them can't be read.
+func (c *Combiner) reuseOutput(m *MainPackage) bool {
+	contents := make(map[string][]byte)
+
+	for _, src := range m.sources {
+		filename := src.filename
+		if c.singleFile && mergeable(src) {
+			filename = mergedName(m)
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(m.OutputDir, filepath.Base(filename)))
+		if err != nil {
+			return false
+		}
+
+		contents[filename] = data
+
+		if c.standaloneTag != "" {
+			data, err := ioutil.ReadFile(filepath.Join(m.OutputDir, filepath.Base(standaloneName(src))))
+			if err != nil {
+				return false
+			}
+
+			contents[standaloneName(src)] = data
+		}
+	}
+
+	m.Contents = contents
+
+	return true
+}
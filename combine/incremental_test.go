@@ -0,0 +1,127 @@
+package combine
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIncremental(t *testing.T) {
+	tests := []struct {
+		name      string
+		change    map[string]string
+		options   []Option
+		rewritten []string
+		kept      []string
+	}{
+		{
+			name: "unchanged",
+			kept: []string{"cmd/server", "cmd/worker"},
+		},
+		{
+			name:      "changed source",
+			change:    map[string]string{"cmd/server/main.go": mainFile("server v2")},
+			rewritten: []string{"cmd/server"},
+			kept:      []string{"cmd/worker"},
+		},
+		{
+			name:      "changed option",
+			options:   []Option{WithEntrypointName("CombinedMain")},
+			rewritten: []string{"cmd/server", "cmd/worker"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go": mainFile("server"),
+				"cmd/worker/main.go": mainFile("worker"),
+			})
+
+			c := collected(t, dir, WithIncremental(true))
+			if err := c.Write(); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := os.Stat(filepath.Join(c.outputDir, stateName)); err != nil {
+				t.Fatalf("no state file was written: %v", err)
+			}
+
+			// backdate the output so a rewrite is visible in its modification time
+			old := time.Now().Add(-time.Hour).Truncate(time.Second)
+			for _, name := range []string{"cmd_server/main.go", "cmd_worker/main.go"} {
+				if err := os.Chtimes(filepath.Join(c.outputDir, name), old, old); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			writeFiles(t, dir, tt.change)
+
+			var logs bytes.Buffer
+
+			options := append([]Option{WithIncremental(true), WithLogger(log.New(&logs, "", 0), 1)}, tt.options...)
+
+			c = collected(t, dir, options...)
+			out := buildBinary(t, c)
+
+			for _, key := range tt.rewritten {
+				if strings.Contains(logs.String(), "skipping "+key+": unchanged") {
+					t.Fatalf("%s was skipped:\n%s", key, logs.String())
+				}
+
+				info, err := os.Stat(filepath.Join(c.outputDir, c.packages[key].PackageName, "main.go"))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if info.ModTime().Equal(old) {
+					t.Fatalf("%s was not rewritten", key)
+				}
+			}
+
+			for _, key := range tt.kept {
+				if !strings.Contains(logs.String(), "skipping "+key+": unchanged\n") {
+					t.Fatalf("%s was not skipped:\n%s", key, logs.String())
+				}
+
+				info, err := os.Stat(filepath.Join(c.outputDir, c.packages[key].PackageName, "main.go"))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !info.ModTime().Equal(old) {
+					t.Fatalf("%s was rewritten", key)
+				}
+			}
+
+			want := "server\n"
+			if tt.change != nil {
+				want = "server v2\n"
+			}
+
+			if got, code := runBinary(t, out, "server"); code != 0 || got != want {
+				t.Fatalf("expected server to print %q, got %q and exit code %d", want, got, code)
+			}
+
+			if got, code := runBinary(t, out, "worker"); code != 0 || got != "worker\n" {
+				t.Fatalf("expected worker to print its name, got %q and exit code %d", got, code)
+			}
+
+			data, err := ioutil.ReadFile(filepath.Join(c.outputDir, stateName))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, key := range []string{"cmd/server", "cmd/worker"} {
+				if !bytes.Contains(data, []byte(`"`+key+`"`)) {
+					t.Fatalf("%s is missing from the state file:\n%s", key, data)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package combine
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const installScriptName = "install.sh"
+
+// installScript generates a shell script that creates one symlink per
+// command, pointing at the combined binary, in the directory passed as its
+// only argument. The binary is expected to already be installed there under
+// the name of the output directory, which is what go build produces.
+// Symlinks are named with the command prefix, if any.
+func (c *Combiner) installScript(outputs []*MainPackage) []byte {
+	binary := filepath.Base(c.outputDir)
+
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("#!/bin/sh\n# " + generatedNotice + "\n\n")
+	_, _ = fmt.Fprintf(&buf, "set -e\n\nbindir=\"${1:?usage: $0 <directory containing %s>}\"\ncd \"$bindir\"\n\n", binary)
+
+	for _, m := range outputs {
+		_, _ = fmt.Fprintf(&buf, "ln -sf %s %s\n", shellQuote(binary), shellQuote(c.commandPrefix+m.Command))
+	}
+
+	return buf.Bytes()
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,202 @@
+package combine
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestInstallScript(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		symlinks []string
+	}{
+		{
+			name:     "plain",
+			symlinks: []string{"server", "worker"},
+		},
+		{
+			name:     "prefix",
+			opts:     []Option{WithCommandPrefix("myorg-")},
+			symlinks: []string{"myorg-server", "myorg-worker"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go": mainFile("server"),
+				"cmd/worker/main.go": mainFile("worker"),
+			})
+
+			c := collected(t, dir, append(tt.opts, WithEmitInstallScript(true))...)
+			if err := c.Write(); err != nil {
+				t.Fatal(err)
+			}
+
+			script := filepath.Join(c.outputDir, installScriptName)
+
+			data, err := ioutil.ReadFile(script)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var links []string
+
+			for _, line := range strings.Split(string(data), "\n") {
+				if strings.HasPrefix(line, "ln ") {
+					links = append(links, line)
+				}
+			}
+
+			if len(links) != len(tt.symlinks) {
+				t.Fatalf("expected one ln line per command, got:\n%s", data)
+			}
+
+			sh, err := exec.LookPath("sh")
+			if err != nil {
+				t.Skip("sh not found")
+			}
+
+			bindir := t.TempDir()
+
+			if out, err := exec.Command(sh, script, bindir).CombinedOutput(); err != nil {
+				t.Fatalf("install script failed: %s\n%s", err, out)
+			}
+
+			entries, err := os.ReadDir(bindir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+
+			for _, entry := range entries {
+				target, err := os.Readlink(filepath.Join(bindir, entry.Name()))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if target != "combined" {
+					t.Errorf("expected %s to link to combined, got %s", entry.Name(), target)
+				}
+
+				got = append(got, entry.Name())
+			}
+
+			sort.Strings(got)
+
+			if !reflect.DeepEqual(got, tt.symlinks) {
+				t.Fatalf("expected symlinks %v, got %v", tt.symlinks, got)
+			}
+		})
+	}
+}
+
+func TestOutputModes(t *testing.T) {
+	// the umask applies to every mode below
+	probe := filepath.Join(t.TempDir(), "probe")
+	if err := ioutil.WriteFile(probe, nil, 0o777); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(probe)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	umask := 0o777 &^ info.Mode().Perm()
+
+	tests := []struct {
+		name string
+		opts []Option
+		// existing files are written before Write
+		existing   map[string]string
+		dirMode    os.FileMode
+		fileMode   os.FileMode
+		scriptMode os.FileMode
+	}{
+		{name: "defaults", dirMode: 0o755, fileMode: 0o644, scriptMode: 0o755},
+		{
+			name:       "private",
+			opts:       []Option{WithDirMode(0o700), WithFileMode(0o600)},
+			dirMode:    0o700,
+			fileMode:   0o600,
+			scriptMode: 0o700,
+		},
+		{
+			name:       "group readable",
+			opts:       []Option{WithDirMode(0o750), WithFileMode(0o640)},
+			dirMode:    0o750,
+			fileMode:   0o640,
+			scriptMode: 0o750,
+		},
+		{
+			name:       "existing script",
+			existing:   map[string]string{"cmd/combined/" + installScriptName: "#!/bin/sh\n# " + generatedNotice + "\n"},
+			dirMode:    0o755,
+			fileMode:   0o644,
+			scriptMode: 0o755,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{"cmd/server/main.go": mainFile("server")})
+			writeFiles(t, dir, tt.existing)
+
+			c := collected(t, dir, append(tt.opts, WithEmitInstallScript(true))...)
+			if err := c.Write(); err != nil {
+				t.Fatal(err)
+			}
+
+			modes := map[string]os.FileMode{
+				"cmd_server":         tt.dirMode | os.ModeDir,
+				"cmd_server/main.go": tt.fileMode,
+				"main.go":            tt.fileMode,
+				installScriptName:    tt.scriptMode,
+			}
+
+			for name, want := range modes {
+				info, err := os.Stat(filepath.Join(c.outputDir, filepath.FromSlash(name)))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if want &^= umask; info.Mode() != want {
+					t.Fatalf("expected %s to have mode %s, got %s", name, want, info.Mode())
+				}
+			}
+
+			script := filepath.Join(c.outputDir, installScriptName)
+			bindir := t.TempDir()
+
+			if err := os.Symlink(buildBinary(t, c), filepath.Join(bindir, "combined")); err != nil {
+				t.Fatal(err)
+			}
+
+			// the script runs without sh
+			if out, err := exec.Command(script, bindir).CombinedOutput(); err != nil {
+				t.Fatalf("failed to run %s: %s\n%s", script, err, out)
+			}
+
+			if out, code := runBinary(t, filepath.Join(bindir, "server"), ""); code != 0 || out != "server\n" {
+				t.Fatalf("expected server to print its name, got %q and exit code %d", out, code)
+			}
+		})
+	}
+
+	t.Run("invalid mode", func(t *testing.T) {
+		dir := newModule(t, map[string]string{"cmd/server/main.go": mainFile("server")})
+
+		if _, err := New(dir, "cmd/combined", WithFileMode(os.ModeSetuid|0o644)); err == nil || !strings.Contains(err.Error(), "must only have permission bits") {
+			t.Fatalf("expected a mode with more than permission bits to be rejected, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package combine
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+)
+
+// declStart returns the position of decl including its doc comment.
+func declStart(decl ast.Decl) token.Pos {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		if d.Doc != nil {
+			return d.Doc.Pos()
+		}
+	case *ast.GenDecl:
+		if d.Doc != nil {
+			return d.Doc.Pos()
+		}
+	}
+
+	return decl.Pos()
+}
+
+func isImportDecl(decl ast.Decl) bool {
+	gd, ok := decl.(*ast.GenDecl)
+	return ok && gd.Tok == token.IMPORT
+}
+
+// declLines returns the line in the original source of every top-level
+// declaration of src other than imports. Transformation never adds or
+// removes these declarations, so they match the rendered file in order.
+func declLines(src *sourceFile) []int {
+	var lines []int
+
+	for _, decl := range src.file.Decls {
+		if !isImportDecl(decl) {
+			lines = append(lines, src.fset.Position(declStart(decl)).Line)
+		}
+	}
+
+	return lines
+}
+
+// addLineDirectives inserts a //line directive before every top-level
+// declaration other than imports in data, the rendered source of filename,
+// pointing to target at the matching entry of lines. Positions inside a
+// declaration are then reported relative to its original line.
+func addLineDirectives(filename string, target string, lines []int, data []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+
+	f, err := parser.ParseFile(fset, filename, data, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse new code for %s %w", filename, err)
+	}
+
+	var offsets []int
+
+	for _, decl := range f.Decls {
+		if !isImportDecl(decl) {
+			offsets = append(offsets, fset.Position(declStart(decl)).Offset)
+		}
+	}
+
+	if len(offsets) != len(lines) {
+		return nil, fmt.Errorf("failed to add line directives to %s: found %d declarations, want %d", filename, len(offsets), len(lines))
+	}
+
+	var buf bytes.Buffer
+
+	last := 0
+
+	for i, offset := range offsets {
+		// declarations always start a line once formatted
+		_, _ = buf.Write(data[last:offset])
+		_, _ = fmt.Fprintf(&buf, "//line %s:%d\n", target, lines[i])
+
+		last = offset
+	}
+
+	_, _ = buf.Write(data[last:])
+
+	return buf.Bytes(), nil
+}
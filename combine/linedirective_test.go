@@ -0,0 +1,79 @@
+package combine
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// panicking is a command that panics on line 8 of main.go, called from
+// line 12.
+const panicking = `package main
+
+import "fmt"
+
+// greet panics.
+func greet() {
+	fmt.Println("hello")
+	panic("boom")
+}
+
+func main() {
+	greet()
+}
+`
+
+func TestLineDirectives(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		lines  []int
+	}{
+		{name: "plain", lines: []int{5, 11}},
+		{name: "build constraint", prefix: "//go:build !nope\n\n", lines: []int{7, 13}},
+		{name: "package doc", prefix: "// Package main panics.\n", lines: []int{6, 12}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{"cmd/server/main.go": tt.prefix + panicking})
+
+			c := collected(t, dir, WithLineDirectives(true))
+
+			files, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data := string(files["cmd_server/main.go"])
+
+			for _, line := range tt.lines {
+				if want := "//line ../../server/main.go:" + strconv.Itoa(line) + "\n"; !strings.Contains(data, want) {
+					t.Fatalf("expected %q in the output:\n%s", want, data)
+				}
+			}
+
+			out, code := runBinary(t, buildBinary(t, c), "server")
+			if code != 2 {
+				t.Fatalf("expected server to panic, got exit code %d: %s", code, out)
+			}
+
+			// the panic is reported at the lines of the original source
+			source := filepath.Join(dir, "cmd", "server", "main.go")
+			for _, line := range []int{tt.lines[0] + 3, tt.lines[1] + 1} {
+				if want := source + ":" + strconv.Itoa(line); !strings.Contains(out, want) {
+					t.Fatalf("expected the stack trace to contain %s:\n%s", want, out)
+				}
+			}
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		dir := newModule(t, map[string]string{"cmd/server/main.go": panicking})
+
+		if files := generate(t, dir); strings.Contains(string(files["cmd_server/main.go"]), "//line ") {
+			t.Fatalf("unexpected line directive:\n%s", files["cmd_server/main.go"])
+		}
+	})
+}
@@ -0,0 +1,41 @@
+package combine
+
+// ManifestSchemaVersion is the version of the Manifest structure. It is
+// incremented whenever fields are changed or removed.
+const ManifestSchemaVersion = 1
+
+// Manifest describes the collected commands for use by other tools.
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	Commands      []ManifestCommand `json:"commands"`
+}
+
+// ManifestCommand describes a single collected command.
+type ManifestCommand struct {
+	Command     string `json:"command"`
+	SourceDir   string `json:"sourceDir"`
+	Module      string `json:"module"`
+	PackageName string `json:"packageName"`
+	ImportPath  string `json:"importPath"`
+}
+
+// Manifest returns a description of the collected commands, in dispatcher
+// order.
+func (c *Combiner) Manifest() *Manifest {
+	manifest := &Manifest{
+		SchemaVersion: ManifestSchemaVersion,
+		Commands:      []ManifestCommand{},
+	}
+
+	for _, m := range c.sortedPackages() {
+		manifest.Commands = append(manifest.Commands, ManifestCommand{
+			Command:     m.Command,
+			SourceDir:   m.SourceDir,
+			Module:      m.Module,
+			PackageName: m.PackageName,
+			ImportPath:  m.ImportPath,
+		})
+	}
+
+	return manifest
+}
@@ -0,0 +1,51 @@
+package combine
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	dir := newModule(t, commandTree)
+
+	data, err := json.Marshal(collected(t, dir).Manifest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var raw struct {
+		SchemaVersion int                      `json:"schemaVersion"`
+		Commands      []map[string]interface{} `json:"commands"`
+	}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+
+	if raw.SchemaVersion != ManifestSchemaVersion {
+		t.Fatalf("expected schema version %d, got %d", ManifestSchemaVersion, raw.SchemaVersion)
+	}
+
+	var commands []string
+	for _, command := range raw.Commands {
+		commands = append(commands, command["command"].(string))
+	}
+
+	// in dispatcher order, by import path
+	if want := []string{"server", "zworker", "alpha"}; !reflect.DeepEqual(commands, want) {
+		t.Fatalf("expected commands %v, got %v", want, commands)
+	}
+
+	want := map[string]interface{}{
+		"command":     "alpha",
+		"sourceDir":   "tools/alpha",
+		"module":      testModule,
+		"packageName": "tools_alpha",
+		"importPath":  testModule + "/cmd/combined/tools_alpha",
+	}
+
+	if got := raw.Commands[2]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected entry %v, got %v", want, got)
+	}
+}
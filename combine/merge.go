@@ -0,0 +1,242 @@
+package combine
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// mergeable reports whether src can be merged into the single file of its
+// package: its build constraints, test status and cgo preamble all apply to
+// the file as a whole, so files with any of them are kept separate.
+func mergeable(src *sourceFile) bool {
+	return !src.test && len(src.constraints) == 0 && fileNameConstraint(src.filename) == nil && !importsC(src.file)
+}
+
+func importsC(f *ast.File) bool {
+	for _, spec := range f.Imports {
+		if importPath(spec) == "C" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mergedName is the Contents key of the single file of m.
+func mergedName(m *MainPackage) string {
+	return filepath.Join(filepath.Dir(m.sources[0].filename), m.PackageName+".go")
+}
+
+// mergePackage replaces the transformed files of m that are mergeable with
+// one file named after the package.
+func mergePackage(m *MainPackage) error {
+	if err := checkCollisions(m.sources); err != nil {
+		return err
+	}
+
+	var files [][]byte
+
+	for _, src := range m.sources {
+		if !mergeable(src) {
+			continue
+		}
+
+		files = append(files, m.Contents[src.filename])
+		delete(m.Contents, src.filename)
+	}
+
+	if len(files) == 0 {
+		return nil
+	}
+
+	filename := mergedName(m)
+
+	data, err := mergeFiles(filename, files)
+	if err != nil {
+		return err
+	}
+
+	m.Contents[filename] = data
+
+	return nil
+}
+
+// checkCollisions reports top-level declarations with the same name in
+// the mergeable sources, which would be redeclared in the merged file.
+func checkCollisions(sources []*sourceFile) error {
+	positions := make(map[string][]string)
+
+	var names []string
+
+	declare := func(src *sourceFile, name string, pos token.Pos) {
+		if name == "_" || name == "init" {
+			return
+		}
+
+		if _, ok := positions[name]; !ok {
+			names = append(names, name)
+		}
+
+		positions[name] = append(positions[name], src.fset.Position(pos).String())
+	}
+
+	for _, src := range sources {
+		if !mergeable(src) {
+			continue
+		}
+
+		for _, decl := range src.file.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				name := d.Name.Name
+				if d.Recv != nil && len(d.Recv.List) == 1 {
+					// methods on T and *T share a namespace
+					recv := d.Recv.List[0].Type
+					if star, ok := recv.(*ast.StarExpr); ok {
+						recv = star.X
+					}
+
+					name = types.ExprString(recv) + "." + name
+				}
+
+				declare(src, name, d.Name.Pos())
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch spec := spec.(type) {
+					case *ast.ValueSpec:
+						for _, ident := range spec.Names {
+							declare(src, ident.Name, ident.Pos())
+						}
+					case *ast.TypeSpec:
+						declare(src, spec.Name.Name, spec.Name.Pos())
+					}
+				}
+			}
+		}
+	}
+
+	var collisions []string
+
+	for _, name := range names {
+		if len(positions[name]) > 1 {
+			collisions = append(collisions, fmt.Sprintf("%s declared at %s", name, strings.Join(positions[name], " and ")))
+		}
+	}
+
+	if len(collisions) > 0 {
+		return fmt.Errorf("can't merge files with colliding top-level declarations: %s", strings.Join(collisions, "; "))
+	}
+
+	return nil
+}
+
+// mergeFiles merges the rendered files of a package into one, combining
+// their imports. The first package doc comment found is kept.
+func mergeFiles(filename string, files [][]byte) ([]byte, error) {
+	var (
+		doc         string
+		packageName string
+		imports     []string
+		body        bytes.Buffer
+	)
+
+	// paths maps the name each import is referred to by to its path
+	paths := make(map[string]string)
+	seen := make(map[string]bool)
+
+	for _, data := range files {
+		fset := token.NewFileSet()
+
+		f, err := parser.ParseFile(fset, filename, data, parser.ParseComments)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse new code for %s %w", filename, err)
+		}
+
+		offset := func(pos token.Pos) int {
+			return fset.Position(pos).Offset
+		}
+
+		packageName = f.Name.Name
+
+		if doc == "" && f.Doc != nil {
+			doc = string(data[offset(f.Doc.Pos()):offset(f.Doc.End())])
+		}
+
+		end := offset(f.Name.End())
+
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.IMPORT {
+				break
+			}
+
+			end = offset(gd.End())
+
+			for _, spec := range gd.Specs {
+				is := spec.(*ast.ImportSpec)
+				p := importPath(is)
+				name := importName(is)
+
+				key := name + " " + p
+				if seen[key] {
+					continue
+				}
+
+				seen[key] = true
+
+				if other, ok := paths[name]; ok && name != "_" && name != "." && other != p {
+					return nil, fmt.Errorf("can't merge the files of %s into %s: %s refers to both %s and %s", packageName, filename, name, other, p)
+				}
+
+				paths[name] = p
+
+				if is.Name != nil {
+					imports = append(imports, is.Name.Name+" "+strconv.Quote(p))
+				} else {
+					imports = append(imports, strconv.Quote(p))
+				}
+			}
+		}
+
+		_, _ = body.Write(data[end:])
+		_, _ = body.WriteString("\n")
+	}
+
+	var buf bytes.Buffer
+
+	if doc != "" {
+		_, _ = buf.WriteString(doc + "\n")
+	}
+
+	_, _ = fmt.Fprintf(&buf, "package %s\n\n", packageName)
+
+	switch len(imports) {
+	case 0:
+	case 1:
+		_, _ = fmt.Fprintf(&buf, "import %s\n\n", imports[0])
+	default:
+		_, _ = fmt.Fprintf(&buf, "import (\n\t%s\n)\n\n", strings.Join(imports, "\n\t"))
+	}
+
+	_, _ = buf.Write(body.Bytes())
+
+	data, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, &FormatError{File: filename, Source: buf.Bytes(), Err: err}
+	}
+
+	data, err = groupImports(filename, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return addGeneratedHeader(filename, data)
+}
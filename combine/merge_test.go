@@ -0,0 +1,188 @@
+package combine
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSingleFile(t *testing.T) {
+	main := "package main\n\nimport (\n\t\"fmt\"\n\t\"os\"\n)\n\nfunc main() {\n\tfmt.Println(greeting(os.Args[0] != \"\"))\n}\n"
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  []string
+		out   string
+		err   string
+	}{
+		{
+			name: "duplicate imports",
+			files: map[string]string{
+				"cmd/server/main.go":   main,
+				"cmd/server/helper.go": "package main\n\nimport (\n\t\"fmt\"\n\t\"strings\"\n)\n\nfunc greeting(ok bool) string {\n\treturn strings.ToUpper(fmt.Sprint(ok))\n}\n",
+			},
+			want: []string{"cmd_server/cmd_server.go"},
+			out:  "TRUE\n",
+		},
+		{
+			name: "renamed import",
+			files: map[string]string{
+				"cmd/server/main.go":   main,
+				"cmd/server/helper.go": "package main\n\nimport (\n\tf \"fmt\"\n)\n\nfunc greeting(ok bool) string {\n\treturn f.Sprint(ok)\n}\n",
+			},
+			want: []string{"cmd_server/cmd_server.go"},
+			out:  "true\n",
+		},
+		{
+			name: "constrained file",
+			files: map[string]string{
+				"cmd/server/main.go":         main,
+				"cmd/server/helper_linux.go": "package main\n\nfunc greeting(ok bool) bool {\n\treturn ok\n}\n",
+				"cmd/server/helper_other.go": "//go:build !linux\n\npackage main\n\nfunc greeting(ok bool) bool {\n\treturn ok\n}\n",
+			},
+			want: []string{"cmd_server/cmd_server.go", "cmd_server/helper_linux.go", "cmd_server/helper_other.go"},
+			out:  "true\n",
+		},
+		{
+			name: "colliding declarations",
+			files: map[string]string{
+				"cmd/server/main.go":   main + "\nvar name string\n\nfunc greeting(bool) string { return name }\n",
+				"cmd/server/helper.go": "package main\n\nvar name = \"helper\"\n",
+			},
+			err: "can't merge files with colliding top-level declarations: name declared at ",
+		},
+		{
+			name: "colliding methods",
+			files: map[string]string{
+				"cmd/server/main.go":   main + "\ntype t struct{}\n\nfunc (t) String() string { return \"\" }\n\nfunc greeting(bool) string { return \"\" }\n",
+				"cmd/server/helper.go": "package main\n\nfunc (*t) String() string { return \"\" }\n",
+			},
+			err: "t.String declared at ",
+		},
+		{
+			name: "conflicting imports",
+			files: map[string]string{
+				"cmd/server/main.go":   main + "\nfunc greeting(bool) string { return \"\" }\n",
+				"cmd/server/helper.go": "package main\n\nimport fmt \"strings\"\n\nvar _ = fmt.ToUpper\n",
+			},
+			err: "fmt refers to both strings and fmt",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, tt.files)
+
+			c := newCombiner(t, dir, WithSingleFile(true))
+
+			err := c.Collect()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			files, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+
+			for name := range files {
+				if strings.HasPrefix(name, "cmd_server/") {
+					got = append(got, name)
+				}
+			}
+
+			sort.Strings(got)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected files %q, got %q", tt.want, got)
+			}
+
+			merged := string(files["cmd_server/cmd_server.go"])
+			if n := strings.Count(merged, "\"fmt\"\n"); n != 1 {
+				t.Fatalf("expected fmt to be imported once, got %d imports:\n%s", n, merged)
+			}
+
+			if out, code := runBinary(t, buildBinary(t, c), "server"); code != 0 || out != tt.out {
+				t.Fatalf("expected server to print %q, got %q and exit code %d", tt.out, out, code)
+			}
+		})
+	}
+}
+
+func TestMergeCollisions(t *testing.T) {
+	tests := []struct {
+		name   string
+		helper string
+		err    string
+		// build is set if the command itself builds
+		build bool
+	}{
+		{
+			name:   "var",
+			helper: "package main\n\nimport \"log\"\n\nvar logger = log.Default()\n",
+			err:    "logger declared at %[1]s/helper.go:5:5 and %[1]s/main.go:5:5",
+		},
+		{
+			name:   "const",
+			helper: "package main\n\nconst logger = \"helper\"\n",
+			err:    "logger declared at %[1]s/helper.go:3:7 and %[1]s/main.go:5:5",
+		},
+		{
+			name:   "type",
+			helper: "package main\n\ntype logger struct{}\n",
+			err:    "logger declared at %[1]s/helper.go:3:6 and %[1]s/main.go:5:5",
+		},
+		{
+			name:   "blank and init",
+			helper: "package main\n\nvar _ = 1\n\nfunc init() {}\n",
+			build:  true,
+		},
+		{
+			name:   "constrained file",
+			helper: "//go:build linux\n\npackage main\n\nvar logger = 1\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go":   "package main\n\nimport \"log\"\n\nvar logger = log.New(log.Writer(), \"\", 0)\n\nfunc init() {}\n\nvar _ = 0\n\nfunc main() {\n\tlogger.Print(\"server\")\n}\n",
+				"cmd/server/helper.go": tt.helper,
+			})
+
+			c := newCombiner(t, dir, WithSingleFile(true))
+
+			err := c.Collect()
+			if tt.err != "" {
+				want := "can't merge files with colliding top-level declarations: " + fmt.Sprintf(tt.err, filepath.Join(dir, "cmd", "server"))
+				if err == nil || err.Error() != want {
+					t.Fatalf("expected error %q, got %v", want, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.build {
+				buildOutput(t, c)
+			}
+		})
+	}
+}
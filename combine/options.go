@@ -0,0 +1,614 @@
+package combine
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Option configures a Combiner.
+type Option func(*Combiner)
+
+// WithInputs collects commands from additional directories, each with its
+// own go.mod, besides the service directory. The generated code imports
+// packages from those modules, so the output module must require them; see
+// WithEmitGoMod. Commands importing internal packages of their own module
+// can only be combined from the service directory.
+func WithInputs(dirs ...string) Option {
+	return func(c *Combiner) {
+		c.extraDirs = append(c.extraDirs, dirs...)
+	}
+}
+
+// WithInclude limits collection to the given directories, relative to the
+// service directory.
+func WithInclude(dirs ...string) Option {
+	return func(c *Combiner) {
+		c.include = append(c.include, dirs...)
+	}
+}
+
+// WithAllowEmptyInclude allows directories passed to WithInclude to contain
+// no main packages. By default Collect fails on such directories.
+func WithAllowEmptyInclude(allow bool) Option {
+	return func(c *Combiner) {
+		c.allowEmptyInclude = allow
+	}
+}
+
+// WithAllowEmpty lets Collect succeed without finding any main package, in
+// which case the dispatcher has no commands. By default Collect fails.
+func WithAllowEmpty(allow bool) Option {
+	return func(c *Combiner) {
+		c.allowEmpty = allow
+	}
+}
+
+// WithIncludeTests also transforms the _test.go files of each main package
+// and writes them next to the generated package, so its tests, including
+// any TestMain, run against the renamed package. Directories that declare
+// package main only in test files are never combined.
+func WithIncludeTests(include bool) Option {
+	return func(c *Combiner) {
+		c.includeTests = include
+	}
+}
+
+// WithMarker only combines main packages with a //combiner:include comment
+// in one of their non-test files. Other main packages are skipped.
+func WithMarker(marker bool) Option {
+	return func(c *Combiner) {
+		c.marker = marker
+	}
+}
+
+// WithAlwaysIgnore adds directories that are never walked, like the built-in
+// .git, vendor, .idea and .github. A name without a slash, such as
+// node_modules, matches directories of that name at any depth; one with a
+// slash matches the path relative to each input directory. Unlike
+// WithExclude, they are skipped before any other filter applies.
+func WithAlwaysIgnore(dirs ...string) Option {
+	return func(c *Combiner) {
+		c.extraIgnore = append(c.extraIgnore, dirs...)
+	}
+}
+
+// WithCopyExtensions copies the files with these extensions, such as .sql
+// or .tmpl, in the directory of each command and below it verbatim into the
+// generated package, keeping their layout. Unlike embedded files, they are
+// copied whether or not the command embeds them.
+func WithCopyExtensions(exts ...string) Option {
+	return func(c *Combiner) {
+		c.extraCopyExtensions = append(c.extraCopyExtensions, exts...)
+	}
+}
+
+// WithExclude skips paths matching the given glob patterns. Exclusion takes
+// precedence over WithInclude.
+func WithExclude(patterns ...string) Option {
+	return func(c *Combiner) {
+		c.exclude = append(c.exclude, patterns...)
+	}
+}
+
+// WithAllowDuplicateCommands disambiguates commands that share a name by
+// their dotted source directory instead of failing. Names set with a
+// //combiner:command comment are never disambiguated.
+func WithAllowDuplicateCommands(allow bool) Option {
+	return func(c *Combiner) {
+		c.allowDuplicateCommands = allow
+	}
+}
+
+// WithCommandPrefix strips prefix from the binary name before the
+// dispatcher matches it, so a symlink named myorg-server runs server with
+// the prefix myorg-. It can't be used with DispatchSubcommand.
+func WithCommandPrefix(prefix string) Option {
+	return func(c *Combiner) {
+		c.commandPrefix = prefix
+	}
+}
+
+// WithGroupBy partitions the commands into several binaries, each with its
+// own dispatcher, by the element of their source directory at depth. With a
+// depth of 2, cmd/admin/users and cmd/admin/groups are dispatched from
+// admin/main.go in the output directory, and cmd/user/login from
+// user/main.go. Commands in shallower directories, such as cmd/server, are
+// dispatched from the output directory itself. The generated packages are
+// shared by all groups. 0, the default, puts every command in one binary.
+func WithGroupBy(depth int) Option {
+	return func(c *Combiner) {
+		c.groupBy = depth
+	}
+}
+
+// WithMaxDepth stops the walk of each input at directories depth levels
+// below it, so with a depth of 2 cmd/server is combined but not
+// cmd/server/tools/gen. 0, the default, walks every directory.
+func WithMaxDepth(depth int) Option {
+	return func(c *Combiner) {
+		c.maxDepth = depth
+	}
+}
+
+// WithDefaultCommand runs the command called name, instead of failing,
+// when the dispatcher is invoked as a name that matches no command, or in
+// DispatchSubcommand mode without one. In DispatchSubcommand mode it sees the
+// arguments as given. Collect fails if there is no such command. With
+// WithGroupBy only the dispatcher of the command's group falls back to it.
+func WithDefaultCommand(name string) Option {
+	return func(c *Combiner) {
+		c.defaultCommand = name
+	}
+}
+
+// WithDispatcherPackage generates the dispatcher as package name, with
+// func Run(args []string) int in place of main, so a binary of its own can
+// call it, e.g. with os.Exit(combined.Run(os.Args)). args are laid out like
+// os.Args, which Run sets for the command it runs, and the result is the
+// exit code of the dispatcher. Commands that call os.Exit still exit the
+// process unless WithTrapExit is set.
+func WithDispatcherPackage(name string) Option {
+	return func(c *Combiner) {
+		c.dispatcherPackage = name
+	}
+}
+
+// WithWrap runs the Go statements in prolog before, and those in epilog
+// after, every command the dispatcher runs, e.g. to start a tracer and flush
+// it. Either may be empty. They can use the packages added with
+// WithWrapImports, and name, the name the command was invoked as. The epilog
+// does not run if the command exits the process.
+func WithWrap(prolog string, epilog string) Option {
+	return func(c *Combiner) {
+		c.prolog = strings.TrimSpace(prolog)
+		c.epilog = strings.TrimSpace(epilog)
+	}
+}
+
+// WithWrapImports adds the packages at these import paths, such as log, to
+// the imports of the dispatcher for the statements of WithWrap. Packages the
+// dispatcher already imports are not added twice.
+func WithWrapImports(paths ...string) Option {
+	return func(c *Combiner) {
+		c.wrapImports = append(c.wrapImports, paths...)
+	}
+}
+
+// WithWrapper leaves func main of each command unrenamed and adds a
+// combined_main.go to its package, with an entrypoint that calls it. With
+// WithStandaloneTag(tag), a package that had main.go is generated as:
+//
+//	main.go             //go:build tag   renamed package, func main as is
+//	combined_main.go    //go:build tag   func MainFunction() { main() }
+//	standalone_main.go  //go:build !tag  the original package main
+//
+// so the combined build, with the tag, calls the original main through the
+// wrapper, and the same directory still builds as the original command
+// without it.
+func WithWrapper(wrapper bool) Option {
+	return func(c *Combiner) {
+		c.wrapper = wrapper
+	}
+}
+
+// WithPreserveArgv0 leaves os.Args[0] as the path of the combined binary in
+// DispatchSubcommand mode, instead of replacing it with the command name, for
+// commands that need to find their own executable. The command name is
+// still removed from the arguments.
+func WithPreserveArgv0(preserve bool) Option {
+	return func(c *Combiner) {
+		c.preserveArgv0 = preserve
+	}
+}
+
+// WithProgress logs how many files and main packages have been discovered
+// every interval while the inputs are walked and parsed, and once when they
+// are done, for trees large enough that this takes a while. See also
+// Combiner.Progress.
+func WithProgress(interval time.Duration) Option {
+	return func(c *Combiner) {
+		c.progressInterval = interval
+	}
+}
+
+// WithDirMode sets the permissions of the directories Write creates,
+// subject to the umask. The default is DefaultDirMode.
+func WithDirMode(mode os.FileMode) Option {
+	return func(c *Combiner) {
+		c.dirMode = mode
+	}
+}
+
+// WithFileMode sets the permissions of the files Write creates, subject to
+// the umask. The default is DefaultFileMode.
+func WithFileMode(mode os.FileMode) Option {
+	return func(c *Combiner) {
+		c.fileMode = mode
+	}
+}
+
+// WithForce lets Write replace files in the output directory that were not
+// generated by the combiner. Without it, Write fails before writing
+// anything.
+func WithForce(force bool) Option {
+	return func(c *Combiner) {
+		c.force = force
+	}
+}
+
+// WithFS reads the service directory from fsys instead of the operating
+// system's file system, e.g. an embed.FS or a fstest.MapFS. The service
+// directory passed to New still determines where output is written and how
+// paths are reported. Other inputs are always read from the operating
+// system.
+func WithFS(fsys fs.FS) Option {
+	return func(c *Combiner) {
+		c.fsys = fsys
+	}
+}
+
+// WithStandaloneTag keeps every generated package buildable as the
+// original command. The transformed files, the dispatcher and the
+// registration files are guarded by tag, and a standalone_ prefixed copy of
+// each original file, still package main, is guarded by !tag:
+//
+//	go build -tags tag ./cmd/combined             # the combined binary
+//	go build ./cmd/combined/cmd_server            # server on its own
+//
+// The standalone copies are not transformed, so options such as
+// WithVersionVar and WithTrapExit only apply to the combined build.
+func WithStandaloneTag(tag string) Option {
+	return func(c *Combiner) {
+		c.standaloneTag = tag
+	}
+}
+
+// WithTrimSuffixes strips these suffixes from the binary name, after .exe
+// and before the command prefix, before the dispatcher matches it. It can't
+// be used with DispatchSubcommand.
+func WithTrimSuffixes(suffixes ...string) Option {
+	return func(c *Combiner) {
+		c.trimSuffixes = append(c.trimSuffixes, suffixes...)
+	}
+}
+
+// WithLineDirectives adds //line directives before the top-level
+// declarations of transformed files, so compiler errors and panics refer to
+// the original source.
+func WithLineDirectives(lineDirectives bool) Option {
+	return func(c *Combiner) {
+		c.lineDirectives = lineDirectives
+	}
+}
+
+// WithGoBinary sets the go command run by Verify.
+func WithGoBinary(path string) Option {
+	return func(c *Combiner) {
+		c.goBinary = path
+	}
+}
+
+// WithKeepGoing skips files that fail to parse, along with the packages
+// they belong to, instead of failing Collect. The errors are available from
+// ParseErrors.
+func WithKeepGoing(keepGoing bool) Option {
+	return func(c *Combiner) {
+		c.keepGoing = keepGoing
+	}
+}
+
+// WithSkipCommands drops the commands with these names. A name may also be
+// the dotted source directory a duplicate command is renamed to, e.g.
+// foo.server, to drop only one of several commands with the same name.
+func WithSkipCommands(names ...string) Option {
+	return func(c *Combiner) {
+		if c.skipCommands == nil {
+			c.skipCommands = make(map[string]bool)
+		}
+
+		for _, name := range names {
+			c.skipCommands[name] = true
+		}
+	}
+}
+
+// WithEmitGoMod generates a go.mod in the output directory so the combined
+// output can be built as its own module.
+func WithEmitGoMod(emit bool) Option {
+	return func(c *Combiner) {
+		c.emitGoMod = emit
+	}
+}
+
+// WithDispatch sets how the generated dispatcher selects a command. The
+// default is DispatchArgv0.
+func WithDispatch(dispatch Dispatch) Option {
+	return func(c *Combiner) {
+		c.dispatch = dispatch
+	}
+}
+
+// WithEmitDockerfile generates a multi-stage Dockerfile in the output
+// directory that builds the combined binary with the service directory as
+// its context. Unless DispatchSubcommand is used, the final image has a
+// symlink for every command, made by the install script, which is then
+// always generated.
+func WithEmitDockerfile(emit bool) Option {
+	return func(c *Combiner) {
+		c.emitDockerfile = emit
+	}
+}
+
+// WithEmitInstallScript generates an install.sh in the output directory that
+// creates a symlink to the combined binary for every command.
+func WithEmitInstallScript(emit bool) Option {
+	return func(c *Combiner) {
+		c.emitInstallScript = emit
+	}
+}
+
+// WithPrefixIdentifiers prefixes the top-level declarations of each package,
+// other than main and init, with the generated package name, and rewrites
+// references to them in the package's files. It relies on syntactic
+// resolution, so a struct literal key that shares its name with a top-level
+// declaration in the same file is renamed as well.
+func WithPrefixIdentifiers(prefix bool) Option {
+	return func(c *Combiner) {
+		c.prefixIdentifiers = prefix
+	}
+}
+
+// WithRenamePredicate prefixes only the top-level declarations rename
+// accepts, as WithPrefixIdentifiers does for all of them. It takes
+// precedence over WithPrefixIdentifiers. Without either, only main is
+// renamed.
+func WithRenamePredicate(rename RenamePredicate) Option {
+	return func(c *Combiner) {
+		c.rename = rename
+	}
+}
+
+// WithDeferInit renames init functions and calls them from the start of the
+// renamed main, so they only run for the command that is dispatched.
+func WithDeferInit(deferInit bool) Option {
+	return func(c *Combiner) {
+		c.deferInit = deferInit
+	}
+}
+
+// WithVersionVar initializes the top-level string variable called name in
+// every command from a generated version package, so a single linker flag
+// sets all of them. See VersionSymbol.
+func WithVersionVar(name string) Option {
+	return func(c *Combiner) {
+		c.versionVar = name
+	}
+}
+
+// WithEmitListCommand adds a reserved command to the dispatcher that prints
+// every command name: "--list" in subcommand mode, otherwise the output
+// directory name followed by "-list".
+func WithEmitListCommand(emit bool) Option {
+	return func(c *Combiner) {
+		c.emitListCommand = emit
+	}
+}
+
+// WithEmitCommands generates a commands package in the output directory,
+// see CommandsImportPath, whose Commands variable maps every command name
+// to its main function, so tests can run commands in-process with
+// commands.Commands["server"](). The functions take a context.Context with
+// WithContextEntrypoint. Commands that call os.Exit still exit the process
+// unless WithTrapExit is set, in which case they panic with an exit.Error.
+func WithEmitCommands(emit bool) Option {
+	return func(c *Combiner) {
+		c.emitCommands = emit
+	}
+}
+
+// WithUnknownExitCode sets the exit code of the dispatcher when invoked as an
+// unknown command. It must be between 1 and 125.
+func WithUnknownExitCode(code int) Option {
+	return func(c *Combiner) {
+		c.unknownExitCode = code
+	}
+}
+
+// WithPrune removes generated output left over from commands or source
+// files that no longer exist when writing.
+func WithPrune(prune bool) Option {
+	return func(c *Combiner) {
+		c.pruneStale = prune
+	}
+}
+
+// WithEntrypointName sets the name main functions are renamed to. It must be
+// an exported identifier that the commands do not already declare.
+func WithEntrypointName(name string) Option {
+	return func(c *Combiner) {
+		c.entrypointName = name
+	}
+}
+
+// WithTrapExit replaces calls to os.Exit in main functions with a panic that
+// the dispatcher recovers and turns back into an exit, so commands can be
+// run in process without ending it.
+func WithTrapExit(trap bool) Option {
+	return func(c *Combiner) {
+		c.trapExit = trap
+	}
+}
+
+// WithAppend adds the commands collected to those of the dispatcher an
+// earlier run wrote to the output directory, instead of replacing it, so
+// commands can be combined in stages. The packages of the earlier commands
+// are left as they are. A command that is collected again replaces its
+// earlier version, but a different command of the same name is a
+// *DuplicateCommandError. Appending requires DispatchArgv0 or
+// DispatchSubcommand, and can't be combined with WithGroupBy, WithPrune or
+// WithEmitGoMod.
+func WithAppend(appendCommands bool) Option {
+	return func(c *Combiner) {
+		c.appendCommands = appendCommands
+	}
+}
+
+// WithDispatcherFilename sets the name of the generated dispatcher file in
+// the output directory. It must end in .go. The default is
+// DefaultDispatcherFilename.
+func WithDispatcherFilename(name string) Option {
+	return func(c *Combiner) {
+		c.dispatcherFilename = name
+	}
+}
+
+// WithEmitCompletion writes a completion script for each shell to the
+// output directory, completing the first argument to a command name. It
+// requires DispatchSubcommand.
+func WithEmitCompletion(shells ...Shell) Option {
+	return func(c *Combiner) {
+		c.completions = shells
+	}
+}
+
+// WithRespectGitignore skips files and directories ignored by .gitignore
+// files in the input directories, and in their parents up to the root of
+// the git repository.
+func WithRespectGitignore(respect bool) Option {
+	return func(c *Combiner) {
+		c.respectGitignore = respect
+	}
+}
+
+// WithSingleFile merges the transformed files of each package into one file
+// named after the package, with their imports combined. Files with build
+// constraints, test files and files using cgo are kept separate. The
+// Contents key of the merged file is its name in the source directory,
+// which does not exist.
+func WithSingleFile(singleFile bool) Option {
+	return func(c *Combiner) {
+		c.singleFile = singleFile
+	}
+}
+
+// WithFollowSymlinks walks into symlinked directories. A symlink to a
+// directory that was already walked, such as a loop back to a parent, is
+// skipped. By default symlinked directories are skipped, while symlinked files
+// are always read.
+func WithFollowSymlinks(follow bool) Option {
+	return func(c *Combiner) {
+		c.followSymlinks = follow
+	}
+}
+
+// WithImportPrefix sets the import path of the output directory. By default
+// it is derived from the nearest go.mod above the output directory.
+func WithImportPrefix(prefix string) Option {
+	return func(c *Combiner) {
+		c.importPrefix = prefix
+	}
+}
+
+// WithContextEntrypoint makes the dispatcher create a context.Context that
+// is canceled on SIGINT or SIGTERM. Commands whose main function is declared
+// as func main(ctx context.Context) are called with it, other commands are
+// called without arguments.
+func WithContextEntrypoint(contextEntrypoint bool) Option {
+	return func(c *Combiner) {
+		c.contextEntrypoint = contextEntrypoint
+	}
+}
+
+// WithBuildTags skips source files whose build constraints can't be
+// satisfied with exactly the given tags set. Constraints on GOOS, GOARCH,
+// cgo, unix and Go versions are decided when the combined binary is built,
+// so only the other tags are evaluated. Without this option, or if tags is
+// nil, only files that no build includes without the ignore tag, such as
+// those with a //go:build ignore line, are skipped.
+func WithBuildTags(tags ...string) Option {
+	return func(c *Combiner) {
+		if tags == nil {
+			c.buildTags = nil
+			return
+		}
+
+		c.buildTags = make(map[string]bool)
+
+		for _, tag := range tags {
+			c.buildTags[tag] = true
+		}
+	}
+}
+
+// WithSimplify applies the simplifications of gofmt -s to every generated
+// file.
+func WithSimplify(simplify bool) Option {
+	return func(c *Combiner) {
+		c.simplify = simplify
+	}
+}
+
+// WithIncremental enables incremental mode. The hash of the inputs of each
+// package is recorded in the output directory, and packages whose inputs
+// are unchanged since the last run reuse their existing output instead of
+// being rewritten. Files whose contents did not change are not written.
+func WithIncremental(incremental bool) Option {
+	return func(c *Combiner) {
+		c.incremental = incremental
+	}
+}
+
+// WithDispatcherTemplate replaces the built-in dispatcher template with a
+// text/template. It is executed with the same data as the built-in one,
+// including .Header, .MainName and .Commands, each with .Name, .PackageName,
+// .ImportPath and .SourceImportPath, in the order set by WithSortBy, and
+// .Library, the package set with WithDispatcherPackage or "". The result
+// must be a Go file of package main, or of the .Library package if one is
+// set.
+func WithDispatcherTemplate(text string) Option {
+	return func(c *Combiner) {
+		c.dispatcherTemplateText = text
+	}
+}
+
+// WithPackageNameTemplate sets the text/template used to name generated
+// packages. It is executed with .Dir, the source directory, .Segments, its
+// elements, .Base, its last element, and .Module, the module path, and may
+// use the functions ident, join, replace and lower. A result starting with a
+// digit is prefixed with pkg, and must then be a valid identifier. See
+// DefaultPackageNameTemplate.
+func WithPackageNameTemplate(text string) Option {
+	return func(c *Combiner) {
+		c.packageNameText = text
+	}
+}
+
+// WithSortBy sets the order of the commands in the dispatcher, its usage
+// message and the other generated files that list them. The default is
+// SortByImportPath.
+func WithSortBy(sortBy SortBy) Option {
+	return func(c *Combiner) {
+		c.sortBy = sortBy
+	}
+}
+
+// WithPackageDoc sets what happens to package doc comments of renamed
+// files. The default is PackageDocRewrite.
+func WithPackageDoc(packageDoc PackageDoc) Option {
+	return func(c *Combiner) {
+		c.packageDoc = packageDoc
+	}
+}
+
+// WithLogger logs progress to logger. Warnings are always logged, verbosity
+// 1 reports skipped files and written output, 2 also reports every directory
+// and file considered.
+func WithLogger(logger *log.Logger, verbosity int) Option {
+	return func(c *Combiner) {
+		c.logger = logger
+		c.verbosity = verbosity
+	}
+}
@@ -0,0 +1,509 @@
+package combine
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// DefaultDirMode and DefaultFileMode are the permissions of the directories
+// and files Write creates unless configured otherwise. The install script is
+// also executable by everyone who can read it.
+const (
+	DefaultDirMode  os.FileMode = 0755
+	DefaultFileMode os.FileMode = 0644
+)
+
+// Generate returns the contents of every file Write would create, keyed by
+// slash separated path relative to the output directory. It does not touch
+// the filesystem.
+func (c *Combiner) Generate() (map[string][]byte, error) {
+	start := time.Now()
+	defer func() {
+		c.timings.Generate += time.Since(start)
+	}()
+
+	files := make(map[string][]byte)
+
+	outputs := c.sortedPackages()
+
+	// sources records the source file of each package file, so files with
+	// the same base name are reported rather than overwritten
+	sources := make(map[string]string)
+
+	for _, m := range outputs {
+		for _, file := range sortedNames(m.Contents) {
+			name := path.Join(m.PackageName, filepath.Base(file))
+			if other, ok := sources[name]; ok {
+				return nil, fmt.Errorf("%s and %s would both be written to %s", other, file, name)
+			}
+
+			sources[name] = file
+			files[name] = m.Contents[file]
+		}
+
+		for _, file := range sortedNames(m.Embedded) {
+			name := path.Join(m.PackageName, file)
+			if other, ok := sources[name]; ok {
+				return nil, fmt.Errorf("%s and embedded file %s would both be written to %s", other, file, name)
+			}
+
+			sources[name] = file
+			files[name] = m.Embedded[file]
+		}
+
+		for _, file := range sortedNames(m.Copied) {
+			// a file may be both embedded and copied
+			if _, ok := m.Embedded[file]; ok {
+				continue
+			}
+
+			name := path.Join(m.PackageName, file)
+			if other, ok := sources[name]; ok {
+				return nil, fmt.Errorf("%s and copied file %s would both be written to %s", other, file, name)
+			}
+
+			sources[name] = file
+			files[name] = m.Copied[file]
+		}
+
+		if c.wrapper {
+			name := path.Join(m.PackageName, wrapperName)
+			if other, ok := sources[name]; ok {
+				return nil, fmt.Errorf("%s conflicts with the generated %s", other, name)
+			}
+
+			files[name] = c.wrapperSource(m)
+		}
+
+		if c.dispatch == DispatchRegistry {
+			name := path.Join(m.PackageName, registerName)
+			if other, ok := sources[name]; ok {
+				return nil, fmt.Errorf("%s conflicts with the generated %s", other, name)
+			}
+
+			files[name] = c.registerSource(m)
+		}
+	}
+
+	// in append mode, the commands of the existing dispatcher are
+	// dispatched too, but their packages are left as they are
+	previous, err := c.previousCommands(outputs)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(previous) > 0 {
+		outputs = c.sortPackages(append(outputs, previous...))
+	}
+
+	// the summary counts the commands kept from the existing dispatcher
+	c.dispatched = len(outputs)
+
+	if c.versionVar != "" {
+		if m := c.findPackage(versionPackage); m != nil {
+			return nil, fmt.Errorf("package generated for %s conflicts with the generated %s package", m.SourceDir, versionPackage)
+		}
+
+		files[path.Join(versionPackage, "version.go")] = versionSource()
+	}
+
+	if c.trapExit {
+		if m := c.findPackage(exitPackage); m != nil {
+			return nil, fmt.Errorf("package generated for %s conflicts with the generated %s package", m.SourceDir, exitPackage)
+		}
+
+		files[path.Join(exitPackage, "exit.go")] = exitSource()
+	}
+
+	if c.dispatch == DispatchRegistry {
+		if m := c.findPackage(registryPackage); m != nil {
+			return nil, fmt.Errorf("package generated for %s conflicts with the generated %s package", m.SourceDir, registryPackage)
+		}
+
+		files[path.Join(registryPackage, "registry.go")] = registrySource(c.contextEntrypoint)
+	}
+
+	if c.emitCommands {
+		if m := c.findPackage(commandsPackage); m != nil {
+			return nil, fmt.Errorf("package generated for %s conflicts with the generated %s package", m.SourceDir, commandsPackage)
+		}
+
+		data, err := c.commandsSource(outputs)
+		if err != nil {
+			return nil, err
+		}
+
+		files[path.Join(commandsPackage, "commands.go")] = data
+	}
+
+	for _, g := range c.groups(outputs) {
+		name := c.dispatcherName(g)
+		if _, ok := files[name]; ok {
+			return nil, fmt.Errorf("dispatcher %s conflicts with a generated file of the same name", name)
+		}
+
+		if g.dir != "" {
+			for _, file := range sortedNames(files) {
+				if strings.HasPrefix(file, g.dir+"/") {
+					return nil, fmt.Errorf("dispatcher of group %s conflicts with the generated %s", g.dir, file)
+				}
+			}
+		}
+
+		data, err := c.dispatcher(c.groupBinary(g), g.commands)
+		if err != nil {
+			return nil, err
+		}
+
+		files[name] = data
+	}
+
+	for _, shell := range c.completions {
+		files[c.completionName(shell)] = c.completionScript(shell, outputs)
+	}
+
+	if c.emitDockerfile {
+		data, err := c.dockerfile(outputs)
+		if err != nil {
+			return nil, err
+		}
+
+		files[dockerfileName] = data
+	}
+
+	// the Dockerfile runs the install script
+	if c.emitInstallScript || c.emitDockerfile && c.dispatch != DispatchSubcommand {
+		files[installScriptName] = c.installScript(outputs)
+	}
+
+	if c.incremental {
+		data, err := c.stateFile()
+		if err != nil {
+			return nil, err
+		}
+
+		files[stateName] = data
+	}
+
+	if c.emitGoMod {
+		data, err := c.goMod()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate %s: %w", goModName, err)
+		}
+
+		files[goModName] = data
+	}
+
+	return files, nil
+}
+
+// DryRun prints the directories and files Write would create, and the
+// commands the dispatcher would contain, without changing the filesystem.
+func (c *Combiner) DryRun(w io.Writer) error {
+	files, err := c.Generate()
+	if err != nil {
+		return err
+	}
+
+	names := sortedNames(files)
+
+	dirs := make(map[string][]byte)
+	for _, name := range names {
+		dirs[filepath.Dir(filepath.Join(c.outputDir, filepath.FromSlash(name)))] = nil
+	}
+
+	for _, dir := range sortedNames(dirs) {
+		_, _ = fmt.Fprintf(w, "create directory %s\n", dir)
+	}
+
+	for _, name := range names {
+		_, _ = fmt.Fprintf(w, "write %s (%d bytes)\n", filepath.Join(c.outputDir, filepath.FromSlash(name)), len(files[name]))
+	}
+
+	for _, m := range c.sortedPackages() {
+		_, _ = fmt.Fprintf(w, "command %s\n", m.Command)
+	}
+
+	return nil
+}
+
+// Check returns the files Write would create or change, sorted, without
+// changing the filesystem. An empty result means the output directory is up
+// to date.
+func (c *Combiner) Check() ([]string, error) {
+	files, err := c.Generate()
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []string
+
+	for _, name := range sortedNames(files) {
+		filename := filepath.Join(c.outputDir, filepath.FromSlash(name))
+
+		existing, err := ioutil.ReadFile(filename)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		if err != nil || !bytes.Equal(existing, files[name]) {
+			changed = append(changed, filename)
+		}
+	}
+
+	return changed, nil
+}
+
+// Diff writes a unified diff of every file Write would create or change
+// against the output directory to w, and returns those files like Check.
+func (c *Combiner) Diff(w io.Writer) ([]string, error) {
+	files, err := c.Generate()
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []string
+
+	for _, name := range sortedNames(files) {
+		filename := filepath.Join(c.outputDir, filepath.FromSlash(name))
+		from := filename
+
+		existing, err := ioutil.ReadFile(filename)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return nil, err
+			}
+
+			from = os.DevNull
+		}
+
+		if err == nil && bytes.Equal(existing, files[name]) {
+			continue
+		}
+
+		changed = append(changed, filename)
+
+		err = difflib.WriteUnifiedDiff(w, difflib.UnifiedDiff{
+			A:        diffLines(existing),
+			B:        diffLines(files[name]),
+			FromFile: from,
+			ToFile:   filename,
+			Context:  3,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return changed, nil
+}
+
+// diffLines splits data into lines for a diff, each ending in a newline.
+// Unlike difflib.SplitLines, no empty line is added after the final newline.
+func diffLines(data []byte) []string {
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	} else {
+		lines[len(lines)-1] += "\n"
+	}
+
+	return lines
+}
+
+// Write writes the transformed packages and the dispatcher to the output
+// directory.
+func (c *Combiner) Write() error {
+	files, err := c.Generate()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	defer func() {
+		c.timings.Write += time.Since(start)
+	}()
+
+	c.summary = Summary{
+		Commands:  c.dispatched,
+		OutputDir: c.outputDir,
+	}
+
+	if !c.force {
+		if err := c.checkOverwrites(files); err != nil {
+			return err
+		}
+	}
+
+	if c.pruneStale {
+		if err := c.prune(files); err != nil {
+			return fmt.Errorf("failed to prune stale output: %w", err)
+		}
+	}
+
+	for _, name := range sortedNames(files) {
+		filename := filepath.Join(c.outputDir, filepath.FromSlash(name))
+
+		if c.incremental {
+			// leave unchanged files alone so their modification time is kept
+			existing, err := ioutil.ReadFile(filename)
+			if err == nil && bytes.Equal(existing, files[name]) {
+				c.logf(2, "unchanged %s", filename)
+				continue
+			}
+		}
+
+		if err := os.MkdirAll(filepath.Dir(filename), c.dirMode); err != nil {
+			return err
+		}
+
+		mode := c.fileMode
+		if name == installScriptName {
+			// executable by whoever may read it
+			mode |= (mode & 0444) >> 2
+		}
+
+		if err := ioutil.WriteFile(filename, files[name], mode); err != nil {
+			return err
+		}
+
+		// WriteFile keeps the mode of an existing file
+		if name == installScriptName {
+			if err := os.Chmod(filename, mode); err != nil {
+				return err
+			}
+		}
+
+		c.logf(1, "wrote %s", filename)
+
+		c.summary.Files++
+		c.summary.Bytes += int64(len(files[name]))
+	}
+
+	return nil
+}
+
+// checkOverwrites makes sure that none of files, which are marked as
+// generated, would replace an existing file that is not, such as a hand
+// written main.go. Files without the mark, such as embedded files, are not
+// checked.
+func (c *Combiner) checkOverwrites(files map[string][]byte) error {
+	var blocked []string
+
+	for _, name := range sortedNames(files) {
+		if !bytes.Contains(files[name], []byte(generatedNotice)) {
+			continue
+		}
+
+		filename := filepath.Join(c.outputDir, filepath.FromSlash(name))
+
+		existing, err := ioutil.ReadFile(filename)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return err
+		}
+
+		generated := bytes.Contains(existing, []byte(generatedNotice))
+		if strings.HasSuffix(name, ".go") {
+			generated = isGeneratedFile(filename)
+		}
+
+		if !generated {
+			blocked = append(blocked, filename)
+		}
+	}
+
+	if len(blocked) > 0 {
+		return fmt.Errorf("refusing to overwrite files not generated by main-combiner: %s", strings.Join(blocked, ", "))
+	}
+
+	return nil
+}
+
+// Summary describes the output of the last call to Write.
+type Summary struct {
+	// Commands is the number of combined commands, including those kept
+	// from an earlier dispatcher with WithAppend.
+	Commands int
+	// Files is the number of files written.
+	Files int
+	// Bytes is the total size of the files written.
+	Bytes int64
+	// OutputDir is the directory the files were written to.
+	OutputDir string
+}
+
+// Summary returns a description of what the last call to Write wrote.
+func (c *Combiner) Summary() Summary {
+	return c.summary
+}
+
+// SortBy selects the order of the commands in the dispatcher.
+type SortBy string
+
+const (
+	// SortByImportPath orders commands by the import path of their
+	// generated package.
+	SortByImportPath SortBy = "import"
+	// SortByCommand orders commands by name.
+	SortByCommand SortBy = "command"
+)
+
+// sortedPackages returns the collected packages in dispatcher order. Import
+// paths are unique, so the order never depends on map iteration.
+func (c *Combiner) sortedPackages() []*MainPackage {
+	var outputs []*MainPackage
+
+	for _, m := range c.packages {
+		outputs = append(outputs, m)
+	}
+
+	return c.sortPackages(outputs)
+}
+
+// sortPackages sorts outputs in dispatcher order and returns them.
+func (c *Combiner) sortPackages(outputs []*MainPackage) []*MainPackage {
+	sort.Slice(outputs, func(i, j int) bool {
+		if c.sortBy == SortByCommand && outputs[i].Command != outputs[j].Command {
+			return outputs[i].Command < outputs[j].Command
+		}
+
+		return outputs[i].ImportPath < outputs[j].ImportPath
+	})
+
+	return outputs
+}
+
+func sortedNames(files map[string][]byte) []string {
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func (c *Combiner) findPackage(packageName string) *MainPackage {
+	for _, m := range c.packages {
+		if m.PackageName == packageName {
+			return m
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,420 @@
+package combine
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// snapshot returns the path, mode, size and modification time of everything
+// below dir.
+func snapshot(t testing.TB, dir string) map[string]string {
+	t.Helper()
+
+	files := make(map[string]string)
+
+	err := filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		files[name] = fmt.Sprintf("%s %d %s", info.Mode(), info.Size(), info.ModTime())
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return files
+}
+
+func TestDryRun(t *testing.T) {
+	dir := newModule(t, map[string]string{
+		"cmd/server/main.go": mainFile("server"),
+		"cmd/worker/main.go": mainFile("worker"),
+	})
+
+	c := collected(t, dir, WithEmitInstallScript(true))
+
+	before := snapshot(t, dir)
+
+	var out bytes.Buffer
+	if err := c.DryRun(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	if after := snapshot(t, dir); !reflect.DeepEqual(before, after) {
+		t.Fatalf("dry run changed the filesystem: before %v, after %v", before, after)
+	}
+
+	outputDir := filepath.Join(dir, "cmd", "combined")
+
+	for _, want := range []string{
+		"create directory " + outputDir + "\n",
+		"create directory " + filepath.Join(outputDir, "cmd_server") + "\n",
+		"write " + filepath.Join(outputDir, "main.go") + " (",
+		"write " + filepath.Join(outputDir, installScriptName) + " (",
+		"command server\ncommand worker\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected the dry run to print %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestBaseNameCollision(t *testing.T) {
+	dir := newModule(t, map[string]string{"cmd/server/main.go": mainFile("server")})
+
+	c := collected(t, dir)
+
+	// two source directories mapped into one package
+	m := c.packages["cmd/server"]
+	m.Contents[filepath.Join(dir, "other", "main.go")] = m.Contents[filepath.Join(dir, "cmd", "server", "main.go")]
+
+	_, err := c.Generate()
+
+	want := filepath.Join(dir, "cmd", "server", "main.go") + " and " + filepath.Join(dir, "other", "main.go") + " would both be written to cmd_server/main.go"
+	if err == nil || err.Error() != want {
+		t.Fatalf("expected error %q, got %v", want, err)
+	}
+}
+
+func TestWriteDeterministic(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []Option
+	}{
+		{name: "default"},
+		{name: "registry", options: []Option{WithDispatch(DispatchRegistry)}},
+		{name: "subcommand", options: []Option{WithDispatch(DispatchSubcommand), WithEmitCommands(true), WithEmitCompletion(ShellBash, ShellZsh, ShellFish)}},
+		{name: "emitted files", options: []Option{WithEmitInstallScript(true), WithEmitDockerfile(true), WithIncremental(true)}},
+		{name: "sorted by command", options: []Option{WithSortBy(SortByCommand), WithSingleFile(true)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{"pkg/lib/lib.go": "package lib\n\n// Name is shared by the commands.\nconst Name = \"lib\"\n"}
+
+			for _, name := range []string{"zeta", "alpha", "mid", "beta"} {
+				files["cmd/"+name+"/main.go"] = mainFile(name)
+				files["cmd/"+name+"/flags.go"] = "package main\n\nimport (\n\t\"flag\"\n\n\t\"" + testModule + "/pkg/lib\"\n)\n\nvar verbose = flag.Bool(\"v\", false, lib.Name)\n"
+				files["cmd/"+name+"/util.go"] = "package main\n\nimport \"os\"\n\nvar pid = os.Getpid()\n"
+			}
+
+			dir := newModule(t, files)
+
+			var first map[string]string
+
+			for i := 0; i < 2; i++ {
+				c := collected(t, dir, tt.options...)
+				if err := c.Write(); err != nil {
+					t.Fatal(err)
+				}
+
+				tree := readTree(t, c.outputDir)
+				if first == nil {
+					first = tree
+
+					if err := os.RemoveAll(c.outputDir); err != nil {
+						t.Fatal(err)
+					}
+
+					continue
+				}
+
+				for name, data := range first {
+					if tree[name] != data {
+						t.Fatalf("%s differs between runs:\n%s\n%s", name, data, tree[name])
+					}
+				}
+
+				if len(tree) != len(first) {
+					t.Fatalf("expected %d files, got %d", len(first), len(tree))
+				}
+
+				goBuild(t, c.outputDir)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		change map[string]string
+		remove string
+		want   []string
+	}{
+		{name: "up to date"},
+		{
+			name:   "changed source",
+			change: map[string]string{"cmd/server/main.go": mainFile("server v2")},
+			want:   []string{"cmd_server/main.go"},
+		},
+		{
+			name:   "new command",
+			change: map[string]string{"cmd/worker/main.go": mainFile("worker")},
+			want:   []string{"cmd_worker/main.go", "main.go"},
+		},
+		{
+			name:   "missing output",
+			remove: "cmd_server/main.go",
+			want:   []string{"cmd_server/main.go"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{"cmd/server/main.go": mainFile("server")})
+
+			if err := collected(t, dir).Write(); err != nil {
+				t.Fatal(err)
+			}
+
+			writeFiles(t, dir, tt.change)
+
+			c := collected(t, dir)
+
+			if tt.remove != "" {
+				if err := os.Remove(filepath.Join(c.outputDir, tt.remove)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			before := snapshot(t, dir)
+
+			got, err := c.Check()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if after := snapshot(t, dir); !reflect.DeepEqual(before, after) {
+				t.Fatalf("check changed the filesystem: before %v, after %v", before, after)
+			}
+
+			var want []string
+			for _, name := range tt.want {
+				want = append(want, filepath.Join(c.outputDir, filepath.FromSlash(name)))
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("expected changed files %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestSummary(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []Option
+		rerun   bool
+		files   int
+	}{
+		{name: "default", files: 4},
+		{name: "install script", options: []Option{WithEmitInstallScript(true)}, files: 5},
+		{name: "registry", options: []Option{WithDispatch(DispatchRegistry)}, files: 7},
+		{name: "incremental rerun", options: []Option{WithIncremental(true)}, rerun: true, files: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go":   mainFile("server"),
+				"cmd/server/helper.go": "package main\n\nfunc helper() {}\n",
+				"cmd/worker/main.go":   mainFile("worker"),
+				"pkg/lib/lib.go":       "package lib\n",
+			})
+
+			if tt.rerun {
+				if err := collected(t, dir, tt.options...).Write(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			c := collected(t, dir, tt.options...)
+			if err := c.Write(); err != nil {
+				t.Fatal(err)
+			}
+
+			var size int64
+			if !tt.rerun {
+				for _, data := range readTree(t, c.outputDir) {
+					size += int64(len(data))
+				}
+			}
+
+			want := Summary{Commands: 2, Files: tt.files, Bytes: size, OutputDir: c.outputDir}
+			if got := c.Summary(); got != want {
+				t.Fatalf("expected summary %+v, got %+v", want, got)
+			}
+		})
+	}
+}
+
+func TestForce(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing map[string]string
+		force    bool
+		blocked  string
+	}{
+		{name: "empty output directory"},
+		{
+			name:     "hand-written main.go",
+			existing: map[string]string{"cmd/combined/main.go": "package main\n\nfunc main() {}\n"},
+			blocked:  filepath.Join("cmd", "combined", "main.go"),
+		},
+		{
+			name:     "hand-written main.go with force",
+			existing: map[string]string{"cmd/combined/main.go": "package main\n\nfunc main() {}\n"},
+			force:    true,
+		},
+		{
+			name:     "hand-written transformed package",
+			existing: map[string]string{"cmd/combined/cmd_server/main.go": "package cmd_server\n"},
+			blocked:  filepath.Join("cmd", "combined", "cmd_server", "main.go"),
+		},
+		{
+			name:     "unrelated file",
+			existing: map[string]string{"cmd/combined/README.md": "notes\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{"cmd/server/main.go": mainFile("server")})
+			writeFiles(t, dir, tt.existing)
+
+			c := collected(t, dir, WithForce(tt.force))
+
+			err := c.Write()
+			if tt.blocked != "" {
+				if err == nil || !strings.Contains(err.Error(), "refusing to overwrite files not generated by main-combiner: "+filepath.Join(dir, tt.blocked)) {
+					t.Fatalf("expected %s to block writing, got %v", tt.blocked, err)
+				}
+
+				// nothing is written, next to go.mod and the source
+				tree := readTree(t, dir)
+				if len(tree) != len(tt.existing)+2 {
+					t.Fatalf("expected nothing to be written, got %d files", len(tree))
+				}
+
+				for name, data := range tt.existing {
+					if got := tree[name]; got != data {
+						t.Fatalf("expected %s to be left alone, got %q", name, got)
+					}
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// a second write replaces only generated files
+			if err := c.Write(); err != nil {
+				t.Fatal(err)
+			}
+
+			binary := buildBinary(t, c)
+
+			if out, code := runBinary(t, binary, "server"); code != 0 || out != "server\n" {
+				t.Fatalf("expected server to print its name, got %q and exit code %d", out, code)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  map[string]string
+		changed []string
+		want    []string
+	}{
+		{name: "up to date"},
+		{
+			name:    "changed source",
+			change:  map[string]string{"cmd/server/main.go": mainFile("changed")},
+			changed: []string{"cmd_server/main.go"},
+			want:    []string{"-\tfmt.Println(\"server\")\n", "+\tfmt.Println(\"changed\")\n"},
+		},
+		{
+			name:    "new command",
+			change:  map[string]string{"cmd/worker/main.go": mainFile("worker")},
+			changed: []string{"cmd_worker/main.go", "main.go"},
+			want:    []string{"--- " + os.DevNull + "\n", "+\tcase \"worker\":\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{"cmd/server/main.go": mainFile("server")})
+
+			if err := collected(t, dir).Write(); err != nil {
+				t.Fatal(err)
+			}
+
+			writeFiles(t, dir, tt.change)
+
+			c := collected(t, dir)
+			before := snapshot(t, c.outputDir)
+
+			var diff bytes.Buffer
+
+			changed, err := c.Diff(&diff)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(snapshot(t, c.outputDir), before) {
+				t.Fatal("expected Diff to leave the output directory alone")
+			}
+
+			var want []string
+			for _, name := range tt.changed {
+				want = append(want, filepath.Join(c.outputDir, filepath.FromSlash(name)))
+			}
+
+			if !reflect.DeepEqual(changed, want) {
+				t.Fatalf("expected changed files %v, got %v", want, changed)
+			}
+
+			if len(tt.changed) == 0 && diff.Len() != 0 {
+				t.Fatalf("expected no diff, got:\n%s", diff.String())
+			}
+
+			for _, name := range want {
+				if !strings.Contains(diff.String(), "+++ "+name+"\n") {
+					t.Fatalf("expected a diff of %s:\n%s", name, diff.String())
+				}
+			}
+
+			for _, line := range tt.want {
+				if !strings.Contains(diff.String(), line) {
+					t.Fatalf("expected %q in the diff:\n%s", line, diff.String())
+				}
+			}
+
+			// writing leaves nothing to diff
+			binary := buildBinary(t, c)
+
+			diff.Reset()
+
+			if changed, err := c.Diff(&diff); err != nil || len(changed) != 0 || diff.Len() != 0 {
+				t.Fatalf("expected no diff after writing, got %v, %v:\n%s", changed, err, diff.String())
+			}
+
+			if out, code := runBinary(t, binary, "server"); code != 0 || out == "" {
+				t.Fatalf("expected server to run, got %q and exit code %d", out, code)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package combine
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"path"
+	"strings"
+	"text/template"
+)
+
+// DefaultPackageNameTemplate derives package names from the source
+// directory, e.g. cmd/foo-bar becomes cmd_foo_bar.
+const DefaultPackageNameTemplate = "{{ ident .Dir }}"
+
+// packageNameData is passed to the package name template.
+type packageNameData struct {
+	// Dir is the Packages key of the command.
+	Dir string
+	// Segments are the elements of Dir.
+	Segments []string
+	// Base is the last element of Dir.
+	Base string
+	// Module is the module path of the command.
+	Module string
+}
+
+var packageNameFuncs = template.FuncMap{
+	"ident": func(s string) string {
+		return strings.NewReplacer("-", "_", "/", "_", ".", "_").Replace(s)
+	},
+	"join":    strings.Join,
+	"replace": strings.ReplaceAll,
+	"lower":   strings.ToLower,
+}
+
+func parsePackageNameTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("package name").Funcs(packageNameFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid package name template: %w", err)
+	}
+
+	return tmpl, nil
+}
+
+// packageName renders the package name template for the package with the
+// given Packages key.
+func (c *Combiner) packageName(key string, module string) (string, error) {
+	data := packageNameData{
+		Dir:      key,
+		Segments: strings.Split(key, "/"),
+		Base:     path.Base(key),
+		Module:   module,
+	}
+
+	var buf bytes.Buffer
+	if err := c.packageNameTemplate.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to generate package name for %s: %w", key, err)
+	}
+
+	name := buf.String()
+
+	// directories such as 2fa produce names that are not identifiers; the
+	// prefix is a letter rather than _ as the go tool ignores directories
+	// starting with _ in patterns such as ./...
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "pkg" + name
+	}
+
+	if !token.IsIdentifier(name) || name == "_" {
+		return "", fmt.Errorf("package name %q generated for %s is not a valid identifier", name, key)
+	}
+
+	return name, nil
+}
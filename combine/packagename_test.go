@@ -0,0 +1,111 @@
+package combine
+
+import "testing"
+
+func TestPackageNameTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		packages map[string]string
+		err      string
+	}{
+		{
+			name:     "default",
+			template: DefaultPackageNameTemplate,
+			packages: map[string]string{"cmd/tools/foo-bar": "cmd_tools_foo_bar", "cmd/server": "cmd_server"},
+		},
+		{
+			name:     "base",
+			template: "{{ .Base }}_cmd",
+			err:      `package name "foo-bar_cmd" generated for cmd/tools/foo-bar is not a valid identifier`,
+		},
+		{
+			name:     "functions",
+			template: `{{ replace .Base "-" "" | lower }}_{{ index .Segments 0 }}`,
+			packages: map[string]string{"cmd/tools/foo-bar": "foobar_cmd", "cmd/server": "server_cmd"},
+		},
+		{
+			name:     "segments",
+			template: `{{ ident (join .Segments "/") }}`,
+			packages: map[string]string{"cmd/tools/foo-bar": "cmd_tools_foo_bar"},
+		},
+		{
+			name:     "invalid",
+			template: "{{ .Base",
+			err:      `invalid package name template: template: package name:1: unclosed action`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/tools/foo-bar/main.go": mainFile("foo-bar"),
+				"cmd/server/main.go":        mainFile("server"),
+			})
+
+			c, err := New(dir, "cmd/combined", WithPackageNameTemplate(tt.template))
+			if err == nil {
+				err = c.Collect()
+			}
+
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for key, want := range tt.packages {
+				if got := c.packages[key].PackageName; got != want {
+					t.Errorf("expected package %s for %s, got %s", want, key, got)
+				}
+			}
+
+			buildOutput(t, c)
+		})
+	}
+}
+
+func TestPackageNameDigits(t *testing.T) {
+	tests := []struct {
+		dir         string
+		packageName string
+		command     string
+	}{
+		{dir: "2fa", packageName: "pkg2fa", command: "2fa"},
+		{dir: "123", packageName: "pkg123", command: "123"},
+		{dir: "_weird", packageName: "_weird", command: "_weird"},
+	}
+
+	files := make(map[string]string)
+	for _, tt := range tests {
+		files["cmd/"+tt.dir+"/main.go"] = mainFile(tt.dir)
+	}
+
+	dir := newModule(t, files)
+
+	c := collected(t, dir, WithPackageNameTemplate("{{ .Base }}"))
+	binary := buildBinary(t, c)
+
+	for _, tt := range tests {
+		t.Run(tt.dir, func(t *testing.T) {
+			m := c.packages["cmd/"+tt.dir]
+			if m == nil {
+				t.Fatalf("cmd/%s was not collected", tt.dir)
+			}
+
+			if m.PackageName != tt.packageName || m.Command != tt.command {
+				t.Fatalf("expected package %s and command %s, got %s and %s", tt.packageName, tt.command, m.PackageName, m.Command)
+			}
+
+			if out, code := runBinary(t, binary, tt.command); code != 0 || out != tt.dir+"\n" {
+				t.Fatalf("expected %s to run, got %q and exit code %d", tt.command, out, code)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package combine
+
+import (
+	"go/ast"
+	"unicode"
+	"unicode/utf8"
+)
+
+// prefixTopLevel renames the top-level declarations of a package made up of
+// sources, along with every reference to them. Exported names stay exported.
+//
+// References are found using the parser's per-file resolution: identifiers
+// resolved to a top-level object of their own file, and unresolved
+// identifiers naming a top-level declaration of another file in the package.
+func prefixTopLevel(prefix string, sources []*sourceFile, rename RenamePredicate) {
+	names := prefixedNames(sources, rename)
+
+	for _, src := range sources {
+		unresolved := make(map[*ast.Ident]bool)
+		for _, ident := range src.file.Unresolved {
+			unresolved[ident] = true
+		}
+
+		ast.Inspect(src.file, func(n ast.Node) bool {
+			ident, ok := n.(*ast.Ident)
+			if !ok || !names[ident.Name] {
+				return true
+			}
+
+			topLevel := ident.Obj != nil && src.file.Scope.Objects[ident.Name] == ident.Obj
+			if topLevel || unresolved[ident] {
+				ident.Name = prefixName(prefix, ident.Name)
+			}
+
+			return true
+		})
+	}
+}
+
+// RenamePredicate reports whether the top-level declaration called name, of
+// kind ast.Con, ast.Typ, ast.Var or ast.Fun, is prefixed with the generated
+// package name. It is never asked about main and init.
+type RenamePredicate func(name string, kind ast.ObjKind) bool
+
+// renameAll is the RenamePredicate of WithPrefixIdentifiers.
+func renameAll(string, ast.ObjKind) bool {
+	return true
+}
+
+// renamePredicate returns the predicate selecting the declarations to
+// prefix, or nil if none are.
+func (c *Combiner) renamePredicate() RenamePredicate {
+	if c.rename != nil {
+		return c.rename
+	}
+
+	if c.prefixIdentifiers {
+		return renameAll
+	}
+
+	return nil
+}
+
+// prefixedNames returns the names of the top-level declarations of sources
+// that rename accepts.
+func prefixedNames(sources []*sourceFile, rename RenamePredicate) map[string]bool {
+	names := make(map[string]bool)
+
+	for _, src := range sources {
+		for name, obj := range src.file.Scope.Objects {
+			if name == "main" || name == "init" || name == "_" {
+				continue
+			}
+
+			if obj.Kind == ast.Bad || obj.Kind == ast.Lbl {
+				continue
+			}
+
+			if rename(name, obj.Kind) {
+				names[name] = true
+			}
+		}
+	}
+
+	return names
+}
+
+func prefixName(prefix string, name string) string {
+	if !ast.IsExported(name) {
+		return prefix + name
+	}
+
+	r, size := utf8.DecodeRuneInString(prefix)
+
+	return string(unicode.ToUpper(r)) + prefix[size:] + name
+}
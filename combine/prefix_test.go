@@ -0,0 +1,172 @@
+package combine
+
+import (
+	"go/ast"
+	"strings"
+	"testing"
+)
+
+func TestPrefixIdentifiers(t *testing.T) {
+	dir := newModule(t, map[string]string{
+		"cmd/server/main.go": `package main
+
+import "fmt"
+
+func main() {
+	for i := 0; i < 2; i++ {
+		counter++
+	}
+
+	fmt.Println(helper(config{name: "server"}), Exported)
+}
+`,
+		"cmd/server/helpers.go": `package main
+
+var counter int
+
+const Exported = "exported"
+
+type config struct {
+	name string
+}
+
+func helper(c config) string {
+	return c.name
+}
+`,
+	})
+
+	c := collected(t, dir, WithPrefixIdentifiers(true))
+	files, err := c.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		file string
+		want string
+	}{
+		{name: "func", file: "helpers.go", want: "func cmd_server_helper(c cmd_server_config) string"},
+		{name: "var", file: "helpers.go", want: "var cmd_server_counter int"},
+		{name: "type", file: "helpers.go", want: "type cmd_server_config struct"},
+		{name: "exported", file: "helpers.go", want: `const Cmd_server_Exported = "exported"`},
+		{name: "references", file: "main.go", want: `fmt.Println(cmd_server_helper(cmd_server_config{name: "server"}), Cmd_server_Exported)`},
+		{name: "main", file: "main.go", want: "func MainFunction() {"},
+		{name: "local", file: "main.go", want: "for i := 0; i < 2; i++ {\n\t\tcmd_server_counter++"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := string(files["cmd_server/"+tt.file])
+			if !strings.Contains(data, tt.want) {
+				t.Fatalf("expected %s to contain %q:\n%s", tt.file, tt.want, data)
+			}
+		})
+	}
+
+	buildOutput(t, c)
+}
+
+func TestRenamePredicate(t *testing.T) {
+	const helpers = `package main
+
+var counter int
+
+func init() {
+	counter++
+}
+
+func helper() string {
+	return "helper"
+}
+
+func other() int {
+	return counter
+}
+`
+
+	tests := []struct {
+		name      string
+		rename    RenamePredicate
+		prefixAll bool
+		want      []string
+		// unwanted must not appear in helpers.go
+		unwanted []string
+	}{
+		{
+			name:     "default",
+			want:     []string{"func helper() string", "func other() int", "var counter int"},
+			unwanted: []string{"cmd_server_"},
+		},
+		{
+			name:     "helper only",
+			rename:   func(name string, kind ast.ObjKind) bool { return name == "helper" },
+			want:     []string{"func cmd_server_helper() string", "func other() int", "var counter int"},
+			unwanted: []string{"cmd_server_other", "cmd_server_counter"},
+		},
+		{
+			name:     "functions",
+			rename:   func(name string, kind ast.ObjKind) bool { return kind == ast.Fun },
+			want:     []string{"func cmd_server_helper() string", "func cmd_server_other() int", "var counter int", "func init() {"},
+			unwanted: []string{"cmd_server_counter"},
+		},
+		{
+			name:      "precedence over prefixing everything",
+			rename:    func(name string, kind ast.ObjKind) bool { return name == "helper" },
+			prefixAll: true,
+			want:      []string{"func cmd_server_helper() string", "func other() int"},
+			unwanted:  []string{"cmd_server_counter"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go":    "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(helper(), other())\n}\n",
+				"cmd/server/helpers.go": helpers,
+			})
+
+			var asked []string
+
+			options := []Option{WithPrefixIdentifiers(tt.prefixAll)}
+			if tt.rename != nil {
+				options = append(options, WithRenamePredicate(func(name string, kind ast.ObjKind) bool {
+					asked = append(asked, name)
+					return tt.rename(name, kind)
+				}))
+			}
+
+			c := collected(t, dir, options...)
+
+			files, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data := string(files["cmd_server/helpers.go"])
+
+			for _, want := range tt.want {
+				if !strings.Contains(data, want) {
+					t.Fatalf("expected %q in helpers.go:\n%s", want, data)
+				}
+			}
+
+			for _, unwanted := range tt.unwanted {
+				if strings.Contains(data, unwanted) {
+					t.Fatalf("unexpected %q in helpers.go:\n%s", unwanted, data)
+				}
+			}
+
+			for _, name := range asked {
+				if name == "main" || name == "init" {
+					t.Fatalf("the predicate was asked about %s", name)
+				}
+			}
+
+			if out, code := runBinary(t, buildBinary(t, c), "server"); code != 0 || out != "helper 1\n" {
+				t.Fatalf("expected server to print helper 1, got %q and exit code %d", out, code)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package combine
+
+import (
+	"sync"
+	"time"
+)
+
+// Progress counts what the walk and parse of the inputs have discovered.
+type Progress struct {
+	// Files is the number of Go files found that pass the filters.
+	Files int
+	// Parsed is the number of those files parsed.
+	Parsed int
+	// Packages is the number of directories found to declare func main in
+	// a main package.
+	Packages int
+}
+
+// progress is updated concurrently while parsing.
+type progress struct {
+	mu       sync.Mutex
+	counts   Progress
+	packages map[string]bool
+}
+
+func (p *progress) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.counts = Progress{}
+	p.packages = nil
+}
+
+func (p *progress) foundFile() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.counts.Files++
+}
+
+// parsed records a parsed file of the directory key, which declares func
+// main in a main package if isMain is set.
+func (p *progress) parsed(key string, isMain bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.counts.Parsed++
+
+	if isMain && !p.packages[key] {
+		if p.packages == nil {
+			p.packages = make(map[string]bool)
+		}
+
+		p.packages[key] = true
+		p.counts.Packages++
+	}
+}
+
+func (p *progress) get() Progress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.counts
+}
+
+// Progress returns what the last walk of the inputs, by Collect or
+// Discover, discovered. It is safe to call while the walk is running.
+func (c *Combiner) Progress() Progress {
+	return c.progress.get()
+}
+
+// reportProgress logs the progress every interval until the returned
+// function is called, which logs it a final time. It does nothing unless
+// WithProgress is set.
+func (c *Combiner) reportProgress() func() {
+	if c.progressInterval <= 0 {
+		return func() {}
+	}
+
+	ticker := time.NewTicker(c.progressInterval)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		for {
+			select {
+			case <-ticker.C:
+				c.logProgress()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+		<-stopped
+
+		c.logProgress()
+	}
+}
+
+func (c *Combiner) logProgress() {
+	p := c.progress.get()
+	c.logf(0, "progress: found %d Go files, parsed %d, %d main packages", p.Files, p.Parsed, p.Packages)
+}
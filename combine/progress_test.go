@@ -0,0 +1,87 @@
+package combine
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgress(t *testing.T) {
+	files := map[string]string{
+		"cmd/server/main.go":      mainFile("server"),
+		"cmd/server/helper.go":    "package main\n\nfunc helper() {}\n",
+		"cmd/server/main_test.go": "package main\n",
+		"cmd/worker/main.go":      mainFile("worker"),
+		"cmd/tool/tool.go":        "package main\n\nfunc helper() {}\n",
+		"pkg/lib/lib.go":          "package lib\n",
+		"vendor/x/main.go":        mainFile("vendored"),
+		"docs/README.md":          "docs\n",
+	}
+
+	tests := []struct {
+		name string
+		opts []Option
+		want Progress
+	}{
+		// cmd/tool has no func main, and ignored files are not found
+		{name: "everything", want: Progress{Files: 6, Parsed: 6, Packages: 2}},
+		{name: "excluded", opts: []Option{WithExclude("cmd/worker")}, want: Progress{Files: 5, Parsed: 5, Packages: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, files)
+
+			var logs bytes.Buffer
+
+			c := newCombiner(t, dir, append([]Option{WithProgress(time.Hour), WithLogger(log.New(&logs, "", 0), 0)}, tt.opts...)...)
+
+			if got := c.Progress(); got != (Progress{}) {
+				t.Fatalf("expected no progress before Collect, got %+v", got)
+			}
+
+			if err := c.Collect(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := c.Progress(); got != tt.want {
+				t.Fatalf("expected progress %+v, got %+v", tt.want, got)
+			}
+
+			// with a long interval, only the final count is logged
+			want := fmt.Sprintf("progress: found %d Go files, parsed %d, %d main packages", tt.want.Files, tt.want.Parsed, tt.want.Packages)
+			if strings.Count(logs.String(), want+"\n") != 1 {
+				t.Fatalf("expected the final progress to be logged, got:\n%s", logs.String())
+			}
+
+			// counts start over for every walk
+			if _, err := c.Discover(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := c.Progress(); got != tt.want {
+				t.Fatalf("expected progress %+v after Discover, got %+v", tt.want, got)
+			}
+
+			buildOutput(t, c)
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		var logs bytes.Buffer
+
+		c := collected(t, newModule(t, files), WithLogger(log.New(&logs, "", 0), 0))
+
+		if strings.Contains(logs.String(), "progress: ") {
+			t.Fatalf("unexpected progress without WithProgress:\n%s", logs.String())
+		}
+
+		// the counts are kept regardless
+		if got := c.Progress(); got.Packages != 2 {
+			t.Fatalf("expected 2 main packages, got %+v", got)
+		}
+	})
+}
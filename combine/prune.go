@@ -0,0 +1,146 @@
+package combine
+
+import (
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// prune removes output written by an earlier run that files no longer
+// contains: package directories for commands that went away, and generated
+// files of a package whose source file was removed. Only directories in
+// which every file is a generated Go file are removed, and only generated Go
+// files are deleted.
+func (c *Combiner) prune(files map[string][]byte) error {
+	entries, err := ioutil.ReadDir(c.outputDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	dirs := make(map[string]bool)
+	for name := range files {
+		dirs[path.Dir(name)] = true
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		dir := filepath.Join(c.outputDir, entry.Name())
+
+		if dirs[entry.Name()] {
+			if err := c.pruneFiles(dir, entry.Name(), files); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		ok, err := onlyGenerated(dir)
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			if err := os.RemoveAll(dir); err != nil {
+				return err
+			}
+
+			c.logf(1, "removed stale directory %s", dir)
+		}
+	}
+
+	return nil
+}
+
+// pruneFiles removes generated Go files in dir that are not in files.
+func (c *Combiner) pruneFiles(dir string, name string, files map[string][]byte) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		if _, ok := files[path.Join(name, entry.Name())]; ok {
+			continue
+		}
+
+		filename := filepath.Join(dir, entry.Name())
+		if !isGeneratedFile(filename) {
+			continue
+		}
+
+		if err := os.Remove(filename); err != nil {
+			return err
+		}
+
+		c.logf(1, "removed stale file %s", filename)
+	}
+
+	return nil
+}
+
+// onlyGenerated reports whether every file under dir is a generated Go file.
+func onlyGenerated(dir string) (bool, error) {
+	generated := true
+
+	err := filepath.Walk(dir, func(fullPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		if !isGeneratedFile(fullPath) {
+			generated = false
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+
+	return generated, err
+}
+
+// isGeneratedFile reports whether filename is a Go file written by the
+// combiner.
+func isGeneratedFile(filename string) bool {
+	if !strings.HasSuffix(filename, ".go") {
+		return false
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return false
+	}
+
+	fset := token.NewFileSet()
+
+	f, err := parser.ParseFile(fset, filename, data, parser.PackageClauseOnly|parser.ParseComments)
+	if err != nil {
+		return false
+	}
+
+	src := &sourceFile{
+		filename: filename,
+		fset:     fset,
+		file:     f,
+	}
+
+	return src.isGenerated()
+}
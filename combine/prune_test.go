@@ -0,0 +1,81 @@
+package combine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrune(t *testing.T) {
+	tests := []struct {
+		name  string
+		prune bool
+		// exist maps paths relative to the output directory to whether they
+		// must exist after the second run
+		exist map[string]bool
+	}{
+		{
+			name:  "prune",
+			prune: true,
+			exist: map[string]bool{
+				"cmd_server/main.go":   true,
+				"cmd_server/old.go":    false,
+				"cmd_worker":           false,
+				"custom/custom.go":     true,
+				"cmd_server/notes.txt": true,
+			},
+		},
+		{
+			name: "keep",
+			exist: map[string]bool{
+				"cmd_server/main.go": true,
+				"cmd_server/old.go":  true,
+				"cmd_worker/main.go": true,
+				"custom/custom.go":   true,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go": mainFile("server"),
+				"cmd/server/old.go":  "package main\n",
+				"cmd/worker/main.go": mainFile("worker"),
+			})
+
+			c := collected(t, dir)
+			if err := c.Write(); err != nil {
+				t.Fatal(err)
+			}
+
+			// hand written files are never pruned
+			writeFiles(t, c.outputDir, map[string]string{
+				"custom/custom.go":     "package custom\n",
+				"cmd_server/notes.txt": "notes\n",
+			})
+
+			for _, name := range []string{"cmd/worker", "cmd/server/old.go"} {
+				if err := os.RemoveAll(filepath.Join(dir, name)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			c = collected(t, dir, WithPrune(tt.prune))
+			if err := c.Write(); err != nil {
+				t.Fatal(err)
+			}
+
+			for name, want := range tt.exist {
+				_, err := os.Stat(filepath.Join(c.outputDir, filepath.FromSlash(name)))
+				if got := err == nil; got != want {
+					t.Errorf("expected %s to exist: %v, got %v", name, want, got)
+				}
+			}
+
+			if tt.prune {
+				goBuild(t, c.outputDir)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package combine
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+)
+
+// registryPackage is the name of the generated package commands register
+// themselves with in DispatchRegistry mode.
+const registryPackage = "registry"
+
+const registryImportName = "combinedregistry"
+
+// registerName is the file added to every package in DispatchRegistry mode
+// to register its command.
+const registerName = "combined_register.go"
+
+func (c *Combiner) registryImportPath() string {
+	return path.Join(c.outputImportPath(), registryPackage)
+}
+
+// registrySource returns the registry package. With context the main
+// functions it holds take a context.Context.
+func registrySource(context bool) []byte {
+	mainType := "func()"
+	imports := ""
+
+	if context {
+		mainType = "func(context.Context)"
+		imports = "\t\"context\"\n"
+	}
+
+	var buf bytes.Buffer
+	_, _ = buf.WriteString(generatedHeader + "\n\n")
+	_, _ = fmt.Fprintf(&buf, `// Package registry maps command names to the main functions of the
+// combined commands, which register themselves when imported.
+package registry
+
+import (
+%s	"fmt"
+	"sort"
+)
+
+var commands = make(map[string]%s)
+
+// Register makes main runnable as name. It panics if name is already
+// registered.
+func Register(name string, main %s) {
+	if _, ok := commands[name]; ok {
+		panic(fmt.Sprintf("command %%s registered twice", name))
+	}
+
+	commands[name] = main
+}
+
+// Lookup returns the main function registered as name.
+func Lookup(name string) (%s, bool) {
+	main, ok := commands[name]
+	return main, ok
+}
+
+// Names returns the registered command names, sorted.
+func Names() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+`, imports, mainType, mainType, mainType)
+
+	return buf.Bytes()
+}
+
+// registerSource returns the file that registers the command of m.
+func (c *Combiner) registerSource(m *MainPackage) []byte {
+	var buf bytes.Buffer
+	if c.standaloneTag != "" {
+		_, _ = fmt.Fprintf(&buf, "//go:build %s\n// +build %s\n\n", c.standaloneTag, c.standaloneTag)
+	}
+
+	_, _ = buf.WriteString(generatedHeader + "\n\n")
+	main := c.entrypointName
+	imports := fmt.Sprintf("import %s %q", registryImportName, c.registryImportPath())
+
+	if c.contextEntrypoint && !m.context {
+		main = fmt.Sprintf("func(context.Context) { %s() }", c.entrypointName)
+		imports = fmt.Sprintf("import (\n\t\"context\"\n\n\t%s %q\n)", registryImportName, c.registryImportPath())
+	}
+
+	_, _ = fmt.Fprintf(&buf, `package %s
+
+%s
+
+func init() {
+	%s.Register(%q, %s)
+}
+`, m.PackageName, imports, registryImportName, m.Command, main)
+
+	return buf.Bytes()
+}
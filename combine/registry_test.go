@@ -0,0 +1,57 @@
+package combine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []Option
+	}{
+		{name: "default"},
+		{name: "context", options: []Option{WithContextEntrypoint(true)}},
+		{name: "custom entrypoint", options: []Option{WithEntrypointName("CombinedMain")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, commandTree)
+
+			c := collected(t, dir, append([]Option{WithDispatch(DispatchRegistry)}, tt.options...)...)
+
+			files, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, ok := files[registryPackage+"/"+registryPackage+".go"]; !ok {
+				t.Fatal("the registry package was not generated")
+			}
+
+			if strings.Contains(string(files["main.go"]), "switch ") {
+				t.Fatalf("the registry dispatcher has a switch:\n%s", files["main.go"])
+			}
+
+			for _, m := range c.packages {
+				register := string(files[m.PackageName+"/"+registerName])
+				if want := registryImportName + ".Register(\"" + m.Command + "\""; !strings.Contains(register, want) {
+					t.Fatalf("expected %s to register %s:\n%s", m.PackageName, m.Command, register)
+				}
+			}
+
+			binary := buildBinary(t, c)
+
+			for _, name := range commandNames(c) {
+				if out, code := runBinary(t, binary, name); code != 0 || out != name+"\n" {
+					t.Fatalf("expected %s to print its name, got %q and exit code %d", name, out, code)
+				}
+			}
+
+			if out, code := runBinary(t, binary, "unknown"); code != DefaultUnknownExitCode || out != "unknown command unknown\n" {
+				t.Fatalf("expected exit code %d for an unknown command, got %d: %s", DefaultUnknownExitCode, code, out)
+			}
+		})
+	}
+}
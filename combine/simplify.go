@@ -0,0 +1,122 @@
+package combine
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// simplify applies the rewrites of gofmt -s to f:
+//
+//	[]T{T{}, T{}}           =>  []T{{}, {}}
+//	[]*T{&T{}}              =>  []*T{{}}
+//	s[a:len(s)]             =>  s[a:]
+//	for x, _ = range v {}   =>  for x = range v {}
+//	for _ = range v {}      =>  for range v {}
+func simplify(f *ast.File) {
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.CompositeLit:
+			simplifyCompositeLit(n)
+		case *ast.SliceExpr:
+			simplifySliceExpr(n)
+		case *ast.RangeStmt:
+			if isBlank(n.Value) {
+				n.Value = nil
+			}
+
+			if isBlank(n.Key) && n.Value == nil {
+				n.Key = nil
+			}
+		}
+
+		return true
+	})
+}
+
+func simplifyCompositeLit(lit *ast.CompositeLit) {
+	var keyType, eltType ast.Expr
+
+	switch t := lit.Type.(type) {
+	case *ast.ArrayType:
+		eltType = t.Elt
+	case *ast.MapType:
+		keyType = t.Key
+		eltType = t.Value
+	default:
+		return
+	}
+
+	for i := range lit.Elts {
+		px := &lit.Elts[i]
+
+		if kv, ok := (*px).(*ast.KeyValueExpr); ok {
+			if keyType != nil {
+				simplifyElement(keyType, &kv.Key)
+			}
+
+			px = &kv.Value
+		}
+
+		simplifyElement(eltType, px)
+	}
+}
+
+// simplifyElement removes the type of the composite literal *px when it is
+// implied by typ.
+func simplifyElement(typ ast.Expr, px *ast.Expr) {
+	if inner, ok := (*px).(*ast.CompositeLit); ok && sameType(typ, inner.Type) {
+		inner.Type = nil
+		return
+	}
+
+	ptr, ok := typ.(*ast.StarExpr)
+	if !ok {
+		return
+	}
+
+	addr, ok := (*px).(*ast.UnaryExpr)
+	if !ok || addr.Op != token.AND {
+		return
+	}
+
+	if inner, ok := addr.X.(*ast.CompositeLit); ok && sameType(ptr.X, inner.Type) {
+		inner.Type = nil
+		*px = inner
+	}
+}
+
+func sameType(a, b ast.Expr) bool {
+	return a != nil && b != nil && types.ExprString(a) == types.ExprString(b)
+}
+
+// simplifySliceExpr drops the high bound of s[a:len(s)].
+func simplifySliceExpr(n *ast.SliceExpr) {
+	if n.Max != nil {
+		return
+	}
+
+	s, ok := n.X.(*ast.Ident)
+	if !ok || s.Obj == nil {
+		return
+	}
+
+	call, ok := n.High.(*ast.CallExpr)
+	if !ok || len(call.Args) != 1 || call.Ellipsis.IsValid() {
+		return
+	}
+
+	// len must be the builtin, not a local declaration
+	if fun, ok := call.Fun.(*ast.Ident); !ok || fun.Name != "len" || fun.Obj != nil {
+		return
+	}
+
+	if arg, ok := call.Args[0].(*ast.Ident); ok && arg.Obj == s.Obj {
+		n.High = nil
+	}
+}
+
+func isBlank(x ast.Expr) bool {
+	ident, ok := x.(*ast.Ident)
+	return ok && ident.Name == "_"
+}
@@ -0,0 +1,80 @@
+package combine
+
+import (
+	"bytes"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+func TestSimplify(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "slice",
+			source: "s := os.Args[0]\n\tfmt.Println(s[1:len(s)])",
+			want:   "fmt.Println(s[1:])",
+		},
+		{
+			name:   "composite literal",
+			source: "points := []point{point{x: 1}, point{x: 2}}\n\tfmt.Println(points)",
+			want:   "points := []point{{x: 1}, {x: 2}}",
+		},
+		{
+			name:   "pointer composite literal",
+			source: "points := []*point{&point{x: 1}}\n\tfmt.Println(points)",
+			want:   "points := []*point{{x: 1}}",
+		},
+		{
+			name:   "range value",
+			source: "for i, _ := range os.Args {\n\t\tfmt.Println(i)\n\t}",
+			want:   "for i := range os.Args {",
+		},
+		{
+			name:   "range key",
+			source: "for _ = range os.Args {\n\t\tfmt.Println()\n\t}",
+			want:   "for range os.Args {",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go": "package main\n\nimport (\n\t\"fmt\"\n\t\"os\"\n)\n\ntype point struct {\n\tx int\n}\n\nfunc main() {\n\t_ = point{}\n\t_ = os.Args\n\t" + tt.source + "\n}\n",
+			})
+
+			files := generate(t, dir, WithSimplify(true))
+
+			got := files["cmd_server/main.go"]
+			if !strings.Contains(string(got), tt.want) {
+				t.Fatalf("expected %q in the simplified output:\n%s", tt.want, got)
+			}
+
+			// simplifying the output again changes nothing
+			fset := token.NewFileSet()
+
+			f, err := parser.ParseFile(fset, "main.go", got, parser.ParseComments)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			simplify(f)
+
+			var again bytes.Buffer
+			if err := format.Node(&again, fset, f); err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(again.Bytes(), got) {
+				t.Fatalf("the output is not stable under simplification:\n%s\n%s", got, again.Bytes())
+			}
+
+			buildOutput(t, collected(t, dir, WithSimplify(true)))
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package combine
+
+import (
+	"bytes"
+	"fmt"
+	"go/build/constraint"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+)
+
+// standalonePrefix starts the name of the copy of each original file that
+// is kept as package main when a standalone tag is set.
+const standalonePrefix = "standalone_"
+
+// checkTag reports an error if tag can't be used in a build constraint.
+func checkTag(tag string) error {
+	expr, err := constraint.Parse("//go:build " + tag)
+	if err != nil {
+		return fmt.Errorf("invalid build tag %q: %w", tag, err)
+	}
+
+	if _, ok := expr.(*constraint.TagExpr); !ok {
+		return fmt.Errorf("invalid build tag %q", tag)
+	}
+
+	return nil
+}
+
+// addStandalone guards the generated files of m with the standalone tag and
+// adds copies of the original files guarded by its negation, so the
+// package builds as a library with the tag and as the original command
+// without it.
+func (c *Combiner) addStandalone(m *MainPackage) error {
+	for filename, data := range m.Contents {
+		data, err := withTag(filename, data, c.standaloneTag, false)
+		if err != nil {
+			return err
+		}
+
+		m.Contents[filename] = data
+	}
+
+	for _, src := range m.sources {
+		data, err := withTag(src.filename, src.data, c.standaloneTag, true)
+		if err != nil {
+			return err
+		}
+
+		data, err = addGeneratedHeader(src.filename, data)
+		if err != nil {
+			return err
+		}
+
+		m.Contents[standaloneName(src)] = data
+	}
+
+	return nil
+}
+
+// standaloneName is the Contents key of the standalone copy of src.
+func standaloneName(src *sourceFile) string {
+	return filepath.Join(filepath.Dir(src.filename), standalonePrefix+filepath.Base(src.filename))
+}
+
+// withTag adds tag, or its negation, to the build constraints of the Go
+// source data, writing both //go:build and // +build lines for go versions
+// before 1.17. The result is formatted.
+func withTag(filename string, data []byte, tag string, negate bool) ([]byte, error) {
+	fset := token.NewFileSet()
+
+	f, err := parser.ParseFile(fset, filename, data, parser.PackageClauseOnly|parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse new code for %s %w", filename, err)
+	}
+
+	src := &sourceFile{filename: filename, constraints: buildConstraints(f)}
+
+	old, err := src.constraint()
+	if err != nil {
+		return nil, err
+	}
+
+	var expr constraint.Expr = &constraint.TagExpr{Tag: tag}
+	if negate {
+		expr = &constraint.NotExpr{X: expr}
+	}
+
+	if old != nil {
+		expr = &constraint.AndExpr{X: old, Y: expr}
+	}
+
+	plus, err := constraint.PlusBuildLines(expr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add build tag to %s: %w", filename, err)
+	}
+
+	var buf bytes.Buffer
+
+	_, _ = buf.WriteString("//go:build " + expr.String() + "\n")
+	for _, line := range plus {
+		_, _ = buf.WriteString(line + "\n")
+	}
+
+	_, _ = buf.WriteString("\n")
+
+	// the old constraint lines are dropped; formatting removes the blank
+	// lines they leave
+	last := 0
+
+	for _, cg := range f.Comments {
+		if cg.Pos() >= f.Package {
+			break
+		}
+
+		for _, comment := range cg.List {
+			if isConstraint(comment.Text) {
+				_, _ = buf.Write(data[last:fset.Position(comment.Pos()).Offset])
+				last = fset.Position(comment.End()).Offset
+			}
+		}
+	}
+
+	_, _ = buf.Write(data[last:])
+
+	out, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, &FormatError{File: filename, Source: buf.Bytes(), Err: err}
+	}
+
+	return out, nil
+}
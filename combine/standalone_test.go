@@ -0,0 +1,69 @@
+package combine
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestStandaloneTag(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []Option
+	}{
+		{name: "argv0"},
+		{name: "registry", options: []Option{WithDispatch(DispatchRegistry)}},
+		{name: "single file", options: []Option{WithSingleFile(true)}},
+		{name: "version variable", options: []Option{WithVersionVar("version")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go":       mainFile("server"),
+				"cmd/server/helper.go":     "package main\n\nfunc helper() {}\n",
+				"cmd/worker/main_linux.go": mainFile("worker"),
+				"cmd/worker/main_other.go": "//go:build !linux\n\n" + mainFile("worker"),
+			})
+
+			c := collected(t, dir, append([]Option{WithStandaloneTag("combined")}, tt.options...)...)
+
+			// the combined binary needs the tag
+			binary := buildBinary(t, c, "-tags", "combined")
+
+			for _, name := range commandNames(c) {
+				if out, code := runBinary(t, binary, name); code != 0 || out != name+"\n" {
+					t.Fatalf("expected %s to print its name, got %q and exit code %d", name, out, code)
+				}
+			}
+
+			// each package still builds as the original command without it
+			for _, m := range c.packages {
+				cmd := exec.Command("go", "run", ".")
+				cmd.Dir = m.OutputDir
+
+				out, err := cmd.CombinedOutput()
+				if err != nil {
+					t.Fatalf("failed to run %s on its own: %v\n%s", m.PackageName, err, out)
+				}
+
+				if string(out) != m.Command+"\n" {
+					t.Fatalf("expected %s on its own to print %s, got %q", m.PackageName, m.Command, out)
+				}
+			}
+		})
+	}
+}
+
+func TestStandaloneTagInvalid(t *testing.T) {
+	dir := newModule(t, map[string]string{"cmd/server/main.go": mainFile("server")})
+
+	for _, tag := range []string{"a b", "!combined", "a||b"} {
+		t.Run(tag, func(t *testing.T) {
+			_, err := New(dir, "cmd/combined", WithStandaloneTag(tag))
+			if err == nil || !strings.HasPrefix(err.Error(), "invalid build tag ") {
+				t.Fatalf("expected tag %q to be rejected, got %v", tag, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package combine
+
+import "time"
+
+// Timings are the wall-clock durations of the phases of a run. Phases that
+// run more than once, such as Generate when Check is followed by Write, are
+// summed.
+type Timings struct {
+	// Walk is the time spent walking the input directories.
+	Walk time.Duration
+	// Parse is the time spent parsing the files found.
+	Parse time.Duration
+	// Rewrite is the time spent transforming and formatting packages.
+	Rewrite time.Duration
+	// Generate is the time spent generating the dispatcher and other
+	// shared files.
+	Generate time.Duration
+	// Write is the time spent writing the output, not counting Generate.
+	Write time.Duration
+}
+
+// Total returns the sum of all phases.
+func (t Timings) Total() time.Duration {
+	return t.Walk + t.Parse + t.Rewrite + t.Generate + t.Write
+}
+
+// Timings returns how long each phase has taken so far.
+func (c *Combiner) Timings() Timings {
+	return c.timings
+}
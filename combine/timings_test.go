@@ -0,0 +1,76 @@
+package combine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimings(t *testing.T) {
+	dir := syntheticTree(t, 20, 3)
+
+	start := time.Now()
+
+	c := newCombiner(t, dir)
+
+	steps := []struct {
+		name string
+		run  func() error
+		// ran are the phases the step runs, and zero those that have not
+		// run yet
+		ran  []string
+		zero []string
+	}{
+		{name: "new", run: func() error { return nil }, zero: []string{"walk", "parse", "rewrite", "generate", "write"}},
+		{name: "collect", run: c.Collect, ran: []string{"walk", "parse", "rewrite"}, zero: []string{"generate", "write"}},
+		{name: "check", run: func() error { _, err := c.Check(); return err }, ran: []string{"generate"}, zero: []string{"write"}},
+		{name: "write", run: c.Write, ran: []string{"generate", "write"}},
+	}
+
+	var previous Timings
+
+	for _, step := range steps {
+		if err := step.run(); err != nil {
+			t.Fatal(err)
+		}
+
+		elapsed := time.Since(start)
+		timings := c.Timings()
+
+		phases := map[string]time.Duration{
+			"walk":     timings.Walk,
+			"parse":    timings.Parse,
+			"rewrite":  timings.Rewrite,
+			"generate": timings.Generate,
+			"write":    timings.Write,
+		}
+
+		for name, d := range phases {
+			if d < 0 {
+				t.Fatalf("after %s, %s took %s", step.name, name, d)
+			}
+		}
+
+		for _, name := range step.ran {
+			if phases[name] == 0 {
+				t.Fatalf("after %s, %s ran but took no time", step.name, name)
+			}
+		}
+
+		for _, name := range step.zero {
+			if phases[name] != 0 {
+				t.Fatalf("after %s, %s has not run but took %s", step.name, name, phases[name])
+			}
+		}
+
+		if timings.Total() > elapsed {
+			t.Fatalf("after %s, the total %s is more than the %s elapsed", step.name, timings.Total(), elapsed)
+		}
+
+		// phases that run again are summed
+		if timings.Total() < previous.Total() || timings.Generate < previous.Generate {
+			t.Fatalf("after %s, the timings went down from %+v to %+v", step.name, previous, timings)
+		}
+
+		previous = timings
+	}
+}
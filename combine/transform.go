@@ -0,0 +1,463 @@
+package combine
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"strings"
+
+	"github.com/fatih/astrewrite"
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// PackageDoc selects what happens to the package doc comment of a main
+// package when it is renamed.
+type PackageDoc string
+
+const (
+	// PackageDocRewrite replaces "Package main" at the start of the doc
+	// comment with the new package name.
+	PackageDocRewrite PackageDoc = "rewrite"
+	// PackageDocStrip removes the package doc comment, but keeps a
+	// copyright or license header written directly above the package
+	// clause.
+	PackageDocStrip PackageDoc = "strip"
+	// PackageDocKeep leaves the package doc comment unchanged.
+	PackageDocKeep PackageDoc = "keep"
+)
+
+// TODO investigate https://pkg.go.dev/golang.org/x/tools/go/ast/astutil#Apply
+
+// DefaultEntrypointName is the name main functions are renamed to unless
+// configured otherwise.
+const DefaultEntrypointName = "MainFunction"
+
+// generatedHeader marks output files as generated, following
+// https://golang.org/s/generatedcode.
+const generatedHeader = "// " + generatedNotice
+
+const generatedNotice = "Code generated by main-combiner; DO NOT EDIT."
+
+// sourceFile is a parsed Go source file.
+type sourceFile struct {
+	filename    string
+	fset        *token.FileSet
+	file        *ast.File
+	constraints []string
+	// preamble is the cgo preamble, see cgoPreamble
+	preamble string
+	// data is the original contents of the file
+	data []byte
+	// test is set for _test.go files
+	test bool
+}
+
+// parseFile reads name from fsys and parses it as filename, including
+// comments, so the result can be both inspected and rewritten.
+func parseFile(fsys fs.FS, name string, filename string) (*sourceFile, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	fset := token.NewFileSet()
+
+	fileAST, err := parser.ParseFile(fset, filename, data, parser.ParseComments)
+	if err != nil {
+		return nil, &ParseError{File: filename, Err: err}
+	}
+
+	return &sourceFile{
+		filename:    filename,
+		fset:        fset,
+		file:        fileAST,
+		data:        data,
+		constraints: buildConstraints(fileAST),
+		preamble:    cgoPreamble(fileAST),
+	}, nil
+}
+
+func (s *sourceFile) isMain() bool {
+	return s.file.Name.Name == "main"
+}
+
+// mainFunc returns the declaration of func main in src, or nil.
+func (s *sourceFile) mainFunc() *ast.FuncDecl {
+	for _, decl := range s.file.Decls {
+		if fd, ok := decl.(*ast.FuncDecl); ok && fd.Recv == nil && fd.Name.Name == "main" {
+			return fd
+		}
+	}
+
+	return nil
+}
+
+// checkMainFunc makes sure func main of src, which must declare it, can be
+// renamed and called by the dispatcher. A main without a body is
+// implemented in assembly or linked by name, which renaming breaks. With
+// allowContext set, main may take a context.Context.
+func (s *sourceFile) checkMainFunc(allowContext bool) error {
+	fd := s.mainFunc()
+	pos := s.fset.Position(fd.Pos())
+
+	if fd.Body == nil {
+		return fmt.Errorf("%s: func main has no body; commands implementing main in assembly or with go:linkname can't be combined", pos)
+	}
+
+	// type parameters sit between the name and the parameters; looking at
+	// the source keeps this working with go/ast versions without them
+	between := s.data[s.fset.Position(fd.Name.End()).Offset:s.fset.Position(fd.Type.Params.Opening).Offset]
+	if bytes.Contains(between, []byte("[")) {
+		return fmt.Errorf("%s: func main must have no type parameters", pos)
+	}
+
+	if allowContext && takesContext([]*sourceFile{s}) && fd.Type.Results.NumFields() == 0 {
+		return nil
+	}
+
+	if fd.Type.Params.NumFields() > 0 || fd.Type.Results.NumFields() > 0 {
+		return fmt.Errorf("%s: func main must have no arguments and no return values", pos)
+	}
+
+	return nil
+}
+
+// isGenerated reports whether src was written by the combiner.
+func (s *sourceFile) isGenerated() bool {
+	for _, cg := range s.file.Comments {
+		if cg.Pos() >= s.file.Package {
+			break
+		}
+
+		for _, c := range cg.List {
+			if c.Text == generatedHeader {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// rewrite transforms src in place.
+func (t *transform) rewrite(src *sourceFile) {
+	t.exitTrapped = false
+
+	src.file = astrewrite.Walk(src.file, t.visitor).(*ast.File)
+
+	if t.file == src.file && !t.keepMain {
+		renameMainRefs(src.file, t.entrypointName)
+	}
+
+	if t.exitTrapped {
+		astutil.AddNamedImport(src.fset, src.file, exitImportName, t.exitImportPath)
+	}
+}
+
+// renameMainRefs renames references to the package's main function in f,
+// such as a call from init, to name. Like prefixTopLevel, it relies on the
+// parser's resolution, so a local variable named main is left alone.
+func renameMainRefs(f *ast.File, name string) {
+	unresolved := make(map[*ast.Ident]bool)
+	for _, ident := range f.Unresolved {
+		unresolved[ident] = true
+	}
+
+	obj := f.Scope.Lookup("main")
+	if obj != nil && obj.Kind != ast.Fun {
+		return
+	}
+
+	ast.Inspect(f, func(n ast.Node) bool {
+		ident, ok := n.(*ast.Ident)
+		if !ok || ident.Name != "main" {
+			return true
+		}
+
+		if (obj != nil && ident.Obj == obj) || unresolved[ident] {
+			ident.Name = name
+		}
+
+		return true
+	})
+}
+
+// render formats src as a generated file.
+func render(src *sourceFile) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := format.Node(&buf, src.fset, src.file); err != nil {
+		return nil, &FormatError{File: src.filename, Err: err}
+	}
+
+	data, err := groupImports(src.filename, buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = addGeneratedHeader(src.filename, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkConstraints(src.filename, src.constraints, data); err != nil {
+		return nil, err
+	}
+
+	if err := checkPreamble(src.filename, src.preamble, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// addGeneratedHeader inserts generatedHeader in data, which must be
+// formatted source, after the comments leading the file other than the
+// package doc, such as build constraints and license headers.
+func addGeneratedHeader(filename string, data []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, data, parser.PackageClauseOnly|parser.ParseComments)
+	if err != nil {
+		return nil, &FormatError{File: filename, Source: data, Err: err}
+	}
+
+	offset := -1
+
+	for _, cg := range f.Comments {
+		if cg.Pos() >= f.Package || cg == f.Doc {
+			break
+		}
+
+		offset = fset.Position(cg.End()).Offset
+	}
+
+	var buf bytes.Buffer
+
+	if offset < 0 {
+		_, _ = buf.WriteString(generatedHeader + "\n\n")
+		_, _ = buf.Write(data)
+	} else {
+		_, _ = buf.Write(data[:offset])
+		_, _ = buf.WriteString("\n\n" + generatedHeader)
+		_, _ = buf.Write(data[offset:])
+	}
+
+	return buf.Bytes(), nil
+}
+
+// checkConstraints makes sure the build constraints of the original file
+// still appear above the package clause of the transformed source, so the
+// go tool applies them to the generated copy.
+func checkConstraints(filename string, want []string, data []byte) error {
+	if len(want) == 0 {
+		return nil
+	}
+
+	fset := token.NewFileSet()
+	newAST, err := parser.ParseFile(fset, filename, data, parser.PackageClauseOnly|parser.ParseComments)
+	if err != nil {
+		return fmt.Errorf("failed to parse new code for %s %w", filename, err)
+	}
+
+	got := buildConstraints(newAST)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		return fmt.Errorf("build constraints of %s were not preserved", filename)
+	}
+
+	return nil
+}
+
+// buildConstraints returns the //go:build and // +build lines that appear
+// before the package clause of f.
+func buildConstraints(f *ast.File) []string {
+	var lines []string
+
+	for _, cg := range f.Comments {
+		if cg.Pos() >= f.Package {
+			break
+		}
+
+		for _, c := range cg.List {
+			if isConstraint(c.Text) {
+				lines = append(lines, c.Text)
+			}
+		}
+	}
+
+	return lines
+}
+
+// cgoPreamble returns the comment immediately above import "C" in f, which
+// cgo compiles as C, or "" if f does not import "C".
+func cgoPreamble(f *ast.File) string {
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.IMPORT {
+			continue
+		}
+
+		for _, spec := range gd.Specs {
+			is := spec.(*ast.ImportSpec)
+			if importPath(is) != "C" {
+				continue
+			}
+
+			doc := is.Doc
+			if doc == nil && !gd.Lparen.IsValid() {
+				doc = gd.Doc
+			}
+
+			return doc.Text()
+		}
+	}
+
+	return ""
+}
+
+// checkPreamble makes sure the cgo preamble of the original file is still
+// attached to import "C" in the transformed source.
+func checkPreamble(filename string, want string, data []byte) error {
+	if want == "" {
+		return nil
+	}
+
+	fset := token.NewFileSet()
+	newAST, err := parser.ParseFile(fset, filename, data, parser.ImportsOnly|parser.ParseComments)
+	if err != nil {
+		return fmt.Errorf("failed to parse new code for %s %w", filename, err)
+	}
+
+	if cgoPreamble(newAST) != want {
+		return fmt.Errorf("cgo preamble of %s was not preserved", filename)
+	}
+
+	return nil
+}
+
+func isConstraint(comment string) bool {
+	return strings.HasPrefix(comment, "//go:build ") || strings.HasPrefix(comment, "// +build ")
+}
+
+type transform struct {
+	packageName    string
+	entrypointName string
+	trapExit       bool
+	exitImportPath string
+	packageDoc     PackageDoc
+	// keepMain leaves func main unrenamed, for a wrapper to call
+	keepMain bool
+
+	// file is the file being rewritten
+	file *ast.File
+	// exitTrapped is set when a call to os.Exit in file was replaced
+	exitTrapped bool
+}
+
+func (t *transform) visitor(n ast.Node) (ast.Node, bool) {
+	switch v := n.(type) {
+	case *ast.File:
+		return t.handleFile(v)
+	case *ast.FuncDecl:
+		return t.handleFuncDecl(v)
+	default:
+		return n, true
+	}
+}
+
+func (t *transform) handleFile(f *ast.File) (ast.Node, bool) {
+	if f.Name.Name != "main" {
+		return f, false
+	}
+
+	f.Name.Name = t.packageName
+	t.file = f
+
+	t.handlePackageDoc(f)
+
+	return f, true
+
+}
+
+// handlePackageDoc updates a "Package main" doc comment for the new
+// package name, or removes the package doc, according to t.packageDoc.
+func (t *transform) handlePackageDoc(f *ast.File) {
+	if f.Doc == nil {
+		return
+	}
+
+	switch t.packageDoc {
+	case PackageDocStrip:
+		// a license header written directly above the package clause is
+		// parsed as its doc, but must survive
+		if n := licenseLines(f.Doc); n > 0 {
+			f.Doc.List = f.Doc.List[:n]
+			return
+		}
+
+		for i, cg := range f.Comments {
+			if cg == f.Doc {
+				f.Comments = append(f.Comments[:i], f.Comments[i+1:]...)
+				break
+			}
+		}
+
+		f.Doc = nil
+	case PackageDocRewrite:
+		for _, c := range f.Doc.List {
+			for _, prefix := range []string{"// ", "//", "/* ", "/*"} {
+				if strings.HasPrefix(c.Text, prefix+"Package main") {
+					c.Text = prefix + "Package " + t.packageName + strings.TrimPrefix(c.Text, prefix+"Package main")
+					return
+				}
+			}
+		}
+	}
+}
+
+// licenseLines returns the number of comments at the start of doc that are
+// a copyright or license header, up to a "Package main" line, or 0 if doc
+// is not one.
+func licenseLines(doc *ast.CommentGroup) int {
+	text := doc.Text()
+	if !strings.Contains(text, "Copyright") && !strings.Contains(text, "SPDX-License-Identifier") {
+		return 0
+	}
+
+	for i, c := range doc.List {
+		for _, prefix := range []string{"// ", "//", "/* ", "/*"} {
+			if strings.HasPrefix(c.Text, prefix+"Package main") {
+				// drop the blank comment lines separating the two
+				for i > 0 && strings.TrimSpace(doc.List[i-1].Text) == "//" {
+					i--
+				}
+
+				return i
+			}
+		}
+	}
+
+	return len(doc.List)
+}
+
+func (t *transform) handleFuncDecl(fd *ast.FuncDecl) (ast.Node, bool) {
+	if fd.Recv != nil {
+		return fd, false
+	}
+
+	if fd.Name.Name != "main" {
+		return fd, false
+	}
+
+	if !t.keepMain {
+		fd.Name.Name = t.entrypointName
+	}
+
+	if t.trapExit && fd.Body != nil && trapExit(t.file, fd.Body) {
+		t.exitTrapped = true
+	}
+
+	return fd, false
+}
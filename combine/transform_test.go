@@ -0,0 +1,540 @@
+package combine
+
+import (
+	"errors"
+	"fmt"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestBuildConstraintsPreserved(t *testing.T) {
+	dir := newModule(t, map[string]string{
+		"cmd/plat/main_linux.go":   "//go:build linux\n// +build linux\n\n" + mainFile("linux"),
+		"cmd/plat/main_windows.go": "//go:build windows\n\n// Package main runs on windows.\n" + mainFile("windows"),
+	})
+
+	c := collected(t, dir)
+	buildOutput(t, c)
+
+	tests := []struct {
+		file string
+		goos string
+		want bool
+	}{
+		{file: "main_linux.go", goos: "linux", want: true},
+		{file: "main_linux.go", goos: "windows", want: false},
+		{file: "main_windows.go", goos: "windows", want: true},
+		{file: "main_windows.go", goos: "linux", want: false},
+	}
+
+	pkgDir := filepath.Join(c.outputDir, "cmd_plat")
+
+	for _, tt := range tests {
+		t.Run(tt.file+"/"+tt.goos, func(t *testing.T) {
+			ctx := build.Default
+			ctx.GOOS = tt.goos
+
+			got, err := ctx.MatchFile(pkgDir, tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Fatalf("expected match %v for GOOS %s, got %v", tt.want, tt.goos, got)
+			}
+		})
+	}
+}
+
+// generatedPattern is the convention for generated files, see
+// https://golang.org/s/generatedcode.
+var generatedPattern = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
+
+func TestGeneratedHeader(t *testing.T) {
+	dir := newModule(t, map[string]string{
+		"cmd/server/main.go":       "// Package main is the server.\n" + mainFile("server"),
+		"cmd/plat/main_linux.go":   "//go:build linux\n\n" + mainFile("linux"),
+		"cmd/plat/main_windows.go": "//go:build windows\n\n" + mainFile("windows"),
+	})
+
+	files := generate(t, dir)
+
+	for _, name := range []string{"main.go", "cmd_server/main.go", "cmd_plat/main_linux.go", "cmd_plat/main_windows.go"} {
+		t.Run(name, func(t *testing.T) {
+			data, ok := files[name]
+			if !ok {
+				t.Fatalf("%s was not generated", name)
+			}
+
+			f, err := parser.ParseFile(token.NewFileSet(), name, data, parser.ParseComments)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			header := false
+
+			for _, line := range strings.Split(string(data), "\n") {
+				if strings.HasPrefix(line, "package ") {
+					break
+				}
+
+				if generatedPattern.MatchString(line) {
+					header = true
+				}
+			}
+
+			if !header {
+				t.Fatalf("no generated header above the package clause of %s:\n%s", name, data)
+			}
+
+			if strings.HasPrefix(path.Base(name), "main_") && len(buildConstraints(f)) == 0 {
+				t.Fatalf("the header detached the build constraint of %s:\n%s", name, data)
+			}
+		})
+	}
+}
+
+func TestLicenseHeader(t *testing.T) {
+	const license = "// Copyright 2021 The Authors.\n//\n// Licensed under the Apache License, Version 2.0.\n"
+
+	tests := []struct {
+		name   string
+		source string
+		// want is the start of the transformed file
+		want string
+	}{
+		{
+			name:   "license",
+			source: license + "\n" + mainFile("server"),
+			want:   license + "\n" + generatedHeader + "\n\npackage cmd_server\n",
+		},
+		{
+			name:   "block comment",
+			source: "/*\n * Copyright 2021 The Authors.\n */\n\n" + mainFile("server"),
+			want:   "/*\n * Copyright 2021 The Authors.\n */\n\n" + generatedHeader + "\n\npackage cmd_server\n",
+		},
+		{
+			name:   "license and build constraint",
+			source: license + "\n//go:build !nope\n\n" + mainFile("server"),
+			want:   license + "\n//go:build !nope\n\n" + generatedHeader + "\n\npackage cmd_server\n",
+		},
+		{
+			name:   "license and package doc",
+			source: license + "\n// Package main is the server.\n" + mainFile("server"),
+			want:   license + "\n" + generatedHeader + "\n\n// Package cmd_server is the server.\npackage cmd_server\n",
+		},
+		{
+			name:   "package doc only",
+			source: "// Package main is the server.\n" + mainFile("server"),
+			want:   generatedHeader + "\n\n// Package cmd_server is the server.\npackage cmd_server\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{"cmd/server/main.go": tt.source})
+
+			c := collected(t, dir)
+
+			files, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if data := string(files["cmd_server/main.go"]); !strings.HasPrefix(data, tt.want) {
+				t.Fatalf("expected the transformed file to start with:\n%s\ngot:\n%s", tt.want, data)
+			}
+
+			if out, code := runBinary(t, buildBinary(t, c), "server"); code != 0 || out != "server\n" {
+				t.Fatalf("expected server to print its name, got %q and exit code %d", out, code)
+			}
+
+			// the output is recognized as generated when it is read back
+			if !isGeneratedFile(filepath.Join(c.outputDir, "cmd_server", "main.go")) {
+				t.Fatal("expected the transformed file to be recognized as generated")
+			}
+		})
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		main   bool
+		err    bool
+	}{
+		{name: "main", source: mainFile("server"), main: true},
+		{name: "library", source: "package lib\n\nfunc Lib() {}\n"},
+		{name: "helper", source: "package main\n\nfunc helper() {}\n", main: true},
+		{name: "broken", source: "package main\n\nfunc main( {\n", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := fstest.MapFS{"file.go": {Data: []byte(tt.source)}}
+
+			src, err := parseFile(fsys, "file.go", "/src/file.go")
+			if tt.err {
+				var parseErr *ParseError
+				if !errors.As(err, &parseErr) || parseErr.File != "/src/file.go" {
+					t.Fatalf("expected a *ParseError for /src/file.go, got %v", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if src.isMain() != tt.main {
+				t.Fatalf("expected isMain %v, got %v", tt.main, src.isMain())
+			}
+
+			if string(src.data) != tt.source {
+				t.Fatal("the contents of the file were not kept")
+			}
+		})
+	}
+}
+
+func BenchmarkParseFile(b *testing.B) {
+	var source strings.Builder
+	_, _ = source.WriteString(mainFile("server"))
+
+	for i := 0; i < 500; i++ {
+		_, _ = fmt.Fprintf(&source, "\n// helper%d upper cases s.\nfunc helper%d(s string) string {\n\treturn strings.ToUpper(s)\n}\n", i, i)
+	}
+
+	fsys := fstest.MapFS{"main.go": {Data: []byte(source.String())}}
+
+	b.SetBytes(int64(source.Len()))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		src, err := parseFile(fsys, "main.go", "main.go")
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		if !src.isMain() || src.mainFunc() == nil {
+			b.Fatal("expected a main package with func main")
+		}
+	}
+}
+
+func TestEntrypointName(t *testing.T) {
+	tests := []struct {
+		name       string
+		entrypoint string
+		newErr     string
+		collectErr string
+	}{
+		{name: "clash", entrypoint: DefaultEntrypointName, collectErr: "MainFunction is already declared; choose another entrypoint name"},
+		{name: "configured", entrypoint: "CombinedMain"},
+		{name: "unexported", entrypoint: "combinedMain", newErr: `entrypoint name "combinedMain" must be an exported Go identifier`},
+		{name: "not an identifier", entrypoint: "Combined-Main", newErr: `entrypoint name "Combined-Main" must be an exported Go identifier`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go": `package main
+
+import "fmt"
+
+// MainFunction is the command's own function of that name.
+func MainFunction() string {
+	return "server"
+}
+
+func main() {
+	fmt.Println(MainFunction())
+}
+`,
+			})
+
+			c, err := New(dir, "cmd/combined", WithEntrypointName(tt.entrypoint))
+			if tt.newErr != "" {
+				if err == nil || err.Error() != tt.newErr {
+					t.Fatalf("expected error %q, got %v", tt.newErr, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = c.Collect()
+			if tt.collectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.collectErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.collectErr, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if out, code := runBinary(t, buildBinary(t, c), "server"); code != 0 || out != "server\n" {
+				t.Fatalf("expected server to print its name, got %q and exit code %d", out, code)
+			}
+		})
+	}
+}
+
+func TestPackageDoc(t *testing.T) {
+	tests := []struct {
+		mode PackageDoc
+		doc  string
+	}{
+		{mode: PackageDocRewrite, doc: "Package cmd_server serves requests.\n\nIt listens on :8080.\n"},
+		{mode: PackageDocStrip, doc: ""},
+		{mode: PackageDocKeep, doc: "Package main serves requests.\n\nIt listens on :8080.\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go": "// Package main serves requests.\n//\n// It listens on :8080.\n" + mainFile("server"),
+			})
+
+			c := collected(t, dir, WithPackageDoc(tt.mode))
+
+			files, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := parser.ParseFile(token.NewFileSet(), "main.go", files["cmd_server/main.go"], parser.ParseComments|parser.PackageClauseOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := f.Doc.Text(); got != tt.doc {
+				t.Fatalf("expected package doc %q, got %q", tt.doc, got)
+			}
+
+			buildOutput(t, c)
+		})
+	}
+}
+
+func TestCgoPreamble(t *testing.T) {
+	preamble := "// #include <stdlib.h>\n//\n// static int answer(void) { return 42; }\n"
+
+	tests := []struct {
+		name   string
+		source string
+	}{
+		{
+			name:   "single import",
+			source: "package main\n\n" + preamble + "import \"C\"\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(C.answer())\n}\n",
+		},
+		{
+			name:   "import block",
+			source: "package main\n\nimport (\n\t\"fmt\"\n)\n\n" + preamble + "import \"C\"\n\nfunc main() {\n\tfmt.Println(C.answer())\n}\n",
+		},
+		{
+			name:   "grouped",
+			source: "package main\n\nimport (\n\t\"fmt\"\n\n\t" + strings.ReplaceAll(preamble, "\n", "\n\t") + "\"C\"\n)\n\nfunc main() {\n\tfmt.Println(C.answer())\n}\n",
+		},
+		{
+			name:   "package doc",
+			source: "// Package main prints the answer.\npackage main\n\n" + preamble + "import \"C\"\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(C.answer())\n}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{"cmd/answer/main.go": tt.source})
+
+			c := collected(t, dir)
+
+			files, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := parser.ParseFile(token.NewFileSet(), "main.go", files["cmd_answer/main.go"], parser.ImportsOnly|parser.ParseComments)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := "#include <stdlib.h>\n\nstatic int answer(void) { return 42; }\n"
+			if got := cgoPreamble(f); got != want {
+				t.Fatalf("expected cgo preamble %q, got %q:\n%s", want, got, files["cmd_answer/main.go"])
+			}
+
+			if _, err := exec.LookPath("gcc"); err != nil {
+				t.Skip("no C compiler to build the output with")
+			}
+
+			if out, code := runBinary(t, buildBinary(t, c), "answer"); code != 0 || out != "42\n" {
+				t.Fatalf("expected answer to print 42, got %q and exit code %d", out, code)
+			}
+		})
+	}
+}
+
+func TestMainReferences(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		out     string
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "recursive",
+			files: map[string]string{
+				"main.go": "package main\n\nimport \"fmt\"\n\nvar depth int\n\nfunc main() {\n\tdepth++\n\tif depth < 3 {\n\t\tmain()\n\t\treturn\n\t}\n\n\tfmt.Println(\"depth\", depth)\n}\n",
+			},
+			out:  "depth 3\n",
+			want: []string{"\t\tMainFunction()\n"},
+		},
+		{
+			name: "from init in another file",
+			files: map[string]string{
+				"main.go": mainFile("server"),
+				"init.go": "package main\n\nimport \"os\"\n\nvar entry = main\n\nfunc init() {\n\tif os.Getenv(\"SERVER_AT_INIT\") != \"\" {\n\t\tmain()\n\t}\n}\n",
+			},
+			out:  "server\n",
+			want: []string{"var entry = MainFunction\n", "\t\tMainFunction()\n"},
+		},
+		{
+			name: "shadowed by a local variable",
+			files: map[string]string{
+				"main.go": "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tmain := \"local\"\n\tfmt.Println(main)\n}\n",
+			},
+			out:     "local\n",
+			want:    []string{"\tmain := \"local\"\n\tfmt.Println(main)\n"},
+			notWant: []string{"MainFunction :=", "Println(MainFunction)"},
+		},
+		{
+			name: "shadowed by a parameter",
+			files: map[string]string{
+				"main.go": "package main\n\nimport \"fmt\"\n\nfunc show(main string) {\n\tfmt.Println(main)\n}\n\nfunc main() {\n\tshow(\"param\")\n}\n",
+			},
+			out:  "param\n",
+			want: []string{"func show(main string) {\n\tfmt.Println(main)\n}\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := make(map[string]string)
+			for name, data := range tt.files {
+				files["cmd/server/"+name] = data
+			}
+
+			dir := newModule(t, files)
+
+			c := collected(t, dir)
+
+			generated, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var all strings.Builder
+			for name := range tt.files {
+				_, _ = all.Write(generated["cmd_server/"+name])
+			}
+
+			for _, want := range tt.want {
+				if !strings.Contains(all.String(), want) {
+					t.Fatalf("expected %q in the output:\n%s", want, all.String())
+				}
+			}
+
+			for _, notWant := range tt.notWant {
+				if strings.Contains(all.String(), notWant) {
+					t.Fatalf("unexpected %q in the output:\n%s", notWant, all.String())
+				}
+			}
+
+			if out, code := runBinary(t, buildBinary(t, c), "server"); code != 0 || out != tt.out {
+				t.Fatalf("expected server to print %q, got %q and exit code %d", tt.out, out, code)
+			}
+		})
+	}
+}
+
+func TestUnusualMainFunc(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		err   string
+	}{
+		{
+			name: "assembly",
+			files: map[string]string{
+				"cmd/tool/main.go":      "package main\n\n// main is implemented in main_amd64.s.\nfunc main()\n",
+				"cmd/tool/main_amd64.s": "#include \"textflag.h\"\n\nTEXT ·main(SB),NOSPLIT,$0-0\n\tRET\n",
+			},
+			err: "main.go:4:1: func main has no body; commands implementing main in assembly or with go:linkname can't be combined",
+		},
+		{
+			name: "linkname",
+			files: map[string]string{
+				"cmd/tool/main.go": "package main\n\nimport _ \"unsafe\"\n\n//go:linkname main example.com/fx/internal/impl.Main\nfunc main()\n",
+			},
+			err: "main.go:6:1: func main has no body",
+		},
+		{
+			name:  "type parameters",
+			files: map[string]string{"cmd/tool/main.go": "package main\n\nfunc main[T any]() {}\n"},
+			err:   "main.go:3:1: func main must have no type parameters",
+		},
+		{
+			name: "method called main",
+			files: map[string]string{
+				"cmd/tool/main.go": "package main\n\nimport \"fmt\"\n\ntype app struct{}\n\nfunc (app) main() {\n\tfmt.Println(\"tool\")\n}\n\nfunc main() {\n\tapp{}.main()\n}\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{"cmd/server/main.go": mainFile("server")}
+			for name, data := range tt.files {
+				files[name] = data
+			}
+
+			dir := newModule(t, files)
+
+			c := newCombiner(t, dir)
+
+			err := c.Collect()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), filepath.Join(dir, "cmd", "tool", tt.err)) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if out, code := runBinary(t, buildBinary(t, c), "tool"); code != 0 || out != "tool\n" {
+				t.Fatalf("expected tool to print its name, got %q and exit code %d", out, code)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package combine
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// DefaultGoBinary is the go command used by Verify unless WithGoBinary is
+// given.
+const DefaultGoBinary = "go"
+
+// Verify builds the written output directory with the go command, which
+// catches generated code that does not compile. Nothing is kept.
+func (c *Combiner) Verify() error {
+	args := []string{"build", "-o", os.DevNull}
+	if tags := c.goBuildTags(); len(tags) > 0 {
+		args = append(args, "-tags", strings.Join(tags, ","))
+	}
+
+	// grouped commands have a dispatcher in each group's directory
+	pattern := "."
+	if c.groupBy > 0 {
+		pattern = "./..."
+	}
+
+	cmd := exec.Command(c.goBinary, append(args, pattern)...)
+	cmd.Dir = c.outputDir
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	c.logf(1, "verifying %s", c.outputDir)
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to build %s: %w\n%s", c.outputDir, err, strings.TrimSpace(out.String()))
+	}
+
+	return nil
+}
+
+// goBuildTags returns the tags to build the output with: those the sources
+// were collected for, and the standalone tag. They are sorted.
+func (c *Combiner) goBuildTags() []string {
+	var tags []string
+	for tag := range c.buildTags {
+		tags = append(tags, tag)
+	}
+
+	if c.standaloneTag != "" {
+		tags = append(tags, c.standaloneTag)
+	}
+
+	sort.Strings(tags)
+
+	return tags
+}
@@ -0,0 +1,65 @@
+package combine
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	goBinary, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go is not installed")
+	}
+
+	tests := []struct {
+		name    string
+		options []Option
+		breaks  string
+		err     string
+	}{
+		{name: "good"},
+		{name: "configured go binary", options: []Option{WithGoBinary(goBinary)}},
+		{name: "grouped", options: []Option{WithGroupBy(1)}},
+		{name: "build tags", options: []Option{WithBuildTags("debug")}},
+		{name: "broken output", breaks: "cmd_server/main.go", err: "undefined: undefined"},
+		{name: "missing go binary", options: []Option{WithGoBinary(filepath.Join(t.TempDir(), "go"))}, err: "failed to build "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go":  mainFile("server"),
+				"cmd/server/debug.go": "//go:build debug\n\npackage main\n\nvar debug = true\n\nfunc init() {\n\t_ = debug\n}\n",
+				"tools/lint/main.go":  mainFile("lint"),
+			})
+
+			c := collected(t, dir, tt.options...)
+			if err := c.Write(); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.breaks != "" {
+				filename := filepath.Join(c.outputDir, filepath.FromSlash(tt.breaks))
+				if err := ioutil.WriteFile(filename, []byte("package cmd_server\n\nfunc MainFunction() {\n\tundefined()\n}\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := c.Verify()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package combine
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"path"
+	"strconv"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// versionPackage is the name of the generated package holding the version
+// shared by every command. Its Value is set with
+// -ldflags "-X <output import path>/version.Value=..."
+const versionPackage = "version"
+
+const versionImportName = "combinedversion"
+
+// VersionSymbol returns the symbol to pass to -ldflags -X to set the
+// version of every combined command.
+func (c *Combiner) VersionSymbol() string {
+	return c.versionImportPath() + ".Value"
+}
+
+func (c *Combiner) versionImportPath() string {
+	return path.Join(c.outputImportPath(), versionPackage)
+}
+
+func versionSource() []byte {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString(generatedHeader + "\n\n")
+	_, _ = buf.WriteString(`// Package version holds the version shared by all combined commands.
+package version
+
+// Value is set at link time with -ldflags "-X <this package>.Value=...".
+var Value string
+
+// Or returns Value, or def if Value was not set.
+func Or(def string) string {
+	if Value != "" {
+		return Value
+	}
+
+	return def
+}
+`)
+
+	return buf.Bytes()
+}
+
+// injectVersion initializes the top-level string variable called name in src
+// from the shared version package, keeping any existing value as the
+// default. It reports whether the variable was found.
+func injectVersion(src *sourceFile, name string, importPath string) (bool, error) {
+	found := false
+
+	for _, decl := range src.file.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.VAR {
+			continue
+		}
+
+		for _, spec := range gd.Specs {
+			vs := spec.(*ast.ValueSpec)
+
+			for _, ident := range vs.Names {
+				if ident.Name != name {
+					continue
+				}
+
+				if len(vs.Names) != 1 {
+					return false, fmt.Errorf("%s: version variable %s must be declared on its own", src.fset.Position(ident.Pos()), name)
+				}
+
+				var def ast.Expr = &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote("")}
+				if len(vs.Values) == 1 {
+					def = vs.Values[0]
+				}
+
+				vs.Values = []ast.Expr{
+					&ast.CallExpr{
+						Fun: &ast.SelectorExpr{
+							X:   ast.NewIdent(versionImportName),
+							Sel: ast.NewIdent("Or"),
+						},
+						Args: []ast.Expr{def},
+					},
+				}
+
+				found = true
+			}
+		}
+	}
+
+	if found {
+		astutil.AddNamedImport(src.fset, src.file, versionImportName, importPath)
+	}
+
+	return found, nil
+}
@@ -0,0 +1,62 @@
+package combine
+
+import "testing"
+
+func TestVersionVar(t *testing.T) {
+	dir := newModule(t, map[string]string{
+		"cmd/server/main.go": `package main
+
+import "fmt"
+
+var version = "dev"
+
+func main() {
+	fmt.Println("server", version)
+}
+`,
+		"cmd/worker/main.go": `package main
+
+import "fmt"
+
+var version string
+
+func main() {
+	fmt.Println("worker", version)
+}
+`,
+	})
+
+	c := collected(t, dir, WithVersionVar("version"))
+
+	if got, want := c.VersionSymbol(), testModule+"/cmd/combined/version.Value"; got != want {
+		t.Fatalf("expected version symbol %s, got %s", want, got)
+	}
+
+	tests := []struct {
+		name  string
+		flags []string
+		want  map[string]string
+	}{
+		{
+			name: "unset",
+			want: map[string]string{"server": "server dev\n", "worker": "worker \n"},
+		},
+		{
+			name:  "ldflags",
+			flags: []string{"-ldflags", "-X " + c.VersionSymbol() + "=1.2.3"},
+			want:  map[string]string{"server": "server 1.2.3\n", "worker": "worker 1.2.3\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			binary := buildBinary(t, c, tt.flags...)
+
+			for command, want := range tt.want {
+				if out, code := runBinary(t, binary, command); code != 0 || out != want {
+					t.Errorf("expected %s to print %q, got %q and exit code %d", command, want, out, code)
+				}
+			}
+		})
+	}
+}
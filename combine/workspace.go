@@ -0,0 +1,100 @@
+package combine
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/mod/modfile"
+)
+
+const goWorkName = "go.work"
+
+// workspace is the go.work of the service directory.
+type workspace struct {
+	// dirs are the absolute directories of the modules in the workspace
+	dirs      []string
+	goVersion string
+}
+
+// readWorkspace returns the go.work at the root of in, or nil if there is
+// none.
+func readWorkspace(in *input) (*workspace, error) {
+	filename := filepath.Join(in.dir, goWorkName)
+
+	data, err := fs.ReadFile(in.fsys, goWorkName)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	f, err := modfile.ParseWork(filename, data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+
+	w := &workspace{}
+	if f.Go != nil {
+		w.goVersion = f.Go.Version
+	}
+
+	for _, use := range f.Use {
+		dir := filepath.FromSlash(use.Path)
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(in.dir, dir)
+		}
+
+		w.dirs = append(w.dirs, filepath.Clean(dir))
+	}
+
+	return w, nil
+}
+
+// workspaceInput returns the input for the workspace module in dir. Modules
+// are read from the file system of root, the service directory, unless that
+// is the operating system's.
+func workspaceInput(root *input, dir string) (*input, error) {
+	in := &input{dir: dir}
+
+	if root.os {
+		in.fsys = os.DirFS(dir)
+		in.os = true
+	} else {
+		name, ok := relativeInside(root.dir, dir)
+		if !ok {
+			return nil, fmt.Errorf("workspace module %s is outside the service directory", dir)
+		}
+
+		fsys, err := fs.Sub(root.fsys, name)
+		if err != nil {
+			return nil, err
+		}
+
+		in.fsys = fsys
+	}
+
+	module, err := getModuleName(in.fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get module name: %w", err)
+	}
+
+	in.module = module
+
+	return in, nil
+}
+
+// inputAt returns the input for dir, or nil if it is not one.
+func (c *Combiner) inputAt(dir string) *input {
+	for _, in := range c.inputs {
+		if in.dir == dir {
+			return in
+		}
+	}
+
+	return nil
+}
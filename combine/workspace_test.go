@@ -0,0 +1,129 @@
+package combine
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestWorkspace(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  map[string]string
+		output string
+		// imports are qualified with the module path outside the
+		// service directory's own module
+		imports  []string
+		commands []string
+	}{
+		{
+			name: "modules only",
+			files: map[string]string{
+				"go.work":                   "go 1.18\n\nuse (\n\t./api\n\t./worker\n\t./combined\n)\n",
+				"api/go.mod":                "module example.com/api\n\ngo 1.16\n",
+				"api/cmd/server/main.go":    mainFile("server"),
+				"worker/go.mod":             "module example.com/worker\n\ngo 1.16\n",
+				"worker/cmd/worker/main.go": mainFile("worker"),
+				"combined/go.mod":           "module example.com/combined\n\ngo 1.16\n",
+			},
+			output:   "combined",
+			imports:  []string{"example.com/combined/example_com_api_cmd_server", "example.com/combined/example_com_worker_cmd_worker"},
+			commands: []string{"server", "worker"},
+		},
+		{
+			name: "root module",
+			files: map[string]string{
+				"go.work":                   "go 1.18\n\nuse (\n\t.\n\t./worker\n)\n",
+				"go.mod":                    "module " + testModule + "\n\ngo 1.16\n",
+				"cmd/server/main.go":        mainFile("server"),
+				"worker/go.mod":             "module example.com/worker\n\ngo 1.16\n",
+				"worker/cmd/worker/main.go": mainFile("worker"),
+			},
+			output:   "cmd/combined",
+			imports:  []string{testModule + "/cmd/combined/cmd_server", testModule + "/cmd/combined/example_com_worker_cmd_worker"},
+			commands: []string{"server", "worker"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+
+			c, err := New(dir, tt.output)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := c.Collect(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := commandNames(c); !reflect.DeepEqual(got, tt.commands) {
+				t.Fatalf("expected commands %v, got %v", tt.commands, got)
+			}
+
+			generated, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, want := range tt.imports {
+				if !strings.Contains(string(generated["main.go"]), strconv.Quote(want)) {
+					t.Fatalf("expected the dispatcher to import %s:\n%s", want, generated["main.go"])
+				}
+			}
+
+			// the output is built in the workspace, which rejects -mod=mod
+			t.Setenv("GOFLAGS", "")
+
+			binary := buildBinary(t, c)
+
+			for _, name := range tt.commands {
+				if out, code := runBinary(t, binary, name); code != 0 || out != name+"\n" {
+					t.Fatalf("expected %s to print its name, got %q and exit code %d", name, out, code)
+				}
+			}
+		})
+	}
+
+	t.Run("file system", func(t *testing.T) {
+		fsys := fstest.MapFS{}
+		for name, data := range tests[0].files {
+			fsys[name] = &fstest.MapFile{Data: []byte(data)}
+		}
+
+		c, err := New("/virtual/root", "combined", WithFS(fsys))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		commands, err := c.Discover()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var keys []string
+		for _, command := range commands {
+			keys = append(keys, command.Key)
+		}
+
+		sort.Strings(keys)
+
+		if want := []string{"example.com/api/cmd/server", "example.com/worker/cmd/worker"}; !reflect.DeepEqual(keys, want) {
+			t.Fatalf("expected keys %v, got %v", want, keys)
+		}
+	})
+
+	t.Run("module outside the service directory", func(t *testing.T) {
+		fsys := fstest.MapFS{"go.work": {Data: []byte("go 1.18\n\nuse ../api\n")}}
+
+		_, err := New("/virtual/root", "combined", WithFS(fsys), WithImportPrefix("example.com/combined"))
+		if err == nil || !strings.Contains(err.Error(), "is outside the service directory") {
+			t.Fatalf("expected an error about the module outside the service directory, got %v", err)
+		}
+	})
+}
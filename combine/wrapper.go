@@ -0,0 +1,36 @@
+package combine
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// wrapperName is the file added to every package in wrapper mode to call
+// its func main from the entrypoint.
+const wrapperName = "combined_main.go"
+
+// wrapperSource returns the file that provides the entrypoint of m, which
+// keeps its func main in wrapper mode.
+func (c *Combiner) wrapperSource(m *MainPackage) []byte {
+	var buf bytes.Buffer
+	if c.standaloneTag != "" {
+		_, _ = fmt.Fprintf(&buf, "//go:build %s\n// +build %s\n\n", c.standaloneTag, c.standaloneTag)
+	}
+
+	_, _ = buf.WriteString(generatedHeader + "\n\n")
+
+	signature, call, imports := "", "main()", ""
+	if m.context {
+		signature, call, imports = "ctx context.Context", "main(ctx)", "import \"context\"\n\n"
+	}
+
+	_, _ = fmt.Fprintf(&buf, `package %s
+
+%s// %s runs the command.
+func %s(%s) {
+	%s
+}
+`, m.PackageName, imports, c.entrypointName, c.entrypointName, signature, call)
+
+	return buf.Bytes()
+}
@@ -0,0 +1,153 @@
+package combine
+
+import (
+	"go/build"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWrapper(t *testing.T) {
+	contextMain := "package main\n\nimport (\n\t\"context\"\n\t\"fmt\"\n)\n\nfunc main(ctx context.Context) {\n\tfmt.Println(\"worker\", ctx.Err())\n}\n"
+
+	tests := []struct {
+		name    string
+		options []Option
+		tag     string
+		// worker replaces the source of the worker command
+		worker string
+		// files are the generated files of cmd_server and the build tag
+		// that selects each, "" for none, and "!" + tag for the standalone
+		// build
+		files map[string]string
+		// contains are substrings of the generated files
+		contains map[string]string
+		out      map[string]string
+	}{
+		{
+			name: "plain",
+			files: map[string]string{
+				"cmd_server/main.go":          "",
+				"cmd_server/helper.go":        "",
+				"cmd_server/combined_main.go": "",
+			},
+			contains: map[string]string{
+				"cmd_server/main.go":          "\nfunc main() {\n",
+				"cmd_server/combined_main.go": "func MainFunction() {\n\tmain()\n}\n",
+			},
+			out: map[string]string{"server": "server\n", "worker": "worker\n"},
+		},
+		{
+			name:    "standalone tag",
+			options: []Option{WithStandaloneTag("combined")},
+			tag:     "combined",
+			files: map[string]string{
+				"cmd_server/main.go":              "combined",
+				"cmd_server/helper.go":            "combined",
+				"cmd_server/combined_main.go":     "combined",
+				"cmd_server/standalone_main.go":   "!combined",
+				"cmd_server/standalone_helper.go": "!combined",
+			},
+			contains: map[string]string{
+				"cmd_server/combined_main.go":   "//go:build combined\n",
+				"cmd_server/standalone_main.go": "package main\n",
+			},
+			out: map[string]string{"server": "server\n", "worker": "worker\n"},
+		},
+		{
+			name:    "context",
+			options: []Option{WithContextEntrypoint(true)},
+			worker:  contextMain,
+			files: map[string]string{
+				"cmd_server/main.go":          "",
+				"cmd_server/combined_main.go": "",
+			},
+			contains: map[string]string{
+				"cmd_worker/main.go":          "\nfunc main(ctx context.Context) {\n",
+				"cmd_worker/combined_main.go": "func MainFunction(ctx context.Context) {\n\tmain(ctx)\n}\n",
+			},
+			out: map[string]string{"server": "server\n", "worker": "worker <nil>\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			worker := tt.worker
+			if worker == "" {
+				worker = mainFile("worker")
+			}
+
+			dir := newModule(t, map[string]string{
+				"cmd/server/main.go":   "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(name())\n}\n",
+				"cmd/server/helper.go": "package main\n\nfunc name() string {\n\treturn \"server\"\n}\n",
+				"cmd/worker/main.go":   worker,
+			})
+
+			c := collected(t, dir, append([]Option{WithWrapper(true)}, tt.options...)...)
+
+			generated, err := c.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for name, want := range tt.contains {
+				if !strings.Contains(string(generated[name]), want) {
+					t.Fatalf("expected %q in %s:\n%s", want, name, generated[name])
+				}
+			}
+
+			var flags []string
+			if tt.tag != "" {
+				flags = []string{"-tags", tt.tag}
+			}
+
+			binary := buildBinary(t, c, flags...)
+
+			for name, want := range tt.out {
+				if out, code := runBinary(t, binary, name); code != 0 || out != want {
+					t.Fatalf("expected %s to print %q, got %q and exit code %d", name, want, out, code)
+				}
+			}
+
+			// each file is in the build it is meant for
+			for name, tag := range tt.files {
+				if _, ok := generated[name]; !ok {
+					t.Fatalf("%s was not generated", name)
+				}
+
+				for _, tags := range [][]string{nil, {tt.tag}} {
+					ctx := build.Default
+					ctx.BuildTags = tags
+
+					got, err := ctx.MatchFile(c.outputDir, filepath.FromSlash(name))
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					want := tag == "" || (tags == nil) == strings.HasPrefix(tag, "!")
+					if got != want {
+						t.Fatalf("expected %s to be built with tags %v: %v, got %v", name, tags, want, got)
+					}
+				}
+			}
+
+			if tt.tag == "" {
+				return
+			}
+
+			// without the tag the package is the original command
+			cmd := exec.Command("go", "run", ".")
+			cmd.Dir = filepath.Join(c.outputDir, "cmd_server")
+
+			out, err := cmd.CombinedOutput()
+			if err != nil {
+				t.Fatalf("failed to run cmd_server on its own: %v\n%s", err, out)
+			}
+
+			if string(out) != "server\n" {
+				t.Fatalf("expected cmd_server on its own to print server, got %q", out)
+			}
+		})
+	}
+}
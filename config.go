@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v2"
+)
+
+const configName = ".main-combiner.yaml"
+
+// withConfig returns args with flags read from a config file prepended. The
+// file is a YAML mapping from flag names to values, read from --config or
+// from .main-combiner.yaml in the input directory. Flags given in args take
+// precedence: a flag set on the command line is never read from the file.
+// A relative input in the file is relative to the file's directory, and a
+// counter such as verbose is given as a count.
+func withConfig(app *kingpin.Application, args []string) ([]string, error) {
+	ctx, err := app.ParseContext(args)
+	if err != nil {
+		// let the real parse report the problem
+		return args, nil
+	}
+
+	set := make(map[string]string)
+
+	for _, el := range ctx.Elements {
+		f, ok := el.Clause.(*kingpin.FlagClause)
+		if !ok {
+			continue
+		}
+
+		value := ""
+		if el.Value != nil {
+			value = *el.Value
+		}
+
+		// keep the first value of repeated flags, e.g. the primary input
+		if _, ok := set[f.Model().Name]; !ok {
+			set[f.Model().Name] = value
+		}
+	}
+
+	filename, explicit := set["config"]
+	if !explicit {
+		input, ok := set["input"]
+		if !ok {
+			input = "."
+		}
+
+		filename = filepath.Join(input, configName)
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) && !explicit {
+			return args, nil
+		}
+
+		return nil, err
+	}
+
+	var config map[string]interface{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+
+	var names []string
+	for name := range config {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var configArgs []string
+
+	for _, name := range names {
+		if name == "config" || app.GetFlag(name) == nil {
+			return nil, fmt.Errorf("unknown option %q in %s", name, filename)
+		}
+
+		if _, ok := set[name]; ok {
+			continue
+		}
+
+		value := config[name]
+
+		if name == "input" {
+			value = relativeInput(filepath.Dir(filename), value)
+		}
+
+		convert := configFlag
+		if isCounter(app.GetFlag(name)) {
+			convert = counterFlag
+		}
+
+		flagArgs, err := convert(name, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid option %q in %s: %w", name, filename, err)
+		}
+
+		configArgs = append(configArgs, flagArgs...)
+	}
+
+	return append(configArgs, args...), nil
+}
+
+// configFlag converts a config file value into command line flags.
+func configFlag(name string, value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return []string{"--" + name}, nil
+		}
+
+		return []string{"--no-" + name}, nil
+	case string, int, float64:
+		return []string{fmt.Sprintf("--%s=%v", name, v)}, nil
+	case []interface{}:
+		var args []string
+
+		for _, item := range v {
+			itemArgs, err := configFlag(name, item)
+			if err != nil {
+				return nil, err
+			}
+
+			args = append(args, itemArgs...)
+		}
+
+		return args, nil
+	default:
+		return nil, fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+// relativeInput resolves the input value of a config file in dir, a path
+// or a list of paths, relative to dir.
+func relativeInput(dir string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if !filepath.IsAbs(v) {
+			return filepath.Join(dir, v)
+		}
+	case []interface{}:
+		inputs := make([]interface{}, len(v))
+		for i, item := range v {
+			inputs[i] = relativeInput(dir, item)
+		}
+
+		return inputs
+	}
+
+	return value
+}
+
+// isCounter reports whether f counts how often it is given, like --verbose,
+// rather than taking a value.
+func isCounter(f *kingpin.FlagClause) bool {
+	v, ok := f.Model().Value.(interface {
+		IsBoolFlag() bool
+		IsCumulative() bool
+	})
+
+	return ok && v.IsBoolFlag() && v.IsCumulative()
+}
+
+// counterFlag converts a config file value of a counter flag, a count or a
+// bool, into the flag repeated that many times.
+func counterFlag(name string, value interface{}) ([]string, error) {
+	count := 0
+
+	switch v := value.(type) {
+	case int:
+		count = v
+	case bool:
+		if v {
+			count = 1
+		}
+	default:
+		return nil, fmt.Errorf("unsupported value %v, expected a count", value)
+	}
+
+	if count < 0 {
+		return nil, fmt.Errorf("count %d must not be negative", count)
+	}
+
+	var args []string
+	for i := 0; i < count; i++ {
+		args = append(args, "--"+name)
+	}
+
+	return args, nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// testFlags are the flags of testApp.
+type testFlags struct {
+	inputs     *[]string
+	output     *string
+	include    *[]string
+	allowEmpty *bool
+	verbose    *int
+}
+
+// testApp returns an application with a few of the flags of main-combiner.
+func testApp() (*kingpin.Application, *testFlags) {
+	app := kingpin.New("main-combiner", "")
+	app.Flag("config", "").String()
+
+	return app, &testFlags{
+		inputs:     app.Flag("input", "").Default(".").ExistingDirs(),
+		output:     app.Flag("output", "").Default("cmd/combined").String(),
+		include:    app.Flag("include", "").Strings(),
+		allowEmpty: app.Flag("allow-empty", "").Bool(),
+		verbose:    app.Flag("verbose", "").Short('v').Counter(),
+	}
+}
+
+// parseWithConfig parses the args returned for a directory holding a config
+// file containing config.
+func parseWithConfig(t *testing.T, config string, args func(dir string) []string) *testFlags {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, configName), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	app, flags := testApp()
+
+	withArgs, err := withConfig(app, args(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := app.Parse(withArgs); err != nil {
+		t.Fatalf("failed to parse %v: %s", withArgs, err)
+	}
+
+	return flags
+}
+
+func TestConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		args    []string
+		output  string
+		include []string
+		empty   bool
+	}{
+		{
+			name:    "file",
+			config:  "output: out\ninclude: [cmd, tools]\nallow-empty: true\n",
+			output:  "out",
+			include: []string{"cmd", "tools"},
+			empty:   true,
+		},
+		{
+			name:    "flags take precedence",
+			config:  "output: out\ninclude: [cmd, tools]\nallow-empty: true\n",
+			args:    []string{"--output=cli", "--include=pkg", "--no-allow-empty"},
+			output:  "cli",
+			include: []string{"pkg"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := parseWithConfig(t, tt.config, func(dir string) []string {
+				return append([]string{"--input", dir}, tt.args...)
+			})
+
+			if *flags.output != tt.output {
+				t.Errorf("expected output %q, got %q", tt.output, *flags.output)
+			}
+
+			if !reflect.DeepEqual(*flags.include, tt.include) {
+				t.Errorf("expected includes %v, got %v", tt.include, *flags.include)
+			}
+
+			if *flags.allowEmpty != tt.empty {
+				t.Errorf("expected allow-empty %v, got %v", tt.empty, *flags.allowEmpty)
+			}
+		})
+	}
+}
+
+func TestConfigFileCounter(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		args    []string
+		verbose int
+	}{
+		{name: "count", config: "verbose: 2\n", verbose: 2},
+		{name: "bool", config: "verbose: true\n", verbose: 1},
+		{name: "off", config: "verbose: false\n", verbose: 0},
+		{name: "flags take precedence", config: "verbose: 2\n", args: []string{"-v"}, verbose: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := parseWithConfig(t, tt.config, func(dir string) []string {
+				return append([]string{"--input", dir}, tt.args...)
+			})
+
+			if *flags.verbose != tt.verbose {
+				t.Fatalf("expected verbosity %d, got %d", tt.verbose, *flags.verbose)
+			}
+		})
+	}
+}
+
+func TestConfigFileInputs(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		inputs []string
+	}{
+		{name: "path", config: "input: repo\n", inputs: []string{"repo"}},
+		{name: "list", config: "input: [repo, other]\n", inputs: []string{"repo", "other"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			for _, input := range []string{"repo", "other"} {
+				if err := os.Mkdir(filepath.Join(dir, input), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			filename := filepath.Join(dir, configName)
+			if err := ioutil.WriteFile(filename, []byte(tt.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			app, flags := testApp()
+
+			// run from elsewhere, so relative inputs only resolve relative
+			// to the config file
+			args, err := withConfig(app, []string{"--config", filename})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := app.Parse(args); err != nil {
+				t.Fatalf("failed to parse %v: %s", args, err)
+			}
+
+			var want []string
+			for _, input := range tt.inputs {
+				want = append(want, filepath.Join(dir, input))
+			}
+
+			if !reflect.DeepEqual(*flags.inputs, want) {
+				t.Fatalf("expected inputs %v, got %v", want, *flags.inputs)
+			}
+		})
+	}
+}
+
+func TestConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{name: "unknown option", config: "outptu: out\n", err: `unknown option "outptu" in `},
+		{name: "unsupported value", config: "output: {a: b}\n", err: `invalid option "output" in `},
+		{name: "negative count", config: "verbose: -1\n", err: `invalid option "verbose" in `},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			filename := filepath.Join(dir, configName)
+
+			if err := ioutil.WriteFile(filename, []byte(tt.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			app, _ := testApp()
+
+			_, err := withConfig(app, []string{"--input", dir})
+			if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+				t.Fatalf("expected an error starting with %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	tests := []struct {
+		values []string
+		want   []string
+	}{
+		{values: nil, want: nil},
+		{values: []string{".sql,.tmpl"}, want: []string{".sql", ".tmpl"}},
+		{values: []string{".sql", " .tmpl , ,.json"}, want: []string{".sql", ".tmpl", ".json"}},
+		{values: []string{","}, want: nil},
+	}
+
+	for _, tt := range tests {
+		if got := splitList(tt.values); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("expected %q to split into %q, got %q", tt.values, tt.want, got)
+		}
+	}
+}
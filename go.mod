@@ -1,12 +1,14 @@
 module github.com/bakins/main-combiner
 
-go 1.15
+go 1.16
 
 require (
 	github.com/alecthomas/template v0.0.0-20190718012654-fb15b899a751 // indirect
 	github.com/alecthomas/units v0.0.0-20190924025748-f65c72e2690d // indirect
 	github.com/fatih/astrewrite v0.0.0-20191207154002-9094e544fcef
-	golang.org/x/mod v0.3.0
-	golang.org/x/tools v0.0.0-20201016155721-7c89c52d2f52
+	github.com/pmezard/go-difflib v1.0.0
+	golang.org/x/mod v0.6.0
+	golang.org/x/tools v0.1.12
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
+	gopkg.in/yaml.v2 v2.4.0
 )
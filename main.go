@@ -1,345 +1,344 @@
 package main
 
 import (
-	"bytes"
+	"encoding/json"
 	"fmt"
-	"go/ast"
-	"go/format"
-	"go/parser"
-	"go/token"
 	"io/ioutil"
 	"log"
 	"os"
-	"path"
-	"path/filepath"
-	"sort"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/fatih/astrewrite"
-	"golang.org/x/mod/modfile"
+	"github.com/bakins/main-combiner/combine"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
-// TODO investigate https://pkg.go.dev/golang.org/x/tools/go/ast/astutil#Apply
-
-const mainName = "MainFunction"
+func main() {
+	log.SetFlags(0)
 
-func getModuleName(filename string) (string, error) {
-	goModBytes, err := ioutil.ReadFile(filename)
+	kingpin.Flag("config", "read flags from this YAML file instead of "+configName+" in the input directory").String()
+	inputs := kingpin.Flag("input", "input directory, a module or a workspace with a go.work; repeat to combine commands from several modules, the first is the one output is relative to").Default(".").ExistingDirs()
+	output := kingpin.Flag("output", "output directory, relative to the first input unless absolute").Default("cmd/combined").String()
+	include := kingpin.Flag("include", "if set, only include these dirctories").Default().Strings()
+	allowEmptyInclude := kingpin.Flag("allow-empty-include", "do not fail when an included directory has no main packages").Bool()
+	allowEmpty := kingpin.Flag("allow-empty", "do not fail when no main packages are found").Bool()
+	marker := kingpin.Flag("marker", "only combine main packages with a //combiner:include comment in one of their files").Bool()
+	includeTests := kingpin.Flag("include-tests", "also copy the _test.go files of each main package").Bool()
+	exclude := kingpin.Flag("exclude", "skip paths matching these glob patterns; ** matches any number of directories. Applied after the built-in ignores and takes precedence over --include").Default().Strings()
+	alwaysIgnore := kingpin.Flag("always-ignore", "never walk directories with this name at any depth, or at this path relative to each input if it has a slash, in addition to .git, vendor, .idea and .github; can be repeated").Strings()
+	copyExt := kingpin.Flag("copy-ext", "copy files with these comma separated extensions, e.g. .sql,.tmpl, from each command directory into its generated package; can be repeated").Strings()
+	allowDuplicates := kingpin.Flag("allow-duplicate-commands", "disambiguate commands with the same name by their dotted directory path instead of failing").Bool()
+	commandPrefix := kingpin.Flag("command-prefix", "strip this prefix from the binary name before matching it to a command, e.g. myorg- for symlinks named myorg-server").String()
+	groupBy := kingpin.Flag("group-by", "build one binary per directory at this depth of the command source directories, e.g. 2 dispatches cmd/admin/* from admin/ in the output directory; 0 builds a single binary").Default("0").Int()
+	maxDepth := kingpin.Flag("max-depth", "do not look for commands more than this many directories below each input; 0 walks every directory").Default("0").Int()
+	defaultCommand := kingpin.Flag("default-command", "run this command when the dispatcher is invoked as an unknown command, instead of exiting with --unknown-exit-code").String()
+	dispatcherAsLibrary := kingpin.Flag("dispatcher-as-library", "generate the dispatcher as func Run(args []string) int in this package instead of package main").String()
+	wrapProlog := kingpin.Flag("wrap-prolog", "Go statements, or a file holding them, to run before every command").String()
+	wrapEpilog := kingpin.Flag("wrap-epilog", "Go statements, or a file holding them, to run after every command that returns").String()
+	wrapImport := kingpin.Flag("wrap-import", "import this package, e.g. log, in the dispatcher for --wrap-prolog and --wrap-epilog; can be repeated").Strings()
+	wrapper := kingpin.Flag("wrapper", "keep func main of each command and call it from a generated entrypoint instead of renaming it").Bool()
+	preserveArgv0 := kingpin.Flag("preserve-argv0", "in subcommand mode, leave os.Args[0] as the path of the combined binary instead of the command name").Bool()
+	dirMode := kingpin.Flag("dir-mode", "octal permissions of the directories created in the output directory").Default("0755").String()
+	fileMode := kingpin.Flag("file-mode", "octal permissions of the files written to the output directory; the install script is also made executable").Default("0644").String()
+	force := kingpin.Flag("force", "overwrite files in the output directory that were not generated by main-combiner").Bool()
+	progress := kingpin.Flag("progress", "report how many files and main packages have been found every second while walking the input").Bool()
+	timings := kingpin.Flag("timings", "print how long each phase took").Bool()
+	standaloneTag := kingpin.Flag("standalone-tag", "guard the combined build with this build tag and keep a copy of each command that builds on its own without it").String()
+	trimSuffixes := kingpin.Flag("trim-suffix", "strip this suffix from the binary name, after .exe, before matching it to a command; can be repeated").Strings()
+	lineDirectives := kingpin.Flag("line-directives", "add //line directives so compiler errors and panics refer to the original source").Bool()
+	verify := kingpin.Flag("verify", "build the output directory after writing it and fail if it does not compile").Bool()
+	goBinary := kingpin.Flag("go-binary", "go command used by --verify").Default(combine.DefaultGoBinary).String()
+	keepGoing := kingpin.Flag("keep-going", "skip files that fail to parse and the packages they belong to, report them all and exit non-zero after writing the rest").Bool()
+	skipCommands := kingpin.Flag("skip-command", "drop the command with this name, or with this dotted source directory such as foo.server; can be repeated").Strings()
+	emitInstallScript := kingpin.Flag("emit-install-script", "write an install.sh to the output directory that symlinks every command to the combined binary").Bool()
+	emitDockerfile := kingpin.Flag("emit-dockerfile", "write a Dockerfile to the output directory that builds the combined binary and symlinks every command to it").Bool()
+	dispatch := kingpin.Flag("dispatch", "select commands by the binary name with a switch (argv0) or a registry the commands add themselves to (registry), or by the first argument (subcommand)").Default(string(combine.DispatchArgv0)).Enum(string(combine.DispatchArgv0), string(combine.DispatchSubcommand), string(combine.DispatchRegistry))
+	prefixIdentifiers := kingpin.Flag("prefix-identifiers", "prefix top-level declarations with the generated package name").Bool()
+	deferInit := kingpin.Flag("defer-init", "run init functions when their command is dispatched rather than at startup").Bool()
+	versionVar := kingpin.Flag("version-var", "initialize this top-level string variable in every command from a generated version package; set it with -ldflags \"-X <output import path>/version.Value=...\"").String()
+	emitCompletion := kingpin.Flag("emit-completion", "write a completion script for this shell to the output directory, with subcommand dispatch; can be repeated").Enums(string(combine.ShellBash), string(combine.ShellZsh), string(combine.ShellFish))
+	respectGitignore := kingpin.Flag("respect-gitignore", "skip paths ignored by .gitignore files").Bool()
+	singleFile := kingpin.Flag("single-file", "merge the files of each command into one file named after its package").Bool()
+	followSymlinks := kingpin.Flag("follow-symlinks", "walk into symlinked directories, skipping loops").Bool()
+	importPrefix := kingpin.Flag("import-prefix", "import path of the output directory; by default derived from the nearest go.mod above it").String()
+	contextEntrypoint := kingpin.Flag("context-entrypoint", "pass a context canceled on SIGINT and SIGTERM to commands declaring func main(ctx context.Context)").Bool()
+	buildTags := kingpin.Flag("build-tags", "only collect files whose build constraints are satisfied by these comma separated tags; can be repeated").Strings()
+	simplify := kingpin.Flag("simplify", "simplify generated code like gofmt -s").Bool()
+	incremental := kingpin.Flag("incremental", "only rewrite packages whose sources changed since the last run").Bool()
+	quiet := kingpin.Flag("quiet", "don't print a summary of the written output").Short('q').Bool()
+	check := kingpin.Flag("check", "list files that are out of date and exit non-zero instead of writing").Bool()
+	diff := kingpin.Flag("diff", "print a unified diff of the files that are out of date and exit non-zero instead of writing").Bool()
+	dryRun := kingpin.Flag("dry-run", "print what would be written without changing anything").Bool()
+	emitCommands := kingpin.Flag("emit-commands", "also generate a commands package in the output directory that maps every command name to its main function").Bool()
+	emitListCommand := kingpin.Flag("emit-list-command", "add a command that lists all commands, invoked as --list in subcommand mode or as <binary>-list").Bool()
+	unknownExitCode := kingpin.Flag("unknown-exit-code", "exit code of the dispatcher for an unknown command, between 1 and 125").Default(strconv.Itoa(combine.DefaultUnknownExitCode)).Int()
+	prune := kingpin.Flag("prune", "remove generated packages and files that no longer have a source").Bool()
+	entrypointName := kingpin.Flag("entrypoint-name", "exported name that main functions are renamed to").Default(combine.DefaultEntrypointName).String()
+	dispatcherTemplate := kingpin.Flag("dispatcher-template", "text/template file to generate the dispatcher from instead of the built-in template").ExistingFile()
+	appendCommands := kingpin.Flag("append", "add the commands to those of the dispatcher already in the output directory instead of replacing it").Bool()
+	dispatcherFilename := kingpin.Flag("dispatcher-filename", "name of the generated dispatcher file in the output directory").Default(combine.DefaultDispatcherFilename).String()
+	trapExit := kingpin.Flag("trap-exit", "replace os.Exit in main functions with a panic recovered by the dispatcher").Bool()
+	manifest := kingpin.Flag("manifest", "write a JSON description of the collected commands to this file").String()
+	packageNameTemplate := kingpin.Flag("output-package-name-template", "text/template for generated package names, with .Dir, .Segments, .Base and .Module").Default(combine.DefaultPackageNameTemplate).String()
+	sortBy := kingpin.Flag("sort-by", "order the commands in the dispatcher by the import path of their generated package (import) or by name (command)").Default(string(combine.SortByImportPath)).Enum(string(combine.SortByImportPath), string(combine.SortByCommand))
+	packageDoc := kingpin.Flag("package-doc", "rewrite \"Package main\" doc comments for the new package name, strip them, or keep them").Default(string(combine.PackageDocRewrite)).Enum(string(combine.PackageDocRewrite), string(combine.PackageDocStrip), string(combine.PackageDocKeep))
+	verbose := kingpin.Flag("verbose", "log skipped files and written output; repeat to log every file considered").Short('v').Counter()
+	emitGoMod := kingpin.Flag("emit-gomod", "write a go.mod to the output directory that requires and replaces the input module").Bool()
+
+	args, err := withConfig(kingpin.CommandLine, os.Args[1:])
 	if err != nil {
-		return "", err
+		kingpin.Fatalf("%s", err)
 	}
 
-	modName := modfile.ModulePath(goModBytes)
+	kingpin.MustParse(kingpin.CommandLine.Parse(args))
 
-	return modName, nil
-}
-
-type mainPackage struct {
-	command     string
-	importPath  string
-	packageName string
-	outputDir   string
-	contents    map[string][]byte
-}
-
-type combiner struct {
-	serviceDir string
-	module     string
-	outputDir  string
-	packages   map[string]*mainPackage
-	include    []string
-}
-
-func newCombiner(serviceDir string, outputDir string, include []string) (*combiner, error) {
-	serviceDir, err := filepath.Abs(serviceDir)
-	if err != nil {
-		return nil, err
+	if *unknownExitCode < 1 || *unknownExitCode > 125 {
+		kingpin.Fatalf("--unknown-exit-code must be between 1 and 125, got %d", *unknownExitCode)
 	}
 
-	outputDir, err = filepath.Abs(filepath.Join(serviceDir, outputDir))
-	if err != nil {
-		return nil, err
+	var progressInterval time.Duration
+	if *progress {
+		progressInterval = time.Second
 	}
 
-	module, err := getModuleName(filepath.Join(serviceDir, "go.mod"))
-	if err != nil {
-		return nil, fmt.Errorf("failed to get module name: %w", err)
+	var shells []combine.Shell
+	for _, shell := range *emitCompletion {
+		shells = append(shells, combine.Shell(shell))
 	}
 
-	return &combiner{
-		serviceDir: serviceDir,
-		module:     module,
-		packages:   make(map[string]*mainPackage),
-		outputDir:  outputDir,
-		include:    include,
-	}, nil
-}
-
-var alwaysIgnore = []string{
-	".git",
-	"vendor",
-	".idea",
-	".github",
-}
+	var dispatcherTemplateText string
 
-func (c *combiner) collect() error {
-	counter := 0
-
-	walkFn := func(fullPath string, info os.FileInfo, err error) error {
+	if *dispatcherTemplate != "" {
+		data, err := ioutil.ReadFile(*dispatcherTemplate)
 		if err != nil {
-			return err
+			log.Fatal(err)
 		}
 
-		relativePath := strings.TrimPrefix(strings.TrimPrefix(fullPath, c.serviceDir), "/")
-
-		if info.IsDir() {
-			for _, ignore := range alwaysIgnore {
-				if ignore == relativePath {
-					return filepath.SkipDir
-				}
-			}
+		dispatcherTemplateText = string(data)
+	}
 
-			if fullPath == c.outputDir {
-				return filepath.SkipDir
-			}
+	// --build-tags "" filters with no tags set, so tags is only nil when
+	// the flag wasn't given
+	var tags []string
 
-			return nil
+	for _, value := range *buildTags {
+		if tags == nil {
+			tags = []string{}
 		}
 
-		if len(c.include) > 0 && relativePath != "" {
-			found := false
-
-			for _, d := range c.include {
-				if strings.HasPrefix(relativePath, d+"/") {
-					found = true
-					break
-				}
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
 			}
-
-			if !found {
-				return nil
-			}
-		}
-
-		if !strings.HasSuffix(fullPath, ".go") || strings.HasSuffix(fullPath, "_test.go") {
-			return nil
-		}
-
-		ok, err := isMain(fullPath)
-		if err != nil {
-			return err
-		}
-
-		if !ok {
-			return nil
 		}
+	}
 
-		dirName := filepath.Dir(relativePath)
+	c, err := combine.New(
+		(*inputs)[0],
+		*output,
+		combine.WithInputs((*inputs)[1:]...),
+		combine.WithInclude(*include...),
+		combine.WithAllowEmptyInclude(*allowEmptyInclude),
+		combine.WithAllowEmpty(*allowEmpty),
+		combine.WithIncludeTests(*includeTests),
+		combine.WithMarker(*marker),
+		combine.WithExclude(*exclude...),
+		combine.WithAlwaysIgnore(*alwaysIgnore...),
+		combine.WithCopyExtensions(splitList(*copyExt)...),
+		combine.WithAllowDuplicateCommands(*allowDuplicates),
+		combine.WithCommandPrefix(*commandPrefix),
+		combine.WithStandaloneTag(*standaloneTag),
+		combine.WithTrimSuffixes(*trimSuffixes...),
+		combine.WithLineDirectives(*lineDirectives),
+		combine.WithGroupBy(*groupBy),
+		combine.WithMaxDepth(*maxDepth),
+		combine.WithDefaultCommand(*defaultCommand),
+		combine.WithDispatcherPackage(*dispatcherAsLibrary),
+		combine.WithWrap(readSnippet(*wrapProlog), readSnippet(*wrapEpilog)),
+		combine.WithWrapImports(*wrapImport...),
+		combine.WithWrapper(*wrapper),
+		combine.WithPreserveArgv0(*preserveArgv0),
+		combine.WithProgress(progressInterval),
+		combine.WithDirMode(parseMode("dir-mode", *dirMode)),
+		combine.WithFileMode(parseMode("file-mode", *fileMode)),
+		combine.WithForce(*force),
+		combine.WithGoBinary(*goBinary),
+		combine.WithKeepGoing(*keepGoing),
+		combine.WithSkipCommands(*skipCommands...),
+		combine.WithEmitGoMod(*emitGoMod),
+		combine.WithPrefixIdentifiers(*prefixIdentifiers),
+		combine.WithDeferInit(*deferInit),
+		combine.WithVersionVar(*versionVar),
+		combine.WithEmitInstallScript(*emitInstallScript),
+		combine.WithEmitDockerfile(*emitDockerfile),
+		combine.WithEmitListCommand(*emitListCommand),
+		combine.WithEmitCommands(*emitCommands),
+		combine.WithDispatch(combine.Dispatch(*dispatch)),
+		combine.WithUnknownExitCode(*unknownExitCode),
+		combine.WithPrune(*prune),
+		combine.WithEntrypointName(*entrypointName),
+		combine.WithEmitCompletion(shells...),
+		combine.WithRespectGitignore(*respectGitignore),
+		combine.WithSingleFile(*singleFile),
+		combine.WithFollowSymlinks(*followSymlinks),
+		combine.WithImportPrefix(*importPrefix),
+		combine.WithContextEntrypoint(*contextEntrypoint),
+		combine.WithBuildTags(tags...),
+		combine.WithSimplify(*simplify),
+		combine.WithIncremental(*incremental),
+		combine.WithDispatcherTemplate(dispatcherTemplateText),
+		combine.WithDispatcherFilename(*dispatcherFilename),
+		combine.WithAppend(*appendCommands),
+		combine.WithTrapExit(*trapExit),
+		combine.WithPackageNameTemplate(*packageNameTemplate),
+		combine.WithSortBy(combine.SortBy(*sortBy)),
+		combine.WithPackageDoc(combine.PackageDoc(*packageDoc)),
+		combine.WithLogger(log.New(os.Stderr, "", 0), *verbose),
+	)
 
-		m := c.packages[dirName]
-		if m == nil {
-			replacer := strings.NewReplacer("-", "_", "/", "_")
-			packageName := replacer.Replace(dirName)
-			importPath := path.Join(c.module, strings.TrimPrefix(c.outputDir, c.serviceDir), packageName)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-			m = &mainPackage{
-				command:     filepath.Base(dirName),
-				importPath:  importPath,
-				contents:    make(map[string][]byte),
-				packageName: packageName,
-				outputDir:   filepath.Join(c.outputDir, packageName),
-			}
+	if err := c.Collect(); err != nil {
+		log.Fatal(err)
+	}
 
-			counter++
+	if *check {
+		changed, err := c.Check()
+		if err != nil {
+			log.Fatal(err)
+		}
 
-			c.packages[dirName] = m
+		for _, filename := range changed {
+			fmt.Println(filename)
 		}
 
-		data, err := parseAndReplace(m.packageName, fullPath)
-		if err != nil {
-			return err
+		if len(changed) > 0 {
+			log.Fatal("generated output is out of date; run without --check to regenerate it")
 		}
 
-		m.contents[fullPath] = data
+		finish(c, *timings)
 
-		return nil
+		return
 	}
 
-	return filepath.Walk(c.serviceDir, walkFn)
-}
-
-func (c *combiner) output() error {
-	var outputs []*mainPackage
-
-	for _, m := range c.packages {
-		outputs = append(outputs, m)
-		if err := os.MkdirAll(m.outputDir, 0755); err != nil {
-			return err
+	if *diff {
+		changed, err := c.Diff(os.Stdout)
+		if err != nil {
+			log.Fatal(err)
 		}
 
-		for file, data := range m.contents {
-			filename := filepath.Join(m.outputDir, filepath.Base(file))
+		finish(c, *timings)
 
-			if err := ioutil.WriteFile(filename, data, 0644); err != nil {
-				return err
-			}
+		if len(changed) > 0 {
+			os.Exit(1)
 		}
-	}
 
-	sort.Slice(outputs, func(i, j int) bool {
-		return outputs[i].importPath < outputs[j].importPath
-	})
-
-	var buf bytes.Buffer
-	_, _ = buf.WriteString("package main\nimport (\n\"os\"\n\"fmt\"\n\"path/filepath\"\n\n")
-
-	for _, m := range outputs {
-		_, _ = fmt.Fprintf(&buf, "%s %q\n", m.packageName, m.importPath)
+		return
 	}
 
-	_, _ = buf.WriteString(`)
-
-func main() {
-    name := filepath.Base(os.Args[0])
-
-    switch name {
-`)
-
-	for _, m := range outputs {
-		_, _ = fmt.Fprintf(&buf, "case %q:\n%s.%s()\n", m.command, m.packageName, mainName)
+	if *manifest != "" && !*dryRun {
+		if err := writeManifest(*manifest, c.Manifest()); err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	_, _ = buf.WriteString(`
-default:
-  fmt.Fprintf(os.Stderr, "unknown command %s\n", name)
-  os.Exit(11)
-}
-}
-`)
+	if *dryRun {
+		if err := c.DryRun(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
 
-	fset := token.NewFileSet()
-	mainAST, err := parser.ParseFile(fset, "main.go", buf.Bytes(), parser.ParseComments)
-	if err != nil {
-		return err
-	}
+		finish(c, *timings)
 
-	buf.Reset()
-	if err := format.Node(&buf, fset, mainAST); err != nil {
-		return fmt.Errorf("failed to format code: %w", err)
+		return
 	}
 
-	if err := os.MkdirAll(c.outputDir, 0755); err != nil {
-		return err
+	if err := c.Write(); err != nil {
+		log.Fatal(err)
 	}
 
-	filename := filepath.Join(c.outputDir, "main.go")
-
-	return ioutil.WriteFile(filename, buf.Bytes(), 0644)
-}
-
-func isMain(filename string) (bool, error) {
-	data, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return false, err
+	if *verify {
+		if err := c.Verify(); err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	fset := token.NewFileSet()
-
-	fileAST, err := parser.ParseFile(fset, filename, data, parser.PackageClauseOnly)
-	if err != nil {
-		return false, fmt.Errorf("failed to parse %s %w", filename, err)
+	if !*quiet {
+		summary := c.Summary()
+		log.Printf("combined %d commands into %s: wrote %d files, %d bytes", summary.Commands, summary.OutputDir, summary.Files, summary.Bytes)
 	}
 
-	return fileAST.Name.Name == "main", nil
+	finish(c, *timings)
 }
 
-func parseAndReplace(packageName string, filename string) ([]byte, error) {
-	data, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return nil, err
-	}
-
-	fset := token.NewFileSet()
-	oldAST, err := parser.ParseFile(fset, filename, data, parser.ParseComments)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse %s %w", filename, err)
-	}
-
-	t := transform{
-		packageName: packageName,
+// finish prints the timings if asked to, then exits non-zero if files were
+// skipped by --keep-going. The errors themselves were logged as they were
+// found.
+func finish(c *combine.Combiner, timings bool) {
+	if timings {
+		t := c.Timings()
+		log.Printf("timings: walk %s, parse %s, rewrite %s, generate %s, write %s, total %s",
+			t.Walk.Round(time.Microsecond),
+			t.Parse.Round(time.Microsecond),
+			t.Rewrite.Round(time.Microsecond),
+			t.Generate.Round(time.Microsecond),
+			t.Write.Round(time.Microsecond),
+			t.Total().Round(time.Microsecond),
+		)
 	}
 
-	newAST := astrewrite.Walk(oldAST, t.visitor)
-
-	var buf bytes.Buffer
-	if err := format.Node(&buf, fset, newAST); err != nil {
-		return nil, fmt.Errorf("failed to format new code: %w", err)
+	if errs := c.ParseErrors(); len(errs) > 0 {
+		log.Fatalf("%d files failed to parse", len(errs))
 	}
-
-	return buf.Bytes(), nil
 }
 
-func main() {
-	log.SetFlags(0)
-
-	input := kingpin.Flag("input", "input directory").Default(".").ExistingDir()
-	output := kingpin.Flag("output", "out directory relative to input").Default("cmd/combined").String()
-	include := kingpin.Flag("include", "if set, only include these dirctories").Default().Strings()
-
-	kingpin.Parse()
-
-	c, err := newCombiner(*input, *output, *include)
-
+func writeManifest(filename string, manifest *combine.Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	if err := c.collect(); err != nil {
-		log.Fatal(err)
-	}
+	return ioutil.WriteFile(filename, append(data, '\n'), 0644)
+}
 
-	if err := c.output(); err != nil {
-		log.Fatal(err)
+// parseMode parses the octal permissions given to flag.
+func parseMode(flag string, value string) os.FileMode {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		kingpin.Fatalf("--%s must be octal permissions such as 0644, got %q", flag, value)
 	}
-}
 
-type transform struct {
-	packageName string
+	return os.FileMode(mode)
 }
 
-func (t *transform) visitor(n ast.Node) (ast.Node, bool) {
-	switch v := n.(type) {
-	case *ast.File:
-		return t.handleFile(v)
-	case *ast.FuncDecl:
-		return handleFuncDecl(v)
-	default:
-		return n, true
+// readSnippet returns the contents of the file named by value, if there is
+// one, and otherwise value itself.
+func readSnippet(value string) string {
+	if info, err := os.Stat(value); err != nil || !info.Mode().IsRegular() {
+		return value
 	}
-}
 
-func (t *transform) handleFile(f *ast.File) (ast.Node, bool) {
-	if f.Name.Name != "main" {
-		return f, false
+	data, err := ioutil.ReadFile(value)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	f.Name.Name = t.packageName
-
-	return f, true
-
+	return string(data)
 }
 
-func handleFuncDecl(fd *ast.FuncDecl) (ast.Node, bool) {
-	if fd.Recv != nil {
-		return fd, false
-	}
+// splitList splits each of values at commas, dropping empty elements.
+func splitList(values []string) []string {
+	var list []string
 
-	if fd.Name.Name != "main" {
-		return fd, false
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			if element = strings.TrimSpace(element); element != "" {
+				list = append(list, element)
+			}
+		}
 	}
 
-	fd.Name.Name = mainName
-
-	return fd, false
+	return list
 }